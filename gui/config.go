@@ -0,0 +1,51 @@
+package gui
+
+import (
+	"net"
+
+	"github.com/rhallman96/nesquack/movie"
+	"github.com/rhallman96/nesquack/netplay"
+	"github.com/rhallman96/nesquack/system"
+)
+
+// Config holds the settings for a gui session.
+type Config struct {
+	Options system.Options
+
+	// DiskDiffPath is where writes to a Famicom Disk System disk are saved,
+	// as an IPS patch against DiskOriginal, when the window is closed.
+	DiskDiffPath string
+	DiskOriginal []uint8
+
+	// TracePath is where the CPU trace is written while tracing is toggled
+	// on. Only instructions between TraceLow and TraceHigh are logged.
+	TracePath           string
+	TraceLow, TraceHigh uint16
+
+	// StatePath is where save states are kept, with the slot number
+	// appended.
+	StatePath string
+
+	// Rewind configures the rewind buffer, which is disabled if its budget
+	// is 0.
+	Rewind system.RewindConfig
+
+	// RunAhead is the number of frames to run ahead to hide input lag.
+	// Changes made with the run-ahead keys are saved to RunAheadPath.
+	RunAhead     int
+	RunAheadPath string
+
+	// PlayMovie is played back from the start, after which the keyboard
+	// takes over. Otherwise, if RecordMoviePath is set, input is recorded to
+	// it from power on, or from RecordMovieState if given.
+	PlayMovie        *movie.Movie
+	RecordMoviePath  string
+	RecordMovieState []uint8
+
+	// NetplayConn, if set, plays with the peer at NetplayRemote. Rewinding,
+	// run-ahead, movies, save states and the console keys are disabled, as
+	// they would desync the peers.
+	NetplayConn   net.PacketConn
+	NetplayRemote net.Addr
+	Netplay       netplay.Config
+}
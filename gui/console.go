@@ -0,0 +1,39 @@
+package gui
+
+import (
+	"log"
+
+	"github.com/rhallman96/nesquack/system"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	resetKey      = sdl.SCANCODE_R
+	powerCycleKey = sdl.SCANCODE_P
+)
+
+// consoleControl handles the reset button and power switch hotkeys.
+type consoleControl struct {
+	nes    system.NES
+	disk   *diskControl
+	movies *movieControl
+}
+
+func (c *consoleControl) handleKey(scancode sdl.Scancode) {
+	switch scancode {
+	case resetKey:
+		if err := c.movies.reset(c.nes); err != nil {
+			log.Printf("Failed to reset: %s", err)
+			return
+		}
+		log.Printf("Reset")
+	case powerCycleKey:
+		if err := c.movies.powerCycle(c.nes); err != nil {
+			log.Printf("Failed to power cycle: %s", err)
+			return
+		}
+		// the first disk side is inserted at power on
+		c.disk.side = 0
+		log.Printf("Power cycled")
+	}
+}
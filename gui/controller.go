@@ -1,42 +1,84 @@
 package gui
 
 import (
+	"log"
+
 	"github.com/veandco/go-sdl2/sdl"
 )
 
+// keyBindings are the keyboard scancodes of a joypad's buttons.
+type keyBindings struct {
+	up, down, left, right int
+	a, b, start, sel      int
+}
+
+var (
+	player1Keys = keyBindings{
+		up:    sdl.SCANCODE_UP,
+		down:  sdl.SCANCODE_DOWN,
+		left:  sdl.SCANCODE_LEFT,
+		right: sdl.SCANCODE_RIGHT,
+		a:     sdl.SCANCODE_X,
+		b:     sdl.SCANCODE_Z,
+		start: sdl.SCANCODE_RETURN,
+		sel:   sdl.SCANCODE_RSHIFT,
+	}
+	player2Keys = keyBindings{
+		up:    sdl.SCANCODE_I,
+		down:  sdl.SCANCODE_K,
+		left:  sdl.SCANCODE_J,
+		right: sdl.SCANCODE_L,
+		a:     sdl.SCANCODE_M,
+		b:     sdl.SCANCODE_N,
+		start: sdl.SCANCODE_O,
+		sel:   sdl.SCANCODE_U,
+	}
+)
+
+// controller reads a joypad from the keyboard and from the gamepad assigned
+// to it, if any.
 type controller struct {
+	keys keyBindings
+	pad  *sdl.GameController
 }
 
 func (c *controller) Up() bool {
-	return readKey(sdl.SCANCODE_UP)
+	return readKey(c.keys.up) || c.readButton(sdl.CONTROLLER_BUTTON_DPAD_UP)
 }
 
 func (c *controller) Down() bool {
-	return readKey(sdl.SCANCODE_DOWN)
+	return readKey(c.keys.down) || c.readButton(sdl.CONTROLLER_BUTTON_DPAD_DOWN)
 }
 
 func (c *controller) Left() bool {
-	return readKey(sdl.SCANCODE_LEFT)
+	return readKey(c.keys.left) || c.readButton(sdl.CONTROLLER_BUTTON_DPAD_LEFT)
 }
 
 func (c *controller) Right() bool {
-	return readKey(sdl.SCANCODE_RIGHT)
+	return readKey(c.keys.right) || c.readButton(sdl.CONTROLLER_BUTTON_DPAD_RIGHT)
 }
 
+// the NES A and B buttons are the right and left face buttons, which SDL
+// names B and A after the Xbox layout
+
 func (c *controller) A() bool {
-	return readKey(sdl.SCANCODE_X)
+	return readKey(c.keys.a) || c.readButton(sdl.CONTROLLER_BUTTON_B)
 }
 
 func (c *controller) B() bool {
-	return readKey(sdl.SCANCODE_Z)
+	return readKey(c.keys.b) || c.readButton(sdl.CONTROLLER_BUTTON_A)
 }
 
 func (c *controller) Start() bool {
-	return readKey(sdl.SCANCODE_RETURN)
+	return readKey(c.keys.start) || c.readButton(sdl.CONTROLLER_BUTTON_START)
 }
 
 func (c *controller) Select() bool {
-	return readKey(sdl.SCANCODE_RSHIFT)
+	return readKey(c.keys.sel) || c.readButton(sdl.CONTROLLER_BUTTON_BACK)
+}
+
+func (c *controller) readButton(b sdl.GameControllerButton) bool {
+	return c.pad != nil && c.pad.Button(b) != 0
 }
 
 func readKey(key int) bool {
@@ -46,3 +88,48 @@ func readKey(key int) bool {
 	}
 	return false
 }
+
+// gamepads assigns connected gamepads to the controllers in order, as they
+// are plugged in.
+type gamepads struct {
+	controllers []*controller
+}
+
+func (g *gamepads) handleEvent(e *sdl.ControllerDeviceEvent) {
+	switch e.Type {
+	case sdl.CONTROLLERDEVICEADDED:
+		// Which is the device index when a gamepad is added
+		index := int(e.Which)
+		if !sdl.IsGameController(index) {
+			return
+		}
+		for i, c := range g.controllers {
+			if c.pad != nil {
+				continue
+			}
+			c.pad = sdl.GameControllerOpen(index)
+			if c.pad != nil {
+				log.Printf("Gamepad %s assigned to player %d", c.pad.Name(), i+1)
+			}
+			return
+		}
+	case sdl.CONTROLLERDEVICEREMOVED:
+		// and its instance ID when it is removed
+		for i, c := range g.controllers {
+			if c.pad != nil && c.pad.Joystick().InstanceID() == e.Which {
+				log.Printf("Gamepad removed from player %d", i+1)
+				c.pad.Close()
+				c.pad = nil
+			}
+		}
+	}
+}
+
+func (g *gamepads) close() {
+	for _, c := range g.controllers {
+		if c.pad != nil {
+			c.pad.Close()
+			c.pad = nil
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package gui
+
+import (
+	"io/ioutil"
+	"log"
+
+	"github.com/rhallman96/nesquack/loader"
+	"github.com/rhallman96/nesquack/system"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	ejectDiskKey  = sdl.SCANCODE_E
+	switchDiskKey = sdl.SCANCODE_S
+)
+
+// diskControl handles the Famicom Disk System disk hotkeys. Each press of the
+// switch key inserts the next disk side.
+type diskControl struct {
+	nes    system.NES
+	movies *movieControl
+	side   int
+}
+
+func (d *diskControl) handleKey(scancode sdl.Scancode) {
+	if d.nes.DiskSides() == 0 {
+		return
+	}
+
+	switch scancode {
+	case ejectDiskKey:
+		d.movies.ejectDisk(d.nes)
+		log.Printf("Disk ejected")
+	case switchDiskKey:
+		d.side = (d.side + 1) % d.nes.DiskSides()
+		if err := d.movies.insertDisk(d.nes, d.side); err != nil {
+			log.Printf("Failed to insert disk side %d: %s", d.side, err)
+			return
+		}
+		log.Printf("Inserted disk %d side %c", (d.side/2)+1, 'A'+rune(d.side%2))
+	}
+}
+
+// saveDiskDiff writes disk changes to the configured diff file.
+func saveDiskDiff(nes system.NES, cfg Config) {
+	if nes.DiskSides() == 0 || cfg.DiskDiffPath == "" {
+		return
+	}
+
+	patch := loader.CreateIPS(cfg.DiskOriginal, nes.DiskImage())
+	if err := ioutil.WriteFile(cfg.DiskDiffPath, patch, 0644); err != nil {
+		log.Printf("Failed to save disk to %s: %s", cfg.DiskDiffPath, err)
+		return
+	}
+	log.Printf("Saved disk changes to %s", cfg.DiskDiffPath)
+}
@@ -9,7 +9,6 @@ type drawer struct {
 	renderer *sdl.Renderer
 	texture  *sdl.Texture
 	pixels   []byte
-	complete bool
 }
 
 func newDrawer(renderer *sdl.Renderer) *drawer {
@@ -34,17 +33,8 @@ func (d *drawer) DrawPixel(col, row, rgb int) {
 	d.pixels[i+2] = byte(rgb & 0xff)        // blue
 }
 
-func (d *drawer) CompleteFrame() {
-	d.complete = true
-}
-
-func (d *drawer) checkComplete() bool {
-	if d.complete {
-		d.complete = false
-		return true
-	}
-	return false
-}
+// CompleteFrame does nothing, as frames are presented once RunFrame returns.
+func (d *drawer) CompleteFrame() {}
 
 func (d *drawer) present() {
 	d.texture.Update(nil, d.pixels, system.DrawWidth*3)
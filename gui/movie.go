@@ -0,0 +1,143 @@
+package gui
+
+import (
+	"bytes"
+	"log"
+	"os"
+
+	"github.com/rhallman96/nesquack/movie"
+	"github.com/rhallman96/nesquack/system"
+)
+
+// movieControl records or plays back a movie, taking over input from the
+// keyboard controller.
+type movieControl struct {
+	cfg      Config
+	recorder *movie.Recorder
+	player   *movie.Player
+	verified bool
+}
+
+// newMovieControl returns the controllers to pass to the NES, which record
+// or play back through live as configured.
+func newMovieControl(live [2]system.Controller, cfg Config) (*movieControl, [2]system.Controller) {
+	m := &movieControl{cfg: cfg}
+	switch {
+	case cfg.PlayMovie != nil:
+		m.player = movie.NewPlayer(cfg.PlayMovie, live[0], live[1])
+		return m, [2]system.Controller{m.player.Controller(0), m.player.Controller(1)}
+	case cfg.RecordMoviePath != "":
+		m.recorder = movie.NewRecorder(live[0], live[1])
+		return m, [2]system.Controller{m.recorder.Controller(0), m.recorder.Controller(1)}
+	}
+	return m, live
+}
+
+// begin puts the NES in the movie's starting state.
+func (m *movieControl) begin(nes system.NES, rom []uint8) error {
+	switch {
+	case m.player != nil:
+		log.Printf("Playing movie of %d frames", len(m.cfg.PlayMovie.Frames))
+		return m.player.Begin(nes)
+	case m.recorder != nil:
+		fromState := m.cfg.RecordMovieState != nil
+		if fromState {
+			if err := nes.LoadState(bytes.NewReader(m.cfg.RecordMovieState)); err != nil {
+				return err
+			}
+		}
+		log.Printf("Recording movie to %s", m.cfg.RecordMoviePath)
+		return m.recorder.Begin(nes, rom, m.options(), fromState)
+	}
+	return nil
+}
+
+// options returns the options to create the NES with, which are those a
+// played back movie was recorded with.
+func (m *movieControl) options() system.Options {
+	if m.player != nil {
+		return m.cfg.PlayMovie.ApplyOptions(m.cfg.Options)
+	}
+	return m.cfg.Options
+}
+
+// active indicates if frames are run by the movie rather than by rewind.
+func (m *movieControl) active() bool {
+	return m.recorder != nil || m.player != nil
+}
+
+func (m *movieControl) runFrame() error {
+	if m.recorder != nil {
+		return m.recorder.RunFrame()
+	}
+
+	if err := m.player.RunFrame(); err != nil {
+		return err
+	}
+	if m.player.Done() && !m.verified {
+		m.verified = true
+		if err := m.player.Verify(); err != nil {
+			log.Printf("Movie ended: %s", err)
+		} else {
+			log.Printf("Movie ended after %d frames and matched its checksum", m.player.Frame())
+		}
+	}
+	return nil
+}
+
+// reset presses the reset button, recording it if a movie is being recorded.
+func (m *movieControl) reset(nes system.NES) error {
+	if m.recorder != nil {
+		return m.recorder.Reset()
+	}
+	return nes.Reset()
+}
+
+// powerCycle power cycles the console, recording it if a movie is being
+// recorded.
+func (m *movieControl) powerCycle(nes system.NES) error {
+	if m.recorder != nil {
+		return m.recorder.PowerCycle()
+	}
+	return nes.PowerCycle()
+}
+
+// insertDisk inserts a disk side, recording it if a movie is being recorded.
+func (m *movieControl) insertDisk(nes system.NES, side int) error {
+	if m.recorder != nil {
+		return m.recorder.InsertDisk(side)
+	}
+	return nes.InsertDisk(side)
+}
+
+// ejectDisk ejects the disk, recording it if a movie is being recorded.
+func (m *movieControl) ejectDisk(nes system.NES) {
+	if m.recorder != nil {
+		m.recorder.EjectDisk()
+		return
+	}
+	nes.EjectDisk()
+}
+
+// finish saves the recorded movie.
+func (m *movieControl) finish() {
+	if m.recorder == nil {
+		return
+	}
+	recorded, err := m.recorder.Finish()
+	if err != nil {
+		log.Printf("Failed to finish movie: %s", err)
+		return
+	}
+	f, err := os.Create(m.cfg.RecordMoviePath)
+	if err != nil {
+		log.Printf("Failed to save movie to %s: %s", m.cfg.RecordMoviePath, err)
+		return
+	}
+	defer f.Close()
+	if err := recorded.Write(f); err != nil {
+		log.Printf("Failed to save movie to %s: %s", m.cfg.RecordMoviePath, err)
+		return
+	}
+	log.Printf("Saved movie of %d frames to %s", len(recorded.Frames), m.cfg.RecordMoviePath)
+}
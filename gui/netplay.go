@@ -0,0 +1,50 @@
+package gui
+
+import (
+	"log"
+
+	"github.com/rhallman96/nesquack/netplay"
+	"github.com/rhallman96/nesquack/system"
+)
+
+// netplayControl runs frames through a netplay session when one is
+// configured.
+type netplayControl struct {
+	cfg     Config
+	session *netplay.Session
+}
+
+// newNetplayControl returns the controllers to pass to the NES. During
+// netplay, the first live controller drives the local player's port and the
+// other player drives the other.
+func newNetplayControl(live [2]system.Controller, cfg Config) (*netplayControl, [2]system.Controller, error) {
+	n := &netplayControl{cfg: cfg}
+	if cfg.NetplayConn == nil {
+		return n, live, nil
+	}
+	s, err := netplay.NewSession(cfg.NetplayConn, cfg.NetplayRemote, live[0], cfg.Netplay)
+	if err != nil {
+		return nil, live, err
+	}
+	n.session = s
+	return n, [2]system.Controller{s.Controller(0), s.Controller(1)}, nil
+}
+
+// begin starts the session from the NES's power on state.
+func (n *netplayControl) begin(nes system.NES) error {
+	if n.session == nil {
+		return nil
+	}
+	log.Printf("Playing netplay as player %d with %s", n.cfg.Netplay.Player+1, n.cfg.NetplayRemote)
+	return n.session.Start(nes)
+}
+
+// active indicates if frames are run by the session.
+func (n *netplayControl) active() bool {
+	return n.session != nil
+}
+
+func (n *netplayControl) runFrame() error {
+	_, err := n.session.AdvanceFrame()
+	return err
+}
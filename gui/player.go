@@ -0,0 +1,58 @@
+package gui
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/rhallman96/nesquack/system"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	prevTrackKey = sdl.SCANCODE_PAGEUP
+	nextTrackKey = sdl.SCANCODE_PAGEDOWN
+)
+
+// trackSelector switches NSF tracks with page up and page down, leaving the
+// arrow keys to the joypad, and shows the current track in the window title.
+type trackSelector struct {
+	nes    system.NES
+	window *sdl.Window
+}
+
+func (t *trackSelector) handleKey(scancode sdl.Scancode) {
+	info := t.nes.NSFInfo()
+	if info == nil {
+		return
+	}
+
+	track := info.Track
+	switch scancode {
+	case prevTrackKey:
+		track = (track + info.Tracks - 1) % info.Tracks
+	case nextTrackKey:
+		track = (track + 1) % info.Tracks
+	default:
+		return
+	}
+
+	if err := t.nes.SelectTrack(track); err != nil {
+		log.Printf("Failed to select track %d: %s", track+1, err)
+		return
+	}
+	t.updateTitle()
+}
+
+func (t *trackSelector) updateTitle() {
+	info := t.nes.NSFInfo()
+	if info == nil {
+		return
+	}
+
+	title := fmt.Sprintf("%s - track %d/%d", info.Title, info.Track+1, info.Tracks)
+	if info.Track < len(info.TrackTitles) && info.TrackTitles[info.Track] != "" {
+		title += ": " + info.TrackTitles[info.Track]
+	}
+	t.window.SetTitle(title)
+	log.Printf("Playing %s", title)
+}
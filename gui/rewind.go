@@ -0,0 +1,41 @@
+package gui
+
+import (
+	"github.com/rhallman96/nesquack/system"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const rewindKey = sdl.SCANCODE_BACKSPACE
+
+// rewindControl plays the game backwards while the rewind key is held.
+type rewindControl struct {
+	nes    system.NES
+	ahead  *system.RunAhead
+	buffer *system.RewindBuffer
+}
+
+func newRewindControl(nes system.NES, ahead *system.RunAhead, cfg system.RewindConfig) *rewindControl {
+	r := &rewindControl{nes: nes, ahead: ahead}
+	if cfg.Budget > 0 {
+		r.buffer = system.NewRewindBuffer(nes, cfg)
+	}
+	return r
+}
+
+// runFrame runs the next frame with run-ahead, or while rewinding, steps back
+// to the previous frame. The game is paused once the buffer runs out.
+func (r *rewindControl) runFrame() error {
+	if r.buffer == nil {
+		return r.ahead.RunFrame()
+	}
+
+	if readKey(rewindKey) {
+		_, err := r.buffer.RewindFrame()
+		return err
+	}
+
+	if err := r.ahead.RunFrame(); err != nil {
+		return err
+	}
+	return r.buffer.Push()
+}
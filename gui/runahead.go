@@ -0,0 +1,48 @@
+package gui
+
+import (
+	"io/ioutil"
+	"log"
+	"strconv"
+
+	"github.com/rhallman96/nesquack/system"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	lessRunAheadKey = sdl.SCANCODE_LEFTBRACKET
+	moreRunAheadKey = sdl.SCANCODE_RIGHTBRACKET
+
+	maxRunAhead = 4
+)
+
+// runAheadControl adjusts the number of run-ahead frames, which is saved for
+// the ROM.
+type runAheadControl struct {
+	ahead *system.RunAhead
+	path  string
+}
+
+func (r *runAheadControl) handleKey(scancode sdl.Scancode) {
+	frames := r.ahead.Frames
+	switch scancode {
+	case lessRunAheadKey:
+		frames--
+	case moreRunAheadKey:
+		frames++
+	default:
+		return
+	}
+	if frames < 0 || frames > maxRunAhead {
+		return
+	}
+
+	r.ahead.Frames = frames
+	log.Printf("Run-ahead set to %d frames", frames)
+	if r.path == "" {
+		return
+	}
+	if err := ioutil.WriteFile(r.path, []byte(strconv.Itoa(frames)+"\n"), 0644); err != nil {
+		log.Printf("Failed to save run-ahead setting to %s: %s", r.path, err)
+	}
+}
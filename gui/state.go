@@ -0,0 +1,62 @@
+package gui
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/rhallman96/nesquack/system"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const (
+	firstSlotKey = sdl.SCANCODE_F1
+	lastSlotKey  = sdl.SCANCODE_F10
+)
+
+// stateControl loads save states from numbered slots with F1 - F10, and
+// saves them when shift is held. Loads are disabled while a movie is active,
+// as movies cannot record them.
+type stateControl struct {
+	nes    system.NES
+	path   string
+	movies *movieControl
+}
+
+func (s *stateControl) handleKey(scancode sdl.Scancode, mod uint16) {
+	if scancode < firstSlotKey || scancode > lastSlotKey || s.path == "" {
+		return
+	}
+	slot := int(scancode-firstSlotKey) + 1
+	path := fmt.Sprintf("%s%d", s.path, slot)
+
+	if mod&sdl.KMOD_SHIFT != 0 {
+		var b bytes.Buffer
+		if err := s.nes.SaveState(&b); err != nil {
+			log.Printf("Failed to save state: %s", err)
+			return
+		}
+		if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+			log.Printf("Failed to save state to %s: %s", path, err)
+			return
+		}
+		log.Printf("Saved state %d", slot)
+		return
+	}
+
+	if s.movies.active() {
+		log.Printf("Save states cannot be loaded during a movie")
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to load state %d: %s", slot, err)
+		return
+	}
+	if err := s.nes.LoadState(bytes.NewReader(data)); err != nil {
+		log.Printf("Failed to load state %d: %s", slot, err)
+		return
+	}
+	log.Printf("Loaded state %d", slot)
+}
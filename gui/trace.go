@@ -0,0 +1,57 @@
+package gui
+
+import (
+	"bufio"
+	"log"
+	"os"
+
+	"github.com/rhallman96/nesquack/system"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+const traceKey = sdl.SCANCODE_T
+
+// traceControl toggles the CPU trace log. The log file is created the first
+// time tracing is enabled, and later traces are appended to it.
+type traceControl struct {
+	nes     system.NES
+	cfg     Config
+	file    *os.File
+	w       *bufio.Writer
+	enabled bool
+}
+
+func (t *traceControl) handleKey(scancode sdl.Scancode) {
+	if scancode != traceKey || t.cfg.TracePath == "" {
+		return
+	}
+
+	if t.enabled {
+		t.nes.SetTrace(nil, 0, 0)
+		t.w.Flush()
+		t.enabled = false
+		log.Printf("Trace stopped")
+		return
+	}
+
+	if t.file == nil {
+		f, err := os.Create(t.cfg.TracePath)
+		if err != nil {
+			log.Printf("Failed to create trace file %s: %s", t.cfg.TracePath, err)
+			return
+		}
+		t.file = f
+		t.w = bufio.NewWriter(f)
+	}
+	t.nes.SetTrace(t.w, t.cfg.TraceLow, t.cfg.TraceHigh)
+	t.enabled = true
+	log.Printf("Tracing to %s", t.cfg.TracePath)
+}
+
+func (t *traceControl) close() {
+	if t.file == nil {
+		return
+	}
+	t.w.Flush()
+	t.file.Close()
+}
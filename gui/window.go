@@ -1,6 +1,8 @@
 package gui
 
 import (
+	"log"
+
 	"github.com/rhallman96/nesquack/system"
 	"github.com/veandco/go-sdl2/sdl"
 )
@@ -10,7 +12,8 @@ const (
 	height = 480
 )
 
-func Launch(rom []uint8) {
+// Launch opens the emulator window and runs the ROM until the window is closed.
+func Launch(rom []uint8, cfg Config) {
 	if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
 		panic(err)
 	}
@@ -31,30 +34,84 @@ func Launch(rom []uint8) {
 	renderer.SetLogicalSize(system.DrawWidth, system.DrawHeight)
 	renderer.Clear()
 
-	j1 := &controller{}
+	j1 := &controller{keys: player1Keys}
+	j2 := &controller{keys: player2Keys}
+	pads := &gamepads{controllers: []*controller{j1, j2}}
+	defer pads.close()
 
 	drawer := newDrawer(renderer)
 	defer drawer.destroy()
 
-	nes, err := system.NewNES(rom, drawer, j1)
+	movies, input := newMovieControl([2]system.Controller{j1, j2}, cfg)
+	net, input, err := newNetplayControl(input, cfg)
+	if err != nil {
+		panic(err)
+	}
+	nes, err := system.NewNESWithControllers(rom, drawer, input[0], input[1], movies.options())
 	if err != nil {
 		panic(err)
 	}
+	if region := nes.Region(); region != system.RegionNTSC {
+		log.Printf("Game is for the %s console; only NTSC timing is emulated", region)
+	}
+	if err := net.begin(nes); err != nil {
+		panic(err)
+	}
+	if err := movies.begin(nes, rom); err != nil {
+		panic(err)
+	}
+	defer movies.finish()
+
+	defer saveDiskDiff(nes, cfg)
+	disk := &diskControl{nes: nes, movies: movies}
+	tracks := &trackSelector{nes: nes, window: window}
+	tracks.updateTitle()
+	console := &consoleControl{nes: nes, disk: disk, movies: movies}
+	trace := &traceControl{nes: nes, cfg: cfg}
+	states := &stateControl{nes: nes, path: cfg.StatePath, movies: movies}
+	ahead := &runAheadControl{ahead: system.NewRunAhead(nes, cfg.RunAhead), path: cfg.RunAheadPath}
+	rewind := newRewindControl(nes, ahead.ahead, cfg.Rewind)
+	defer trace.close()
 
 	running := true
 	for running {
 		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
-			switch event.(type) {
+			switch e := event.(type) {
 			case *sdl.QuitEvent:
 				running = false
 				break
+			case *sdl.ControllerDeviceEvent:
+				pads.handleEvent(e)
+			case *sdl.KeyboardEvent:
+				if e.State == sdl.PRESSED && e.Repeat == 0 && net.active() {
+					trace.handleKey(e.Keysym.Scancode)
+				} else if e.State == sdl.PRESSED && e.Repeat == 0 {
+					disk.handleKey(e.Keysym.Scancode)
+					tracks.handleKey(e.Keysym.Scancode)
+					console.handleKey(e.Keysym.Scancode)
+					trace.handleKey(e.Keysym.Scancode)
+					states.handleKey(e.Keysym.Scancode, e.Keysym.Mod)
+					// movies run frames without run-ahead
+					if !movies.active() {
+						ahead.handleKey(e.Keysym.Scancode)
+					}
+				}
 			}
 		}
-		for !drawer.checkComplete() {
-			err := nes.Step()
-			if err != nil {
-				panic(err)
+		if net.active() {
+			if err := net.runFrame(); err != nil {
+				log.Printf("Netplay stopped: %s", err)
+				running = false
 			}
+			drawer.present()
+			continue
+		}
+		run := rewind.runFrame
+		if movies.active() {
+			run = movies.runFrame
+		}
+		if err := run(); err != nil {
+			panic(err)
 		}
 		drawer.present()
 	}
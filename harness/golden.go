@@ -0,0 +1,102 @@
+package harness
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+
+	"github.com/rhallman96/nesquack/system"
+)
+
+// RunFrames runs a ROM for the script's frame count with its input, and
+// returns the last frame drawn.
+func RunFrames(rom []uint8, script *Script, opts system.Options) (*image.RGBA, error) {
+	input := NewScriptController(script)
+	nes, err := system.NewNESWithOptions(rom, nil, input, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	frames := 0
+	if script != nil {
+		frames = script.Frames
+	}
+	for i := 0; i < frames; i++ {
+		if err := nes.RunFrame(); err != nil {
+			return nil, errors.New(fmt.Sprintf("frame %d: %s", i, err))
+		}
+		input.Advance()
+	}
+	return nes.Framebuffer(), nil
+}
+
+// CompareGolden compares a frame against a golden PNG. On a mismatch, an
+// image marking the differing pixels in red is written to diffPath, if set.
+func CompareGolden(frame image.Image, goldenPath, diffPath string) error {
+	golden, err := readPNG(goldenPath)
+	if err != nil {
+		return err
+	}
+	if frame.Bounds() != golden.Bounds() {
+		return errors.New(fmt.Sprintf("frame is %v but %s is %v", frame.Bounds().Size(),
+			goldenPath, golden.Bounds().Size()))
+	}
+
+	diff, n := diffImages(frame, golden)
+	if n == 0 {
+		return nil
+	}
+	if diffPath != "" {
+		if err := WritePNG(diffPath, diff); err != nil {
+			return err
+		}
+	}
+	return errors.New(fmt.Sprintf("%d pixels differ from %s", n, goldenPath))
+}
+
+// diffImages returns an image with the differing pixels in red over a
+// dimmed copy of the golden image, and the number of differing pixels.
+func diffImages(frame, golden image.Image) (*image.RGBA, int) {
+	bounds := golden.Bounds()
+	diff := image.NewRGBA(bounds)
+	n := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			want := color.RGBAModel.Convert(golden.At(x, y)).(color.RGBA)
+			got := color.RGBAModel.Convert(frame.At(x, y)).(color.RGBA)
+			if got == want {
+				gray := uint8((uint16(want.R) + uint16(want.G) + uint16(want.B)) / 9)
+				diff.SetRGBA(x, y, color.RGBA{gray, gray, gray, 0xff})
+				continue
+			}
+			diff.SetRGBA(x, y, color.RGBA{0xff, 0, 0, 0xff})
+			n++
+		}
+	}
+	return diff, n
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// WritePNG writes an image to a PNG file.
+func WritePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
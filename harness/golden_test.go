@@ -0,0 +1,113 @@
+package harness
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rhallman96/nesquack/internal/testrom"
+	"github.com/rhallman96/nesquack/system"
+)
+
+var update = flag.Bool("update", false, "rewrite golden frames instead of comparing them")
+
+const goldenDir = "testdata/golden"
+
+// TestGolden runs each ROM in testdata/golden with the script of the same
+// name, and compares the last frame against its golden PNG. A diff image is
+// written next to the golden on a mismatch.
+func TestGolden(t *testing.T) {
+	scripts, err := filepath.Glob(filepath.Join(goldenDir, "*.script"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		t.Fatalf("no scripts in %s", goldenDir)
+	}
+
+	for _, path := range scripts {
+		base := strings.TrimSuffix(path, ".script")
+		t.Run(filepath.Base(base), func(t *testing.T) {
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			script, err := ParseScript(f)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rom, err := ioutil.ReadFile(base + ".nes")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			frame, err := RunFrames(rom, script, system.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *update {
+				if err := WritePNG(base+".png", frame); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err := CompareGolden(frame, base+".png", base+".diff.png"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCompareGolden(t *testing.T) {
+	// set the backdrop color to $16 through PPUADDR and PPUDATA
+	rom := testrom.NROM(testrom.Spin([]uint8{
+		0xa9, 0x3f, 0x8d, 0x06, 0x20, // LDA #$3F, STA $2006
+		0xa9, 0x00, 0x8d, 0x06, 0x20, // LDA #$00, STA $2006
+		0xa9, 0x16, 0x8d, 0x07, 0x20, // LDA #$16, STA $2007
+	}))
+	script, err := ParseScript(strings.NewReader("frames 3\n0 start\n1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := RunFrames(rom, script, system.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	golden := filepath.Join(dir, "backdrop.png")
+	diff := filepath.Join(dir, "backdrop.diff.png")
+
+	want := image.NewRGBA(frame.Bounds())
+	for i := 0; i < len(want.Pix); i += 4 {
+		copy(want.Pix[i:], []uint8{0xdb, 0x2b, 0x00, 0xff})
+	}
+	if err := WritePNG(golden, want); err != nil {
+		t.Fatal(err)
+	}
+	if err := CompareGolden(frame, golden, diff); err != nil {
+		t.Fatal(err)
+	}
+
+	frame.SetRGBA(10, 20, color.RGBA{0, 0, 0, 0xff})
+	if err := CompareGolden(frame, golden, diff); err == nil {
+		t.Fatal("expected a mismatch")
+	}
+	img, err := readPNG(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := img.At(10, 20).RGBA(); r != 0xffff || g != 0 || b != 0 {
+		t.Errorf("differing pixel not marked in diff image")
+	}
+}
@@ -0,0 +1,143 @@
+// Package harness runs test ROMs headlessly and reports their results.
+//
+// Test ROMs written by blargg report through PRG RAM: $6000 holds the
+// status, $6001-$6003 hold the signature DE B0 61 once the status is valid,
+// and a null-terminated message starts at $6004. A status of $80 means the
+// test is running, $81 asks for the reset button to be pressed, and
+// anything lower is the result code, where 0 is a pass.
+package harness
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rhallman96/nesquack/system"
+)
+
+const (
+	statusAddr    = 0x6000
+	signatureAddr = 0x6001
+	messageAddr   = 0x6004
+	messageMax    = 0x1000
+
+	statusRunning    = 0x80
+	statusResetAsked = 0x81
+
+	// frames to hold off before pressing reset, as the ROMs require at least
+	// 100ms
+	resetDelayFrames = 6
+
+	// DefaultTimeout is the number of frames a ROM may run before it fails.
+	DefaultTimeout = 60 * 60
+)
+
+var signature = [3]uint8{0xde, 0xb0, 0x61}
+
+// Config configures a test run.
+type Config struct {
+	Options system.Options
+
+	// Timeout is the number of frames to run before giving up. Zero selects
+	// DefaultTimeout.
+	Timeout int
+}
+
+// Result is the outcome of running a test ROM.
+type Result struct {
+	Name    string  `json:"name"`
+	Passed  bool    `json:"passed"`
+	Code    int     `json:"code"`
+	Message string  `json:"message"`
+	Frames  int     `json:"frames"`
+	Seconds float64 `json:"seconds"`
+
+	// Error describes why a ROM failed without posting a result, such as a
+	// timeout or an emulation error.
+	Error string `json:"error,omitempty"`
+}
+
+// noCodePosted is the result code of ROMs that never posted one.
+const noCodePosted = -1
+
+// Run runs a test ROM until it posts a result or the timeout is reached.
+func Run(name string, rom []uint8, cfg Config) Result {
+	start := time.Now()
+	r := run(name, rom, cfg)
+	r.Seconds = time.Since(start).Seconds()
+	return r
+}
+
+func run(name string, rom []uint8, cfg Config) Result {
+	r := Result{Name: name, Code: noCodePosted}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	nes, err := system.NewNESWithOptions(rom, nil, NewScriptController(nil), cfg.Options)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	resetAt := -1
+	for r.Frames = 0; r.Frames < timeout; r.Frames++ {
+		if err := nes.RunFrame(); err != nil {
+			r.Error = err.Error()
+			r.Message = readMessage(nes)
+			return r
+		}
+
+		if !hasSignature(nes) {
+			continue
+		}
+		switch status := nes.ReadMemory(statusAddr); {
+		case status == statusRunning:
+		case status == statusResetAsked:
+			if resetAt < 0 {
+				resetAt = r.Frames + resetDelayFrames
+			}
+			if r.Frames >= resetAt {
+				if err := nes.Reset(); err != nil {
+					r.Error = err.Error()
+					return r
+				}
+				resetAt = -1
+			}
+		case status < statusRunning:
+			r.Code = int(status)
+			r.Passed = status == 0
+			r.Message = readMessage(nes)
+			return r
+		}
+	}
+
+	r.Error = fmt.Sprintf("timed out after %d frames", timeout)
+	r.Message = readMessage(nes)
+	return r
+}
+
+func hasSignature(nes system.NES) bool {
+	for i, v := range signature {
+		if nes.ReadMemory(signatureAddr+uint16(i)) != v {
+			return false
+		}
+	}
+	return true
+}
+
+func readMessage(nes system.NES) string {
+	if !hasSignature(nes) {
+		return ""
+	}
+	var text []byte
+	for a := uint16(messageAddr); a < messageAddr+messageMax; a++ {
+		v := nes.ReadMemory(a)
+		if v == 0 {
+			break
+		}
+		text = append(text, v)
+	}
+	return string(text)
+}
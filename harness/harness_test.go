@@ -0,0 +1,71 @@
+package harness
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/rhallman96/nesquack/internal/testrom"
+)
+
+// buildROM assembles an NROM image that posts a result through PRG RAM, or
+// spins forever if post is false.
+func buildROM(post bool, code uint8, message string) []uint8 {
+	var program []uint8
+	store := func(a uint16, v uint8) {
+		// LDA #v, STA a
+		program = append(program, 0xa9, v, 0x8d, uint8(a), uint8(a>>8))
+	}
+	if post {
+		for i, v := range signature {
+			store(signatureAddr+uint16(i), v)
+		}
+		for i := 0; i < len(message); i++ {
+			store(messageAddr+uint16(i), message[i])
+		}
+		store(messageAddr+uint16(len(message)), 0)
+		store(statusAddr, code)
+	}
+	return testrom.NROM(testrom.Spin(program))
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		rom     []uint8
+		passed  bool
+		code    int
+		message string
+		timeout bool
+	}{
+		{"pass", buildROM(true, 0, "Passed"), true, 0, "Passed", false},
+		{"fail", buildROM(true, 3, "Failed #3"), false, 3, "Failed #3", false},
+		{"timeout", buildROM(false, 0, ""), false, noCodePosted, "", true},
+	}
+
+	for _, tt := range tests {
+		r := Run(tt.name, tt.rom, Config{Timeout: 10})
+		if r.Passed != tt.passed || r.Code != tt.code || r.Message != tt.message || (r.Error != "") != tt.timeout {
+			t.Errorf("%s: got %+v", tt.name, r)
+		}
+	}
+}
+
+// TestCPUInterrupts runs blargg's cpu_interrupts_v2, which checks CLI/SEI/PLP
+// latency, NMI/IRQ hijacking of BRK and branch-delayed interrupts. The ROM is
+// not redistributed with the repository, so the test skips without it.
+func TestCPUInterrupts(t *testing.T) {
+	const path = "testdata/cpu_interrupts.nes"
+	rom, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		t.Skipf("%s is missing", path)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := Run("cpu_interrupts", rom, Config{})
+	if !r.Passed {
+		t.Errorf("got %+v", r)
+	}
+}
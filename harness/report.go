@@ -0,0 +1,74 @@
+package harness
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes results as a JUnit XML test suite. ROMs that post a
+// failing code are failures, and ROMs that time out or crash are errors.
+func WriteJUnit(w io.Writer, suite string, results []Result) error {
+	s := junitSuite{Name: suite, Tests: len(results)}
+	for _, r := range results {
+		c := junitCase{
+			Name:      r.Name,
+			ClassName: suite,
+			Time:      r.Seconds,
+			SystemOut: r.Message,
+		}
+		switch {
+		case r.Error != "":
+			c.Error = &junitProblem{Message: r.Error, Text: r.Message}
+			s.Errors++
+		case !r.Passed:
+			c.Failure = &junitProblem{Message: fmt.Sprintf("result code %d", r.Code), Text: r.Message}
+			s.Failures++
+		}
+		s.Time += r.Seconds
+		s.Cases = append(s.Cases, c)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	if err := e.Encode(s); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteJSON writes results as a JSON array.
+func WriteJSON(w io.Writer, results []Result) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(results)
+}
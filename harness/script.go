@@ -0,0 +1,119 @@
+package harness
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rhallman96/nesquack/system"
+)
+
+var buttonNames = map[string]system.Buttons{
+	"a":      system.ButtonA,
+	"b":      system.ButtonB,
+	"select": system.ButtonSelect,
+	"start":  system.ButtonStart,
+	"up":     system.ButtonUp,
+	"down":   system.ButtonDown,
+	"left":   system.ButtonLeft,
+	"right":  system.ButtonRight,
+}
+
+// Script is a timeline of controller input. Each line of a script is either
+// "frames N", giving the number of frames to run, or a frame number followed
+// by the buttons held from that frame on, e.g. "60 start" or "62" to release
+// everything. Lines starting with # are comments.
+type Script struct {
+	Frames int
+	events []scriptEvent
+}
+
+type scriptEvent struct {
+	frame   int
+	buttons system.Buttons
+}
+
+// ParseScript reads a script.
+func ParseScript(r io.Reader) (*Script, error) {
+	s := &Script{}
+	lines := bufio.NewScanner(r)
+	for n := 1; lines.Scan(); n++ {
+		fields := strings.Fields(lines.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if fields[0] == "frames" {
+			if len(fields) != 2 {
+				return nil, errors.New(fmt.Sprintf("line %d: frames takes one count", n))
+			}
+			frames, err := strconv.Atoi(fields[1])
+			if err != nil || frames < 0 {
+				return nil, errors.New(fmt.Sprintf("line %d: invalid frame count %s", n, fields[1]))
+			}
+			s.Frames = frames
+			continue
+		}
+
+		frame, err := strconv.Atoi(fields[0])
+		if err != nil || frame < 0 {
+			return nil, errors.New(fmt.Sprintf("line %d: invalid frame %s", n, fields[0]))
+		}
+		e := scriptEvent{frame: frame}
+		for _, name := range fields[1:] {
+			b, ok := buttonNames[strings.ToLower(name)]
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("line %d: unknown button %s", n, name))
+			}
+			e.buttons |= b
+		}
+		s.events = append(s.events, e)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(s.events, func(i, j int) bool {
+		return s.events[i].frame < s.events[j].frame
+	})
+	return s, nil
+}
+
+// Buttons returns the buttons held on a frame.
+func (s *Script) Buttons(frame int) system.Buttons {
+	var b system.Buttons
+	for _, e := range s.events {
+		if e.frame > frame {
+			break
+		}
+		b = e.buttons
+	}
+	return b
+}
+
+// ScriptController is a Controller that plays back a script. Advance must be
+// called after each frame.
+type ScriptController struct {
+	system.Buttons
+	script *Script
+	frame  int
+}
+
+// NewScriptController returns a controller positioned at frame 0 of s. A nil
+// script holds no buttons.
+func NewScriptController(s *Script) *ScriptController {
+	if s == nil {
+		s = &Script{}
+	}
+	return &ScriptController{Buttons: s.Buttons(0), script: s}
+}
+
+// Advance moves the controller to the next frame.
+func (c *ScriptController) Advance() {
+	c.frame++
+	c.Buttons = c.script.Buttons(c.frame)
+}
@@ -0,0 +1,78 @@
+// Package testrom builds small iNES images for tests.
+package testrom
+
+const (
+	// Origin is where programs are placed and where the reset vector points.
+	Origin = 0xc000
+
+	// vectors at the top of the address space
+	NMIVector   = 0xfffa
+	ResetVector = 0xfffc
+	IRQVector   = 0xfffe
+
+	headerSize  = 16
+	trainerSize = 512
+	prgSize     = 0x4000
+	chrSize     = 0x2000
+)
+
+// Build returns an iNES image for mapper with one 16 KB PRG ROM bank, which
+// holds program at Origin, and one 8 KB CHR ROM bank. The trainer is omitted
+// if nil.
+func Build(mapper uint8, trainer, program []uint8) []uint8 {
+	rom := []uint8{'N', 'E', 'S', 0x1a, 1, 1, mapper << 4, mapper & 0xf0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if trainer != nil {
+		rom[6] |= 0x04
+		rom = append(rom, trainer...)
+	}
+
+	prg := make([]uint8, prgSize)
+	copy(prg, program)
+	rom = append(rom, prg...)
+	rom = append(rom, make([]uint8, chrSize)...)
+	SetVector(rom, ResetVector, Origin)
+	return rom
+}
+
+// NROM returns an NROM image running program from Origin.
+func NROM(program []uint8) []uint8 {
+	return Build(0, nil, program)
+}
+
+// Spin returns program followed by a jump to itself, so that it stops without
+// running into empty memory.
+func Spin(program []uint8) []uint8 {
+	a := Origin + len(program)
+	return append(append([]uint8{}, program...), 0x4c, uint8(a), uint8(a>>8))
+}
+
+// SetVector points an interrupt vector of an image built by Build at addr.
+func SetVector(rom []uint8, vector, addr uint16) {
+	i := headerSize + int(vector-Origin)
+	if rom[6]&0x04 != 0 {
+		i += trainerSize
+	}
+	rom[i] = uint8(addr)
+	rom[i+1] = uint8(addr >> 8)
+}
+
+// Pad is an NROM image that reads both controllers once per frame, after
+// vblank starts, and adds each read to $00. Reads of controllers 1 and 2 are
+// kept in $01 and $02.
+var Pad = NROM([]uint8{
+	0x2c, 0x02, 0x20, // wait: BIT $2002
+	0x10, 0xfb, // BPL wait
+	0xa9, 0x01, 0x8d, 0x16, 0x40, // LDA #1, STA $4016
+	0xa9, 0x00, 0x8d, 0x16, 0x40, // LDA #0, STA $4016
+	0xa2, 0x08, // LDX #8
+	0xad, 0x16, 0x40, // loop: LDA $4016
+	0x4a,       // LSR A
+	0x26, 0x01, // ROL $01
+	0xad, 0x17, 0x40, // LDA $4017
+	0x4a,       // LSR A
+	0x26, 0x02, // ROL $02
+	0xca,       // DEX
+	0xd0, 0xf1, // BNE loop
+	0xa5, 0x01, 0x18, 0x65, 0x00, 0x65, 0x02, 0x85, 0x00, // LDA $01, CLC, ADC $00, ADC $02, STA $00
+	0x4c, 0x00, 0xc0, // JMP $C000
+})
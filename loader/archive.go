@@ -0,0 +1,87 @@
+package loader
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+var (
+	zipMagic  = []uint8{0x50, 0x4b, 0x03, 0x04}
+	gzipMagic = []uint8{0x1f, 0x8b}
+)
+
+// romExtensions are the file types picked from a zip archive when no entry is named
+var romExtensions = []string{".nes", ".unf", ".unif", ".fds", ".nsf", ".nsfe"}
+
+func isZip(data []uint8) bool {
+	return hasPrefix(data, zipMagic)
+}
+
+func isGzip(data []uint8) bool {
+	return hasPrefix(data, gzipMagic)
+}
+
+// readZip extracts a single file from a zip archive. If entry is empty, the
+// first file with one of the given extensions is returned.
+func readZip(data []uint8, entry string, exts []string) ([]uint8, error) {
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range z.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if entry == "" && !hasExtension(f.Name, exts) {
+			continue
+		}
+		if entry != "" && f.Name != entry && path.Base(f.Name) != entry {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+
+	if entry == "" {
+		return nil, errors.New(fmt.Sprintf("no %s file found in zip archive", strings.Join(exts, ", ")))
+	}
+	return nil, errors.New(fmt.Sprintf("%s not found in zip archive", entry))
+}
+
+// IsROMFile indicates if a file name has the extension of a supported ROM
+// format.
+func IsROMFile(name string) bool {
+	return hasExtension(name, romExtensions)
+}
+
+func hasExtension(name string, exts []string) bool {
+	ext := path.Ext(name)
+	for _, e := range exts {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// readGzip decompresses a gzip stream.
+func readGzip(data []uint8) ([]uint8, error) {
+	g, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer g.Close()
+	return ioutil.ReadAll(g)
+}
@@ -0,0 +1,112 @@
+package loader
+
+import (
+	"errors"
+)
+
+// BPS actions
+const (
+	bpsSourceRead = iota
+	bpsTargetRead
+	bpsSourceCopy
+	bpsTargetCopy
+)
+
+// applyBPS applies a BPS patch. Source, target, and patch checksums are all
+// verified.
+func applyBPS(rom, patch []uint8) ([]uint8, error) {
+	if len(patch) < len(bpsMagic)+patchFooterSize {
+		return nil, errPatchTruncated
+	}
+
+	pr := &patchReader{
+		data: patch[:len(patch)-patchFooterSize],
+		pos:  len(bpsMagic),
+	}
+	sourceSize, err := pr.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	targetSize, err := pr.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	metadataSize, err := pr.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	if sourceSize != uint64(len(rom)) {
+		return nil, errors.New("source rom size mismatch")
+	}
+	if err := checkTargetSize(targetSize); err != nil {
+		return nil, err
+	}
+	if metadataSize > uint64(len(pr.data)-pr.pos) {
+		return nil, errPatchTruncated
+	}
+	pr.pos += int(metadataSize)
+
+	r := make([]uint8, targetSize)
+	var out, sourceRel, targetRel int64
+	for pr.pos < len(pr.data) {
+		v, err := pr.readNumber()
+		if err != nil {
+			return nil, err
+		}
+		action := v & 0x3
+		length := int64(v>>2) + 1
+		if out+length > int64(len(r)) {
+			return nil, errors.New("bps action writes past end of target")
+		}
+
+		switch action {
+		case bpsSourceRead:
+			if out+length > int64(len(rom)) {
+				return nil, errors.New("bps source read out of range")
+			}
+			copy(r[out:out+length], rom[out:out+length])
+		case bpsTargetRead:
+			for j := int64(0); j < length; j++ {
+				x, err := pr.readByte()
+				if err != nil {
+					return nil, err
+				}
+				r[out+j] = x
+			}
+		case bpsSourceCopy, bpsTargetCopy:
+			o, err := pr.readNumber()
+			if err != nil {
+				return nil, err
+			}
+			offset := int64(o >> 1)
+			if o&1 != 0 {
+				offset = -offset
+			}
+
+			if action == bpsSourceCopy {
+				sourceRel += offset
+				if sourceRel < 0 || sourceRel+length > int64(len(rom)) {
+					return nil, errors.New("bps source copy out of range")
+				}
+				copy(r[out:out+length], rom[sourceRel:sourceRel+length])
+				sourceRel += length
+			} else {
+				targetRel += offset
+				if targetRel < 0 || targetRel >= out {
+					return nil, errors.New("bps target copy out of range")
+				}
+				// target copies may overlap the bytes being written, so copy one at a time
+				for j := int64(0); j < length; j++ {
+					r[out+j] = r[targetRel]
+					targetRel++
+				}
+			}
+		}
+		out += length
+	}
+
+	if err := verifyChecksums(rom, r, patch); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,99 @@
+package loader
+
+const (
+	ipsEOF           = 0x454f46
+	ipsMaxRecordSize = 0xffff
+)
+
+// applyIPS applies an IPS patch. IPS has no checksums, so the patch is
+// applied as-is. Records beyond the end of the ROM grow the image.
+func applyIPS(rom, patch []uint8) ([]uint8, error) {
+	r := make([]uint8, len(rom))
+	copy(r, rom)
+
+	i := len(ipsMagic)
+	for {
+		if i+3 > len(patch) {
+			return nil, errPatchTruncated
+		}
+		offset := int(patch[i])<<16 | int(patch[i+1])<<8 | int(patch[i+2])
+		i += 3
+		if offset == ipsEOF {
+			break
+		}
+
+		if i+2 > len(patch) {
+			return nil, errPatchTruncated
+		}
+		size := int(patch[i])<<8 | int(patch[i+1])
+		i += 2
+
+		var data []uint8
+		if size == 0 {
+			// run-length encoded record
+			if i+3 > len(patch) {
+				return nil, errPatchTruncated
+			}
+			size = int(patch[i])<<8 | int(patch[i+1])
+			data = make([]uint8, size)
+			for j := range data {
+				data[j] = patch[i+2]
+			}
+			i += 3
+		} else {
+			if i+size > len(patch) {
+				return nil, errPatchTruncated
+			}
+			data = patch[i : i+size]
+			i += size
+		}
+
+		if offset+size > len(r) {
+			r = append(r, make([]uint8, offset+size-len(r))...)
+		}
+		copy(r[offset:], data)
+	}
+
+	// an optional truncation length follows the EOF marker
+	if i+3 <= len(patch) {
+		size := int(patch[i])<<16 | int(patch[i+1])<<8 | int(patch[i+2])
+		if size < len(r) {
+			r = r[:size]
+		}
+	}
+
+	return r, nil
+}
+
+// CreateIPS returns an IPS patch that converts source into target. Bytes
+// past the end of source are included as records, and a truncation length
+// is added if target is shorter.
+func CreateIPS(source, target []uint8) []uint8 {
+	r := append([]uint8{}, ipsMagic...)
+
+	for i := 0; i < len(target); {
+		if i < len(source) && source[i] == target[i] {
+			i++
+			continue
+		}
+		// a record cannot start at the offset spelling "EOF"
+		if i == ipsEOF {
+			i--
+		}
+
+		start := i
+		for i < len(target) && i-start < ipsMaxRecordSize &&
+			(i >= len(source) || source[i] != target[i] || i == start) {
+			i++
+		}
+		r = append(r, uint8(start>>16), uint8(start>>8), uint8(start))
+		r = append(r, uint8((i-start)>>8), uint8(i-start))
+		r = append(r, target[start:i]...)
+	}
+
+	r = append(r, "EOF"...)
+	if len(target) < len(source) {
+		r = append(r, uint8(len(target)>>16), uint8(len(target)>>8), uint8(len(target)))
+	}
+	return r
+}
@@ -0,0 +1,100 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// patchExtensions are checked, in order, for soft-patches stored next to a ROM.
+var patchExtensions = []string{".ips", ".ups", ".bps"}
+
+// Load reads a ROM image from disk. Zip and gzip archives are opened
+// transparently; entry selects a file within a zip archive, and the first
+// ROM file is used when it is empty.
+func Load(filename, entry string) ([]uint8, error) {
+	return load(filename, entry, romExtensions)
+}
+
+// load reads a file from disk, opening archives. exts are the file types
+// picked from a zip archive when no entry is named.
+func load(filename, entry string, exts []string) ([]uint8, error) {
+	file, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var r []uint8
+	switch {
+	case isZip(file):
+		r, err = readZip(file, entry, exts)
+	case isGzip(file):
+		r, err = readGzip(file)
+	default:
+		r = file
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Loaded %s (%d bytes)", filename, len(r))
+
+	return r, nil
+}
+
+// LoadPatched loads a ROM image and applies each patch to it in order. If no
+// patches are given, any patch sharing the ROM's base name is applied instead.
+func LoadPatched(filename, entry string, patches []string) ([]uint8, error) {
+	rom, err := Load(filename, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(patches) == 0 {
+		patches = findPatches(filename)
+	}
+
+	for _, p := range patches {
+		patch, err := load(p, "", patchExtensions)
+		if err != nil {
+			return nil, err
+		}
+		rom, err = ApplyPatch(rom, patch)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %s", p, err))
+		}
+		log.Printf("Applied patch %s", p)
+	}
+
+	return rom, nil
+}
+
+// findPatches returns patches stored next to a ROM with the same base name.
+func findPatches(filename string) []string {
+	base := strings.TrimSuffix(filename, filepath.Ext(filename))
+
+	var r []string
+	for _, ext := range patchExtensions {
+		p := base + ext
+		if _, err := os.Stat(p); err == nil {
+			r = append(r, p)
+		}
+	}
+	return r
+}
+
+func hasPrefix(data, prefix []uint8) bool {
+	if len(data) < len(prefix) {
+		return false
+	}
+	for i, v := range prefix {
+		if data[i] != v {
+			return false
+		}
+	}
+	return true
+}
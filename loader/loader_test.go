@@ -0,0 +1,154 @@
+package loader
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeZip writes a zip archive holding the given files, in order.
+func writeZip(t *testing.T, path string, files [][2]string) {
+	var b bytes.Buffer
+	z := zip.NewWriter(&b)
+	for _, f := range files {
+		w, err := z.Create(f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]uint8(f[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadZipEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "games.zip")
+	writeZip(t, path, [][2]string{
+		{"readme.txt", "not a rom"},
+		{"first.nes", "first"},
+		{"disks/second.fds", "second"},
+	})
+
+	tests := []struct {
+		entry, want string
+	}{
+		{"", "first"},
+		{"first.nes", "first"},
+		{"second.fds", "second"},
+		{"disks/second.fds", "second"},
+		{"readme.txt", "not a rom"},
+	}
+	for _, tt := range tests {
+		r, err := Load(path, tt.entry)
+		if err != nil {
+			t.Errorf("entry %q: %s", tt.entry, err)
+			continue
+		}
+		if string(r) != tt.want {
+			t.Errorf("entry %q loaded %q, want %q", tt.entry, r, tt.want)
+		}
+	}
+
+	if _, err := Load(path, "missing.nes"); err == nil {
+		t.Error("missing entry was loaded")
+	}
+
+	empty := filepath.Join(dir, "empty.zip")
+	writeZip(t, empty, [][2]string{{"readme.txt", "not a rom"}})
+	if _, err := Load(empty, ""); err == nil {
+		t.Error("zip without a rom was loaded")
+	}
+}
+
+func TestLoadGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var b bytes.Buffer
+	g := gzip.NewWriter(&b)
+	g.Write([]uint8("rom data"))
+	g.Close()
+	path := filepath.Join(dir, "game.nes.gz")
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Load(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r) != "rom data" {
+		t.Errorf("loaded %q, want %q", r, "rom data")
+	}
+}
+
+func TestLoadPatchedFindsPatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source, target := []uint8("0123456789"), []uint8("01x3456789")
+	path := filepath.Join(dir, "game.nes")
+	if err := ioutil.WriteFile(path, source, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "game.ups"), createUPS(source, target), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := LoadPatched(path, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r, target) {
+		t.Errorf("loaded %q, want %q", r, target)
+	}
+}
+
+func TestLoadPatchedZippedPatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source, target := []uint8("0123456789"), []uint8("01x3456789")
+	path := filepath.Join(dir, "game.nes")
+	if err := ioutil.WriteFile(path, source, 0644); err != nil {
+		t.Fatal(err)
+	}
+	patch := filepath.Join(dir, "hack.zip")
+	writeZip(t, patch, [][2]string{
+		{"readme.txt", "not a patch"},
+		{"hack.bps", string(createBPS(source, target))},
+	})
+
+	r, err := LoadPatched(path, "", []string{patch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r, target) {
+		t.Errorf("loaded %q, want %q", r, target)
+	}
+}
@@ -0,0 +1,102 @@
+package loader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+var (
+	ipsMagic = []uint8("PATCH")
+	upsMagic = []uint8("UPS1")
+	bpsMagic = []uint8("BPS1")
+)
+
+// size of the source, target, and patch checksums at the end of UPS and BPS patches
+const patchFooterSize = 12
+
+// maxPatchedSize bounds the target size declared by UPS and BPS patches. It
+// is far larger than any NES ROM.
+const maxPatchedSize = 64 << 20
+
+var (
+	errPatchTruncated = errors.New("patch is truncated")
+	errNumberTooLarge = errors.New("patch number is too large")
+)
+
+// ApplyPatch detects the format of a patch and applies it to a ROM image.
+// IPS, UPS, and BPS patches are supported.
+func ApplyPatch(rom, patch []uint8) ([]uint8, error) {
+	switch {
+	case hasPrefix(patch, ipsMagic):
+		return applyIPS(rom, patch)
+	case hasPrefix(patch, upsMagic):
+		return applyUPS(rom, patch)
+	case hasPrefix(patch, bpsMagic):
+		return applyBPS(rom, patch)
+	default:
+		return nil, errors.New("unrecognized patch format")
+	}
+}
+
+// patchReader reads the fields of UPS and BPS patches.
+type patchReader struct {
+	data []uint8
+	pos  int
+}
+
+func (r *patchReader) readByte() (uint8, error) {
+	if r.pos >= len(r.data) {
+		return 0, errPatchTruncated
+	}
+	v := r.data[r.pos]
+	r.pos++
+	return v, nil
+}
+
+// readNumber decodes the variable-length integers shared by UPS and BPS.
+func (r *patchReader) readNumber() (uint64, error) {
+	var v, shift uint64 = 0, 1
+	for {
+		x, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		v += uint64(x&0x7f) * shift
+		if x&0x80 != 0 {
+			return v, nil
+		}
+		// numbers wider than 63 bits would overflow
+		if shift >= 1<<56 {
+			return 0, errNumberTooLarge
+		}
+		shift <<= 7
+		v += shift
+	}
+}
+
+// checkTargetSize validates the target size declared by a UPS or BPS patch
+// before it is allocated.
+func checkTargetSize(size uint64) error {
+	if size > maxPatchedSize {
+		return errors.New(fmt.Sprintf("patched rom size %d is too large", size))
+	}
+	return nil
+}
+
+// verifyChecksums validates the source, target, and patch CRC32 values
+// stored in the footer of UPS and BPS patches.
+func verifyChecksums(source, target, patch []uint8) error {
+	footer := patch[len(patch)-patchFooterSize:]
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != binary.LittleEndian.Uint32(footer[8:]) {
+		return errors.New("patch checksum mismatch")
+	}
+	if crc32.ChecksumIEEE(source) != binary.LittleEndian.Uint32(footer[0:]) {
+		return errors.New("source rom checksum mismatch")
+	}
+	if crc32.ChecksumIEEE(target) != binary.LittleEndian.Uint32(footer[4:]) {
+		return errors.New("patched rom checksum mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// encodeNumber encodes the variable-length integers of UPS and BPS patches.
+func encodeNumber(v uint64) []uint8 {
+	var r []uint8
+	for {
+		x := uint8(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(r, x|0x80)
+		}
+		r = append(r, x)
+		v--
+	}
+}
+
+// appendFooter appends the source, target, and patch checksums.
+func appendFooter(patch, source, target []uint8) []uint8 {
+	var footer [4]uint8
+	for _, crc := range []uint32{crc32.ChecksumIEEE(source), crc32.ChecksumIEEE(target)} {
+		binary.LittleEndian.PutUint32(footer[:], crc)
+		patch = append(patch, footer[:]...)
+	}
+	binary.LittleEndian.PutUint32(footer[:], crc32.ChecksumIEEE(patch))
+	return append(patch, footer[:]...)
+}
+
+// createUPS returns a UPS patch that converts source into target.
+func createUPS(source, target []uint8) []uint8 {
+	r := append([]uint8{}, upsMagic...)
+	r = append(r, encodeNumber(uint64(len(source)))...)
+	r = append(r, encodeNumber(uint64(len(target)))...)
+
+	n := len(source)
+	if len(target) > n {
+		n = len(target)
+	}
+	xor := func(i int) uint8 {
+		var s, t uint8
+		if i < len(source) {
+			s = source[i]
+		}
+		if i < len(target) {
+			t = target[i]
+		}
+		return s ^ t
+	}
+
+	last := 0
+	for i := 0; i < n; {
+		if xor(i) == 0 {
+			i++
+			continue
+		}
+		r = append(r, encodeNumber(uint64(i-last))...)
+		for ; i < n && xor(i) != 0; i++ {
+			r = append(r, xor(i))
+		}
+		r = append(r, 0)
+		i++
+		last = i
+	}
+	return appendFooter(r, source, target)
+}
+
+// createBPS returns a BPS patch that converts source into target with
+// source and target reads.
+func createBPS(source, target []uint8) []uint8 {
+	r := append([]uint8{}, bpsMagic...)
+	r = append(r, encodeNumber(uint64(len(source)))...)
+	r = append(r, encodeNumber(uint64(len(target)))...)
+	r = append(r, encodeNumber(0)...)
+
+	same := func(i int) bool { return i < len(source) && source[i] == target[i] }
+	for i := 0; i < len(target); {
+		start := i
+		if same(i) {
+			for i < len(target) && same(i) {
+				i++
+			}
+			r = append(r, encodeNumber(uint64(i-start-1)<<2|bpsSourceRead)...)
+			continue
+		}
+		for i < len(target) && !same(i) {
+			i++
+		}
+		r = append(r, encodeNumber(uint64(i-start-1)<<2|bpsTargetRead)...)
+		r = append(r, target[start:i]...)
+	}
+	return appendFooter(r, source, target)
+}
+
+// patchCases are source and target ROMs covering changed, grown, and shrunk
+// images.
+var patchCases = []struct {
+	name           string
+	source, target []uint8
+}{
+	{"changed", []uint8("0123456789"), []uint8("01x34yz789")},
+	{"grown", []uint8("0123456789"), []uint8("0123456789abc")},
+	{"shrunk", []uint8("0123456789"), []uint8("012z45")},
+	{"unchanged", []uint8("0123456789"), []uint8("0123456789")},
+}
+
+func TestPatchRoundTrip(t *testing.T) {
+	formats := []struct {
+		name   string
+		create func(source, target []uint8) []uint8
+	}{
+		{"ips", CreateIPS},
+		{"ups", createUPS},
+		{"bps", createBPS},
+	}
+	for _, f := range formats {
+		for _, c := range patchCases {
+			r, err := ApplyPatch(c.source, f.create(c.source, c.target))
+			if err != nil {
+				t.Errorf("%s %s: %s", f.name, c.name, err)
+				continue
+			}
+			if !bytes.Equal(r, c.target) {
+				t.Errorf("%s %s: patched rom is %q, want %q", f.name, c.name, r, c.target)
+			}
+		}
+	}
+}
+
+func TestBPSCopies(t *testing.T) {
+	source := []uint8("0123456789")
+	target := []uint8("4567xyxyxy")
+
+	patch := append([]uint8{}, bpsMagic...)
+	patch = append(patch, encodeNumber(uint64(len(source)))...)
+	patch = append(patch, encodeNumber(uint64(len(target)))...)
+	patch = append(patch, encodeNumber(0)...)
+	// copy "4567" from source offset +4
+	patch = append(patch, encodeNumber(3<<2|bpsSourceCopy)...)
+	patch = append(patch, encodeNumber(4<<1)...)
+	// write "xy"
+	patch = append(patch, encodeNumber(1<<2|bpsTargetRead)...)
+	patch = append(patch, 'x', 'y')
+	// repeat "xy" with an overlapping target copy from offset +4
+	patch = append(patch, encodeNumber(3<<2|bpsTargetCopy)...)
+	patch = append(patch, encodeNumber(4<<1)...)
+	patch = appendFooter(patch, source, target)
+
+	r, err := ApplyPatch(source, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r, target) {
+		t.Errorf("patched rom is %q, want %q", r, target)
+	}
+}
+
+func TestPatchTruncated(t *testing.T) {
+	c := patchCases[1]
+
+	ips := CreateIPS(c.source, c.target)
+	// stop before the EOF marker, since the truncation length is optional
+	for n := len(ipsMagic); n < len(ips)-3; n++ {
+		if _, err := ApplyPatch(c.source, ips[:n]); err == nil {
+			t.Errorf("ips truncated to %d bytes was applied", n)
+		}
+	}
+
+	for _, patch := range [][]uint8{createUPS(c.source, c.target), createBPS(c.source, c.target)} {
+		for n := 4; n < len(patch); n++ {
+			if _, err := ApplyPatch(c.source, patch[:n]); err == nil {
+				t.Errorf("%s truncated to %d bytes was applied", patch[:4], n)
+			}
+		}
+	}
+}
+
+func TestPatchChecksumMismatch(t *testing.T) {
+	c := patchCases[0]
+	for _, patch := range [][]uint8{createUPS(c.source, c.target), createBPS(c.source, c.target)} {
+		wrongSource := append([]uint8{}, c.source...)
+		wrongSource[0] ^= 0xff
+		if _, err := ApplyPatch(wrongSource, patch); err == nil || err.Error() != "source rom checksum mismatch" {
+			t.Errorf("%s applied to the wrong rom: %v", patch[:4], err)
+		}
+
+		corrupt := append([]uint8{}, patch...)
+		corrupt[len(corrupt)-patchFooterSize-1] ^= 0x01
+		if _, err := ApplyPatch(c.source, corrupt); err == nil {
+			t.Errorf("corrupt %s was applied", patch[:4])
+		}
+
+		corrupt = append([]uint8{}, patch...)
+		corrupt[len(corrupt)-patchFooterSize+4] ^= 0x01
+		if _, err := ApplyPatch(c.source, corrupt); err == nil {
+			t.Errorf("%s with a bad target checksum was applied", patch[:4])
+		}
+	}
+}
+
+func TestPatchSizeBounds(t *testing.T) {
+	rom := []uint8("0123456789")
+	header := func(magic []uint8, fields ...uint64) []uint8 {
+		r := append([]uint8{}, magic...)
+		for _, f := range fields {
+			r = append(r, encodeNumber(f)...)
+		}
+		return append(r, make([]uint8, patchFooterSize)...)
+	}
+
+	patches := map[string][]uint8{
+		"ups target size":   header(upsMagic, uint64(len(rom)), 1<<60),
+		"bps target size":   header(bpsMagic, uint64(len(rom)), 1<<60, 0),
+		"bps metadata size": header(bpsMagic, uint64(len(rom)), uint64(len(rom)), 1<<62),
+		"ups record skip":   header(upsMagic, uint64(len(rom)), uint64(len(rom)), 1<<40),
+		"number overflow": append(append(append([]uint8{}, upsMagic...),
+			0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x80), make([]uint8, patchFooterSize)...),
+	}
+	for name, patch := range patches {
+		if _, err := ApplyPatch(rom, patch); err == nil {
+			t.Errorf("%s: patch was applied", name)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package loader
+
+import (
+	"errors"
+)
+
+// applyUPS applies a UPS patch, whose records XOR the source ROM with the
+// patch data. Source, target, and patch checksums are all verified.
+func applyUPS(rom, patch []uint8) ([]uint8, error) {
+	if len(patch) < len(upsMagic)+patchFooterSize {
+		return nil, errPatchTruncated
+	}
+
+	pr := &patchReader{
+		data: patch[:len(patch)-patchFooterSize],
+		pos:  len(upsMagic),
+	}
+	sourceSize, err := pr.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	targetSize, err := pr.readNumber()
+	if err != nil {
+		return nil, err
+	}
+	if sourceSize != uint64(len(rom)) {
+		return nil, errors.New("source rom size mismatch")
+	}
+	if err := checkTargetSize(targetSize); err != nil {
+		return nil, err
+	}
+
+	r := make([]uint8, targetSize)
+	copy(r, rom)
+
+	var i uint64
+	for pr.pos < len(pr.data) {
+		skip, err := pr.readNumber()
+		if err != nil {
+			return nil, err
+		}
+		if skip > targetSize {
+			return nil, errors.New("ups record past end of target")
+		}
+		i += skip
+		for {
+			x, err := pr.readByte()
+			if err != nil {
+				return nil, err
+			}
+			if x == 0 {
+				i++
+				break
+			}
+			if i < targetSize {
+				r[i] ^= x
+			}
+			i++
+		}
+	}
+
+	if err := verifyChecksums(rom, r, patch); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
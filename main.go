@@ -1,42 +1,264 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/rhallman96/nesquack/gui"
+	"github.com/rhallman96/nesquack/loader"
+	"github.com/rhallman96/nesquack/movie"
+	"github.com/rhallman96/nesquack/system"
 )
 
+const (
+	diskDiffExtension = ".fdsdiff"
+
+	// save state slots are numbered after the extension, as in game.ss1
+	stateExtension = ".ss"
+
+	// holds the run-ahead frames chosen for a rom
+	runAheadExtension = ".runahead"
+)
+
+// patchList collects repeated --patch flags in the order they are given.
+type patchList []string
+
+func (p *patchList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patchList) Set(v string) error {
+	*p = append(*p, v)
+	return nil
+}
+
 func main() {
-	if len(os.Args) < 2 {
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		os.Exit(runTests(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "netplay" {
+		os.Exit(runNetplay(os.Args[2:]))
+	}
+
+	var patches patchList
+	flag.Var(&patches, "patch", "IPS, UPS, or BPS patch to apply (may be repeated)")
+	entry := flag.String("entry", "", "file to load from a zip archive (defaults to the first ROM file)")
+	systemFlags := addOptionFlags(flag.CommandLine)
+	trace := flag.String("trace", "", "file to write the CPU trace to, toggled with the t key")
+	traceRange := flag.String("trace-range", "0000-FFFF", "range of addresses to trace, in hex")
+	rewindMB := flag.Int("rewind-mb", 64, "memory for the rewind buffer in MB, or 0 to disable rewinding")
+	rewindInterval := flag.Int("rewind-interval", 1, "frames between rewind states")
+	runAhead := flag.Int("run-ahead", -1, "frames to run ahead to hide input lag (defaults to the setting saved for the rom, or 0)")
+	play := flag.String("play", "", "FM2 movie to play back")
+	record := flag.String("record", "", "FM2 movie file to record input to, from power on")
+	recordFrom := flag.String("record-from", "", "save state to start the recorded movie from, such as game.ss1")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
 		fmt.Println("ROM filename was not provided")
 		return
 	}
 
-	filename := os.Args[1]
-	rom, err := load(filename)
+	filename := flag.Arg(0)
+	rom, err := load(filename, *entry, patches)
+	if err != nil {
+		fmt.Println("failed to load rom from " + filename + ": " + err.Error())
+		os.Exit(1)
+	}
+
+	options, err := systemFlags.options(rom)
 	if err != nil {
-		fmt.Println("failed to load rom from " + filename)
+		fmt.Println(err.Error())
 		os.Exit(1)
 	}
 
-	gui.Launch(rom)
+	traceLow, traceHigh, err := parseRange(*traceRange)
+	if err != nil {
+		fmt.Println("invalid trace range " + *traceRange + ": " + err.Error())
+		os.Exit(1)
+	}
+
+	cfg := gui.Config{
+		Options:   options,
+		TracePath: *trace,
+		TraceLow:  traceLow,
+		TraceHigh: traceHigh,
+		StatePath: strings.TrimSuffix(filename, filepath.Ext(filename)) + stateExtension,
+	}
+	cfg.Rewind = system.DefaultRewindConfig
+	cfg.Rewind.Budget = *rewindMB << 20
+	cfg.Rewind.Interval = *rewindInterval
+
+	cfg.RunAheadPath = strings.TrimSuffix(filename, filepath.Ext(filename)) + runAheadExtension
+	cfg.RunAhead = *runAhead
+	if cfg.RunAhead < 0 {
+		cfg.RunAhead = loadRunAhead(cfg.RunAheadPath)
+	}
+
+	if *play != "" {
+		cfg.PlayMovie, err = loadMovie(*play, rom)
+		if err != nil {
+			fmt.Println("failed to load movie from " + *play + ": " + err.Error())
+			os.Exit(1)
+		}
+	} else if *record != "" {
+		cfg.RecordMoviePath = *record
+		if *recordFrom != "" {
+			cfg.RecordMovieState, err = ioutil.ReadFile(*recordFrom)
+			if err != nil {
+				fmt.Println("failed to load save state from " + *recordFrom + ": " + err.Error())
+				os.Exit(1)
+			}
+		}
+	}
+
+	if system.IsDiskImage(rom) {
+		// disk writes are kept in a patch next to the image
+		cfg.DiskOriginal = rom
+		cfg.DiskDiffPath = strings.TrimSuffix(filename, filepath.Ext(filename)) + diskDiffExtension
+		rom, err = loadDiskDiff(rom, cfg.DiskDiffPath)
+		if err != nil {
+			fmt.Println("failed to load disk changes from " + cfg.DiskDiffPath + ": " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	gui.Launch(rom, cfg)
+}
+
+// optionFlags are the flags configuring the emulated system, shared by the
+// commands that run a game.
+type optionFlags struct {
+	gameDB    *string
+	noGameDB  *bool
+	fdsBIOS   *string
+	ram       *string
+	seed      *int64
+	randomCPU *bool
+}
+
+func addOptionFlags(f *flag.FlagSet) *optionFlags {
+	return &optionFlags{
+		gameDB:    f.String("gamedb", "", "nesdev NES 2.0 XML database to merge into the game database"),
+		noGameDB:  f.Bool("no-gamedb", false, "trust the iNES header instead of correcting it from the game database"),
+		fdsBIOS:   f.String("fds-bios", "disksys.rom", "Famicom Disk System BIOS ROM, used to run .fds images"),
+		ram:       f.String("ram", "zeros", "power-on memory pattern: zeros, ones, alternating, or random"),
+		seed:      f.Int64("seed", 0, "seed for random memory and CPU registers"),
+		randomCPU: f.Bool("random-cpu", false, "start the CPU with random register values"),
+	}
 }
 
-func load(filename string) ([]uint8, error) {
-	file, err := ioutil.ReadFile(filename)
+// options loads the game database and FDS BIOS as needed to run rom, and
+// returns the options the flags select.
+func (o *optionFlags) options(rom []uint8) (system.Options, error) {
+	if *o.gameDB != "" {
+		if err := loadGameDB(*o.gameDB); err != nil {
+			return system.Options{}, errors.New("failed to load game database from " + *o.gameDB + ": " + err.Error())
+		}
+	}
+
+	ramPattern, err := system.ParseRAMPattern(*o.ram)
 	if err != nil {
+		return system.Options{}, err
+	}
+	opts := system.Options{
+		DisableGameDB: *o.noGameDB,
+		RAM:           ramPattern,
+		Seed:          *o.seed,
+		RandomizeCPU:  *o.randomCPU,
+	}
+
+	if system.IsDiskImage(rom) {
+		opts.FDSBIOS, err = loader.Load(*o.fdsBIOS, "")
+		if err != nil {
+			return system.Options{}, errors.New("failed to load fds bios from " + *o.fdsBIOS + ": " + err.Error())
+		}
+	}
+	return opts, nil
+}
+
+// loadDiskDiff applies saved disk writes to a Famicom Disk System image.
+func loadDiskDiff(rom []uint8, filename string) ([]uint8, error) {
+	diff, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return rom, nil
+	} else if err != nil {
 		return nil, err
 	}
+	log.Printf("Applied disk changes from %s", filename)
+	return loader.ApplyPatch(rom, diff)
+}
+
+// parseRange parses an address range such as C000-FFFF.
+func parseRange(s string) (uint16, uint16, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.New("expected two addresses separated by -")
+	}
+	low, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	high, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint16(low), uint16(high), nil
+}
+
+func loadGameDB(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	r := make([]uint8, len(file))
-	for i, v := range file {
-		r[i] = uint8(v)
+	n, err := system.LoadGameDB(f)
+	if err != nil {
+		return err
 	}
+	log.Printf("Loaded %d game database entries from %s", n, filename)
+	return nil
+}
 
-	log.Printf("Loaded %s (%d bytes)", filename, len(r))
+func load(filename, entry string, patches []string) ([]uint8, error) {
+	return loader.LoadPatched(filename, entry, patches)
+}
 
-	return r, nil
+// loadMovie reads an FM2 movie, warning if it was recorded with another ROM.
+func loadMovie(path string, rom []uint8) (*movie.Movie, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := movie.Read(f)
+	if err != nil {
+		return nil, err
+	}
+	if m.ROMChecksum != "" && m.ROMChecksum != movie.ROMChecksum(rom) {
+		log.Printf("Movie was recorded with a different rom (%s), so it may desync", m.ROMFilename)
+	}
+	return m, nil
+}
+
+// loadRunAhead reads the run-ahead frames saved for a rom, or returns 0.
+func loadRunAhead(path string) int {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	frames, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || frames < 0 {
+		log.Printf("Ignoring invalid run-ahead setting in %s", path)
+		return 0
+	}
+	return frames
 }
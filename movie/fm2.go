@@ -0,0 +1,270 @@
+package movie
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rhallman96/nesquack/system"
+)
+
+// FM2 header keys. Keys prefixed with nesquack are ignored by FCEUX.
+const (
+	keyVersion       = "version"
+	keyEmuVersion    = "emuVersion"
+	keyRerecords     = "rerecordCount"
+	keyPAL           = "palFlag"
+	keyROMFilename   = "romFilename"
+	keyROMChecksum   = "romChecksum"
+	keyGUID          = "guid"
+	keyFourScore     = "fourscore"
+	keyPort0         = "port0"
+	keyPort1         = "port1"
+	keyPort2         = "port2"
+	keyComment       = "comment"
+	keySubtitle      = "subtitle"
+	keyFCEUXState    = "savestate"
+	keyState         = "nesquackState"
+	keyFinalChecksum = "nesquackChecksum"
+	keyRAM           = "nesquackRAM"
+	keySeed          = "nesquackSeed"
+	keyRandomCPU     = "nesquackRandomCPU"
+	keyCPU           = "nesquackCPU"
+	keyNoGameDB      = "nesquackNoGameDB"
+	keyDisk          = "nesquackDisk"
+
+	fm2Version   = 3
+	base64Prefix = "base64:"
+
+	// FM2 port devices
+	portNone    = 0
+	portGamepad = 1
+)
+
+// fm2Buttons are the gamepad buttons in the order FM2 lists them, from the
+// highest bit to the lowest.
+const fm2Buttons = "RLDUTSBA"
+
+// Read parses a movie in the FM2 format.
+func Read(r io.Reader) (*Movie, error) {
+	m := &Movie{}
+	lines := bufio.NewScanner(r)
+	lines.Buffer(nil, 64<<20)
+	for n := 1; lines.Scan(); n++ {
+		line := strings.TrimRight(lines.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "|") {
+			f, err := parseFrame(line)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("line %d: %s", n, err))
+			}
+			m.Frames = append(m.Frames, f)
+			continue
+		}
+		if err := m.parseHeader(line); err != nil {
+			return nil, errors.New(fmt.Sprintf("line %d: %s", n, err))
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(m.Disk, func(i, j int) bool { return m.Disk[i].Frame < m.Disk[j].Frame })
+	return m, nil
+}
+
+func (m *Movie) parseHeader(line string) error {
+	key, value := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		key, value = line[:i], line[i+1:]
+	}
+
+	var err error
+	switch key {
+	case keyVersion:
+		var v int
+		v, err = strconv.Atoi(value)
+		if err == nil && v != fm2Version {
+			err = errors.New(fmt.Sprintf("fm2 version %d is not supported", v))
+		}
+	case keyRerecords:
+		m.Rerecords, err = strconv.Atoi(value)
+	case keyPAL:
+		if value != "0" {
+			err = errors.New("PAL movies are not supported")
+		}
+	case keyROMFilename:
+		m.ROMFilename = value
+	case keyROMChecksum:
+		m.ROMChecksum = value
+	case keyGUID:
+		m.GUID = value
+	case keyFourScore:
+		if value != "0" {
+			err = errors.New("four score movies are not supported")
+		}
+	case keyPort0, keyPort1, keyPort2:
+		if value != strconv.Itoa(portNone) && value != strconv.Itoa(portGamepad) {
+			err = errors.New(fmt.Sprintf("%s device %s is not supported", key, value))
+		}
+	case keyComment:
+		m.Comments = append(m.Comments, value)
+	case keyFCEUXState:
+		err = errors.New("movies starting from FCEUX save states are not supported")
+	case keyState:
+		m.State, err = decodeBase64(value)
+	case keyFinalChecksum:
+		m.Checksum = value
+	case keyRAM:
+		var v int
+		v, err = strconv.Atoi(value)
+		if err == nil && (v < int(system.RAMZeros) || v > int(system.RAMRandom)) {
+			err = errors.New(fmt.Sprintf("RAM pattern %d is not supported", v))
+		}
+		m.Options.RAM = system.RAMPattern(v)
+	case keySeed:
+		m.Options.Seed, err = strconv.ParseInt(value, 10, 64)
+	case keyRandomCPU:
+		m.Options.RandomizeCPU, err = parseFlag(value)
+	case keyCPU:
+		var v int
+		v, err = strconv.Atoi(value)
+		if err == nil && (v < int(system.CPU2A03) || v > int(system.CPU65C02)) {
+			err = errors.New(fmt.Sprintf("CPU variant %d is not supported", v))
+		}
+		m.Options.CPU = system.CPUVariant(v)
+	case keyNoGameDB:
+		m.Options.DisableGameDB, err = parseFlag(value)
+	case keyDisk:
+		var d DiskChange
+		if _, serr := fmt.Sscanf(value, "%d %d", &d.Frame, &d.Side); serr != nil {
+			err = errors.New(fmt.Sprintf("invalid disk change %s", value))
+		}
+		m.Disk = append(m.Disk, d)
+	}
+	// other keys, such as subtitles and emulator settings, are ignored
+	return err
+}
+
+// parseFlag parses a 0 or 1 header value.
+func parseFlag(value string) (bool, error) {
+	switch value {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	return false, errors.New(fmt.Sprintf("invalid flag %s", value))
+}
+
+// parseFrame parses an input line, such as |0|R..U...A|........||.
+func parseFrame(line string) (Frame, error) {
+	var f Frame
+	fields := strings.Split(line, "|")
+	if len(fields) < 3 {
+		return f, errors.New("input line has too few fields")
+	}
+	commands, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return f, errors.New(fmt.Sprintf("invalid commands %s", fields[1]))
+	}
+	f.Commands = uint8(commands)
+
+	for port := 0; port < len(f.Ports) && port+2 < len(fields); port++ {
+		pad := fields[port+2]
+		if pad == "" {
+			continue
+		}
+		if len(pad) != len(fm2Buttons) {
+			return f, errors.New(fmt.Sprintf("invalid gamepad input %s", pad))
+		}
+		for i := 0; i < len(fm2Buttons); i++ {
+			if pad[i] != '.' && pad[i] != ' ' {
+				f.Ports[port] |= 1 << uint(len(fm2Buttons)-1-i)
+			}
+		}
+	}
+	return f, nil
+}
+
+// Write writes a movie in the FM2 format.
+func (m *Movie) Write(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "%s %d\n", keyVersion, fm2Version)
+	fmt.Fprintf(b, "%s %d\n", keyEmuVersion, 0)
+	fmt.Fprintf(b, "%s %d\n", keyRerecords, m.Rerecords)
+	fmt.Fprintf(b, "%s %d\n", keyPAL, 0)
+	fmt.Fprintf(b, "%s %s\n", keyROMFilename, m.ROMFilename)
+	fmt.Fprintf(b, "%s %s\n", keyROMChecksum, m.ROMChecksum)
+	if m.GUID != "" {
+		fmt.Fprintf(b, "%s %s\n", keyGUID, m.GUID)
+	}
+	fmt.Fprintf(b, "%s %d\n", keyFourScore, 0)
+	fmt.Fprintf(b, "%s %d\n", keyPort0, portGamepad)
+	fmt.Fprintf(b, "%s %d\n", keyPort1, portGamepad)
+	fmt.Fprintf(b, "%s %d\n", keyPort2, portNone)
+	for _, c := range m.Comments {
+		fmt.Fprintf(b, "%s %s\n", keyComment, c)
+	}
+	if m.State != nil {
+		fmt.Fprintf(b, "%s %s%s\n", keyState, base64Prefix, base64.StdEncoding.EncodeToString(m.State))
+	}
+	if m.Checksum != "" {
+		fmt.Fprintf(b, "%s %s\n", keyFinalChecksum, m.Checksum)
+	}
+	fmt.Fprintf(b, "%s %d\n", keyRAM, m.Options.RAM)
+	fmt.Fprintf(b, "%s %d\n", keySeed, m.Options.Seed)
+	fmt.Fprintf(b, "%s %s\n", keyRandomCPU, formatFlag(m.Options.RandomizeCPU))
+	fmt.Fprintf(b, "%s %d\n", keyCPU, m.Options.CPU)
+	fmt.Fprintf(b, "%s %s\n", keyNoGameDB, formatFlag(m.Options.DisableGameDB))
+	for _, d := range m.Disk {
+		fmt.Fprintf(b, "%s %d %d\n", keyDisk, d.Frame, d.Side)
+	}
+
+	for _, f := range m.Frames {
+		fmt.Fprintf(b, "|%d|%s|%s||\n", f.Commands, formatPad(f.Ports[0]), formatPad(f.Ports[1]))
+	}
+	return b.Flush()
+}
+
+func formatFlag(v bool) string {
+	if v {
+		return "1"
+	}
+	return "0"
+}
+
+func formatPad(buttons system.Buttons) string {
+	pad := []byte("........")
+	for i := 0; i < len(fm2Buttons); i++ {
+		if buttons&(1<<uint(len(fm2Buttons)-1-i)) != 0 {
+			pad[i] = fm2Buttons[i]
+		}
+	}
+	return string(pad)
+}
+
+func decodeBase64(value string) ([]uint8, error) {
+	if !strings.HasPrefix(value, base64Prefix) {
+		return nil, errors.New("expected a base64 value")
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimPrefix(value, base64Prefix))
+}
+
+// ROMChecksum returns the checksum FCEUX identifies a ROM by: the MD5 of its
+// data after the iNES header, in base64.
+func ROMChecksum(rom []uint8) string {
+	data := rom
+	if len(rom) > 16 && string(rom[:4]) == "NES\x1a" {
+		data = rom[16:]
+	}
+	sum := md5.Sum(data)
+	return base64Prefix + base64.StdEncoding.EncodeToString(sum[:])
+}
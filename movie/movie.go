@@ -0,0 +1,307 @@
+// Package movie records and plays back controller input frame by frame.
+//
+// Movies are stored in FCEUX's FM2 text format. A movie starts from power on
+// or from an embedded save state, and ends with a checksum of the machine
+// state that playback verifies. The options that change the power on state,
+// and disk changes, are kept in extra header keys so that playback is
+// deterministic.
+package movie
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/rhallman96/nesquack/system"
+)
+
+// frame commands, applied before the frame runs
+const (
+	CommandReset      = 1
+	CommandPowerCycle = 2
+)
+
+// Movie is a recording of controller input.
+type Movie struct {
+	ROMFilename string
+	ROMChecksum string
+	GUID        string
+	Rerecords   int
+	Comments    []string
+
+	// State is the save state the movie starts from, or nil to start from
+	// power on.
+	State []uint8
+
+	// Options are the options the movie was recorded with. The FDS BIOS is
+	// not kept, as it is a file like the ROM.
+	Options system.Options
+
+	// Disk lists the Famicom Disk System disk changes, in frame order.
+	Disk []DiskChange
+
+	// Checksum identifies the machine state after the last frame.
+	Checksum string
+
+	Frames []Frame
+}
+
+// Frame is the input for one frame.
+type Frame struct {
+	Commands uint8
+	Ports    [2]system.Buttons
+}
+
+// DiskChange inserts a disk side, or ejects the disk if Side is negative,
+// before a frame runs and after the frame's commands.
+type DiskChange struct {
+	Frame int
+	Side  int
+}
+
+// ApplyOptions returns opts with the options the movie was recorded with.
+func (m *Movie) ApplyOptions(opts system.Options) system.Options {
+	bios := opts.FDSBIOS
+	opts = m.Options
+	opts.FDSBIOS = bios
+	return opts
+}
+
+// stateChecksum returns the SHA-1 of the machine state in hex.
+func stateChecksum(nes system.NES) (string, error) {
+	var b bytes.Buffer
+	if err := nes.SaveState(&b); err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(b.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// start puts nes in the movie's starting state.
+func start(nes system.NES, state []uint8) error {
+	if state == nil {
+		return nes.PowerCycle()
+	}
+	return nes.LoadState(bytes.NewReader(state))
+}
+
+// applyFrame applies the commands and disk changes of a frame before it runs.
+func applyFrame(nes system.NES, f Frame, disk []DiskChange) error {
+	if f.Commands&CommandPowerCycle != 0 {
+		if err := nes.PowerCycle(); err != nil {
+			return err
+		}
+	} else if f.Commands&CommandReset != 0 {
+		if err := nes.Reset(); err != nil {
+			return err
+		}
+	}
+	for _, d := range disk {
+		if d.Side < 0 {
+			nes.EjectDisk()
+		} else if err := nes.InsertDisk(d.Side); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPorts samples controllers into ports. Ports without a controller hold
+// no buttons.
+func readPorts(ports *[2]system.Buttons, controllers [2]system.Controller) {
+	for i, c := range controllers {
+		ports[i] = 0
+		if c != nil {
+			ports[i] = system.ReadButtons(c)
+		}
+	}
+}
+
+// Recorder records the input of live controllers. Its controllers must be
+// passed to the NES, so that input is sampled once per frame.
+type Recorder struct {
+	ports   [2]system.Buttons
+	live    [2]system.Controller
+	nes     system.NES
+	movie   *Movie
+	pending uint8
+
+	// the disk changes made since the last frame
+	disk []DiskChange
+}
+
+// NewRecorder returns a recorder for the live controllers of ports 1 and 2.
+// Either may be nil to record no input for that port.
+func NewRecorder(live1, live2 system.Controller) *Recorder {
+	return &Recorder{live: [2]system.Controller{live1, live2}}
+}
+
+// Controller returns the controller for a port, to be passed to the NES.
+func (r *Recorder) Controller(port int) system.Controller {
+	return &r.ports[port]
+}
+
+// Begin starts a movie for rom, from power on or, if fromState is set, from
+// the current state of nes. opts are the options nes was created with.
+func (r *Recorder) Begin(nes system.NES, rom []uint8, opts system.Options, fromState bool) error {
+	opts.FDSBIOS = nil
+	m := &Movie{ROMChecksum: ROMChecksum(rom), Options: opts}
+	if fromState {
+		var b bytes.Buffer
+		if err := nes.SaveState(&b); err != nil {
+			return err
+		}
+		m.State = b.Bytes()
+	}
+	if err := start(nes, m.State); err != nil {
+		return err
+	}
+	r.nes = nes
+	r.movie = m
+	r.pending = 0
+	r.disk = nil
+	return nil
+}
+
+// RunFrame samples the live controllers and runs one frame, after applying
+// the commands and disk changes made since the last frame as playback does.
+func (r *Recorder) RunFrame() error {
+	readPorts(&r.ports, r.live)
+	f := Frame{Commands: r.pending, Ports: r.ports}
+	r.movie.Frames = append(r.movie.Frames, f)
+	r.movie.Disk = append(r.movie.Disk, r.disk...)
+	disk := r.disk
+	r.pending = 0
+	r.disk = nil
+	if err := applyFrame(r.nes, f, disk); err != nil {
+		return err
+	}
+	return r.nes.RunFrame()
+}
+
+// Reset records a press of the reset button, which takes effect at the start
+// of the next frame.
+func (r *Recorder) Reset() error {
+	r.pending |= CommandReset
+	return nil
+}
+
+// PowerCycle records a power cycle, which takes effect at the start of the
+// next frame.
+func (r *Recorder) PowerCycle() error {
+	r.pending |= CommandPowerCycle
+	return nil
+}
+
+// InsertDisk records the insertion of a disk side, which takes effect at the
+// start of the next frame.
+func (r *Recorder) InsertDisk(side int) error {
+	if side < 0 || side >= r.nes.DiskSides() {
+		return errors.New(fmt.Sprintf("disk side %d does not exist", side))
+	}
+	r.disk = append(r.disk, DiskChange{Frame: len(r.movie.Frames), Side: side})
+	return nil
+}
+
+// EjectDisk records the ejection of the disk, which takes effect at the start
+// of the next frame.
+func (r *Recorder) EjectDisk() {
+	r.disk = append(r.disk, DiskChange{Frame: len(r.movie.Frames), Side: -1})
+}
+
+// Finish ends the recording and returns the movie, with the checksum of the
+// final state.
+func (r *Recorder) Finish() (*Movie, error) {
+	sum, err := stateChecksum(r.nes)
+	if err != nil {
+		return nil, err
+	}
+	r.movie.Checksum = sum
+	return r.movie, nil
+}
+
+// Player plays back a movie. Its controllers must be passed to the NES. Once
+// the movie ends, input comes from the fallback controllers, if any.
+type Player struct {
+	ports    [2]system.Buttons
+	movie    *Movie
+	fallback [2]system.Controller
+	nes      system.NES
+	frame    int
+
+	// the next disk change to apply
+	disk int
+}
+
+// NewPlayer returns a player for m, with fallback controllers for ports 1
+// and 2.
+func NewPlayer(m *Movie, fallback1, fallback2 system.Controller) *Player {
+	return &Player{movie: m, fallback: [2]system.Controller{fallback1, fallback2}}
+}
+
+// Controller returns the controller for a port, to be passed to the NES.
+func (p *Player) Controller(port int) system.Controller {
+	return &p.ports[port]
+}
+
+// Begin puts nes in the movie's starting state.
+func (p *Player) Begin(nes system.NES) error {
+	if err := start(nes, p.movie.State); err != nil {
+		return err
+	}
+	p.nes = nes
+	p.frame = 0
+	p.disk = 0
+	return nil
+}
+
+// RunFrame applies the next frame of the movie and runs it.
+func (p *Player) RunFrame() error {
+	if p.Done() {
+		readPorts(&p.ports, p.fallback)
+		return p.nes.RunFrame()
+	}
+
+	f := p.movie.Frames[p.frame]
+	first := p.disk
+	for p.disk < len(p.movie.Disk) && p.movie.Disk[p.disk].Frame <= p.frame {
+		p.disk++
+	}
+	p.frame++
+	if err := applyFrame(p.nes, f, p.movie.Disk[first:p.disk]); err != nil {
+		return err
+	}
+	p.ports = f.Ports
+	return p.nes.RunFrame()
+}
+
+// Done indicates if every frame of the movie has been played.
+func (p *Player) Done() bool {
+	return p.frame >= len(p.movie.Frames)
+}
+
+// Frame returns the number of movie frames played.
+func (p *Player) Frame() int {
+	return p.frame
+}
+
+// Verify checks the machine state against the movie's checksum once the
+// movie is done. Movies without a checksum, such as imported FM2 files, pass.
+func (p *Player) Verify() error {
+	if !p.Done() {
+		return errors.New(fmt.Sprintf("movie is at frame %d of %d", p.frame, len(p.movie.Frames)))
+	}
+	if p.movie.Checksum == "" {
+		return nil
+	}
+	sum, err := stateChecksum(p.nes)
+	if err != nil {
+		return err
+	}
+	if sum != p.movie.Checksum {
+		return errors.New(fmt.Sprintf("movie desynced: state checksum %s, expected %s", sum, p.movie.Checksum))
+	}
+	return nil
+}
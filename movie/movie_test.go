@@ -0,0 +1,231 @@
+package movie
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rhallman96/nesquack/internal/testrom"
+	"github.com/rhallman96/nesquack/system"
+)
+
+func TestRecordAndPlay(t *testing.T) {
+	var live [2]system.Buttons
+	rec := NewRecorder(&live[0], &live[1])
+	nes, err := system.NewNESWithControllers(testrom.Pad, nil, rec.Controller(0), rec.Controller(1), system.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// start from a state a few frames in
+	for i := 0; i < 3; i++ {
+		nes.RunFrame()
+	}
+	if err := rec.Begin(nes, testrom.Pad, system.Options{}, true); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		live[0] = system.Buttons(i * 37)
+		live[1] = system.Buttons(i * 11)
+		if i == 20 {
+			if err := rec.Reset(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rec.RunFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorded, err := rec.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fm2 bytes.Buffer
+	if err := recorded.Write(&fm2); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Read(&fm2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Frames) != 30 || m.Frames[20].Commands != CommandReset || m.Checksum != recorded.Checksum {
+		t.Fatalf("movie did not survive a round trip: %+v", m.Frames)
+	}
+
+	play := func(m *Movie) error {
+		p := NewPlayer(m, nil, nil)
+		nes, err := system.NewNESWithControllers(testrom.Pad, nil, p.Controller(0), p.Controller(1), system.Options{})
+		if err != nil {
+			return err
+		}
+		if err := p.Begin(nes); err != nil {
+			return err
+		}
+		for !p.Done() {
+			if err := p.RunFrame(); err != nil {
+				return err
+			}
+		}
+		return p.Verify()
+	}
+	if err := play(m); err != nil {
+		t.Fatal(err)
+	}
+
+	for port := range m.Frames[10].Ports {
+		changed := *m
+		changed.Frames = append([]Frame(nil), m.Frames...)
+		changed.Frames[10].Ports[port] ^= system.ButtonA
+		if err := play(&changed); err == nil {
+			t.Errorf("changed input on port %d did not desync the movie", port+1)
+		}
+	}
+}
+
+func TestReadFCEUX(t *testing.T) {
+	fm2 := strings.Join([]string{
+		"version 3",
+		"emuVersion 22020",
+		"rerecordCount 5",
+		"palFlag 0",
+		"romFilename smb",
+		"romChecksum base64:jjYwGG411HcjG/j9UOVM3Q==",
+		"guid 452DE2C3-EF43-2FA9-77AC-0677FC51543B",
+		"fourscore 0",
+		"port0 1",
+		"port1 1",
+		"port2 0",
+		"|0|........|........||",
+		"|1|R..U...A|........||",
+		"|0|....T...|.L....B.||",
+	}, "\n")
+	m, err := Read(strings.NewReader(fm2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Frame{
+		{},
+		{Commands: CommandReset, Ports: [2]system.Buttons{system.ButtonRight | system.ButtonUp | system.ButtonA}},
+		{Ports: [2]system.Buttons{system.ButtonStart, system.ButtonLeft | system.ButtonB}},
+	}
+	if len(m.Frames) != len(want) || m.Rerecords != 5 || m.ROMFilename != "smb" {
+		t.Fatalf("got %+v", m)
+	}
+	for i, f := range want {
+		if m.Frames[i] != f {
+			t.Errorf("frame %d: got %+v, want %+v", i, m.Frames[i], f)
+		}
+	}
+}
+
+// fdsBIOS returns a Famicom Disk System BIOS that spins at $E000.
+func fdsBIOS() []uint8 {
+	bios := make([]uint8, 0x2000)
+	copy(bios, []uint8{0x4c, 0x00, 0xe0}) // JMP $E000
+	for v := 0x1ffa; v < 0x2000; v += 2 {
+		bios[v], bios[v+1] = 0x00, 0xe0
+	}
+	return bios
+}
+
+// fdsImage returns a .fds image of empty disk sides.
+func fdsImage(sides int) []uint8 {
+	var image []uint8
+	for i := 0; i < sides; i++ {
+		side := make([]uint8, 65500)
+		copy(side, "\x01*NINTENDO-HVC*")
+		side[56] = 0x02 // file amount block, with no files
+		image = append(image, side...)
+	}
+	return image
+}
+
+func TestRecordOptionsAndDisk(t *testing.T) {
+	image := fdsImage(2)
+	opts := system.Options{FDSBIOS: fdsBIOS(), RAM: system.RAMRandom, Seed: 42, RandomizeCPU: true, DisableGameDB: true}
+	rec := NewRecorder(nil, nil)
+	nes, err := system.NewNESWithControllers(image, nil, rec.Controller(0), rec.Controller(1), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Begin(nes, image, opts, false); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		switch i {
+		case 5:
+			if err := rec.InsertDisk(1); err != nil {
+				t.Fatal(err)
+			}
+		case 10:
+			rec.EjectDisk()
+		case 15:
+			if err := rec.InsertDisk(1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rec.RunFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.InsertDisk(2); err == nil {
+		t.Error("inserted a disk side that does not exist")
+	}
+	recorded, err := rec.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fm2 bytes.Buffer
+	if err := recorded.Write(&fm2); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Read(&fm2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOpts := opts
+	wantOpts.FDSBIOS = nil
+	if m.Options.RAM != wantOpts.RAM || m.Options.Seed != wantOpts.Seed || m.Options.RandomizeCPU != wantOpts.RandomizeCPU ||
+		m.Options.CPU != wantOpts.CPU || m.Options.DisableGameDB != wantOpts.DisableGameDB || m.Options.FDSBIOS != nil {
+		t.Errorf("options did not survive a round trip: %+v", m.Options)
+	}
+	wantDisk := []DiskChange{{5, 1}, {10, -1}, {15, 1}}
+	if len(m.Disk) != len(wantDisk) {
+		t.Fatalf("disk changes are %v, want %v", m.Disk, wantDisk)
+	}
+	for i, d := range wantDisk {
+		if m.Disk[i] != d {
+			t.Errorf("disk change %d is %+v, want %+v", i, m.Disk[i], d)
+		}
+	}
+
+	play := func(m *Movie, opts system.Options) error {
+		p := NewPlayer(m, nil, nil)
+		nes, err := system.NewNESWithControllers(image, nil, p.Controller(0), p.Controller(1), opts)
+		if err != nil {
+			return err
+		}
+		if err := p.Begin(nes); err != nil {
+			return err
+		}
+		for !p.Done() {
+			if err := p.RunFrame(); err != nil {
+				return err
+			}
+		}
+		return p.Verify()
+	}
+	base := system.Options{FDSBIOS: opts.FDSBIOS}
+	if err := play(m, m.ApplyOptions(base)); err != nil {
+		t.Fatal(err)
+	}
+	if err := play(m, base); err == nil {
+		t.Error("movie played back with other options did not desync")
+	}
+	withoutDisk := *m
+	withoutDisk.Disk = nil
+	if err := play(&withoutDisk, m.ApplyOptions(base)); err == nil {
+		t.Error("movie played back without its disk changes did not desync")
+	}
+}
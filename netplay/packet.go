@@ -0,0 +1,77 @@
+package netplay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/rhallman96/nesquack/system"
+)
+
+var packetMagic = []uint8("NQNP")
+
+const (
+	// most inputs sent in one packet
+	maxPacketInputs = 128
+
+	noHash = -1
+)
+
+// packet carries a peer's inputs from frame start on, the number of the
+// other peer's inputs it has received, and the state hash of its latest
+// confirmed frame. Inputs are resent until acknowledged, so lost packets
+// only delay them.
+type packet struct {
+	ack       uint32
+	start     uint32
+	inputs    []system.Buttons
+	hashFrame int32
+	hash      uint64
+}
+
+func (p *packet) encode() []uint8 {
+	var b bytes.Buffer
+	b.Write(packetMagic)
+	binary.Write(&b, binary.LittleEndian, p.ack)
+	binary.Write(&b, binary.LittleEndian, p.start)
+	b.WriteByte(uint8(len(p.inputs)))
+	for _, in := range p.inputs {
+		b.WriteByte(uint8(in))
+	}
+	binary.Write(&b, binary.LittleEndian, p.hashFrame)
+	binary.Write(&b, binary.LittleEndian, p.hash)
+	return b.Bytes()
+}
+
+func decodePacket(data []uint8) (*packet, error) {
+	if !bytes.HasPrefix(data, packetMagic) {
+		return nil, errors.New("not a netplay packet")
+	}
+	r := bytes.NewReader(data[len(packetMagic):])
+	p := &packet{}
+	var count uint8
+	if err := binary.Read(r, binary.LittleEndian, &p.ack); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &p.start); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	inputs := make([]uint8, count)
+	if _, err := io.ReadFull(r, inputs); err != nil {
+		return nil, err
+	}
+	for _, in := range inputs {
+		p.inputs = append(p.inputs, system.Buttons(in))
+	}
+	if err := binary.Read(r, binary.LittleEndian, &p.hashFrame); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &p.hash); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
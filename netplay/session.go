@@ -0,0 +1,442 @@
+// Package netplay lets two players share a game over UDP with rollback.
+//
+// Each peer runs the whole game. Local input is sent to the other peer and
+// applied a few frames later, and the other peer's input is predicted until
+// it arrives. When a prediction turns out wrong, the game is rolled back to a
+// save state from before it and replayed with the real input. Peers compare
+// state hashes of confirmed frames to detect desyncs.
+package netplay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"time"
+
+	"github.com/rhallman96/nesquack/system"
+)
+
+const (
+	// DefaultInputDelay hides most of the network latency without rollbacks.
+	DefaultInputDelay = 2
+
+	// DefaultMaxRollback is the most frames a peer may run ahead of the
+	// input it has received.
+	DefaultMaxRollback = 8
+
+	// DefaultTimeout is how long to wait to hear from the other peer.
+	DefaultTimeout = 5 * time.Second
+
+	// number of confirmed state hashes kept to compare with the other peer
+	hashHistory = 256
+
+	// how long to wait for packets while stalled, and otherwise. Reads with
+	// an expired deadline fail without taking queued packets.
+	stallWait = time.Millisecond
+	pollWait  = 100 * time.Microsecond
+
+	// how long a finished peer keeps answering, as the other peer may not
+	// know yet that its last input arrived
+	lingerTime = 200 * time.Millisecond
+)
+
+// Config configures a netplay session.
+type Config struct {
+	// Player is the port the local input drives, 0 or 1.
+	Player int
+
+	// InputDelay is the number of frames local input is delayed by.
+	InputDelay int
+
+	// MaxRollback is the most frames that may be replayed. The session
+	// stalls rather than run further ahead of the other peer.
+	MaxRollback int
+
+	Timeout time.Duration
+}
+
+// DefaultConfig returns the default configuration for player.
+func DefaultConfig(player int) Config {
+	return Config{
+		Player:      player,
+		InputDelay:  DefaultInputDelay,
+		MaxRollback: DefaultMaxRollback,
+		Timeout:     DefaultTimeout,
+	}
+}
+
+// Session is one peer of a netplay game.
+type Session struct {
+	conn   net.PacketConn
+	remote net.Addr
+	cfg    Config
+	nes    system.NES
+	local  system.Controller
+
+	// the controllers passed to the NES
+	ports [2]system.Buttons
+
+	// frame is the next frame to run
+	frame int
+
+	localInputs  []system.Buttons
+	remoteInputs []system.Buttons
+
+	// the remote input each frame was last run with, predicted or not
+	usedRemote []system.Buttons
+
+	// the earliest frame run with a wrong prediction, or -1
+	mispredicted int
+
+	// the number of local inputs the other peer has received
+	remoteAck int
+
+	// save states from the start of the last MaxRollback + 1 frames
+	states      [][]uint8
+	stateFrames []int
+
+	// state hashes of confirmed frames, and those the other peer sent
+	hashes       map[int]uint64
+	remoteHashes map[int]uint64
+	lastHashed   int
+
+	lastHeard time.Time
+	desync    error
+
+	// Rollbacks counts the frames replayed after wrong predictions.
+	Rollbacks int
+}
+
+// NewSession creates a session exchanging input over conn with the peer at
+// remote, with local as the local player's controller.
+func NewSession(conn net.PacketConn, remote net.Addr, local system.Controller, cfg Config) (*Session, error) {
+	if cfg.Player != 0 && cfg.Player != 1 {
+		return nil, errors.New(fmt.Sprintf("invalid player %d", cfg.Player))
+	}
+	if cfg.MaxRollback < 1 {
+		cfg.MaxRollback = DefaultMaxRollback
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+
+	s := &Session{
+		conn:         conn,
+		remote:       remote,
+		cfg:          cfg,
+		local:        local,
+		mispredicted: -1,
+		states:       make([][]uint8, cfg.MaxRollback+1),
+		stateFrames:  make([]int, cfg.MaxRollback+1),
+		hashes:       map[int]uint64{},
+		remoteHashes: map[int]uint64{},
+		lastHashed:   -1,
+	}
+	for i := range s.stateFrames {
+		s.stateFrames[i] = -1
+	}
+	// the first frames run before any input arrives, so both peers send
+	// empty input for them
+	for i := 0; i < cfg.InputDelay; i++ {
+		s.localInputs = append(s.localInputs, 0)
+	}
+	return s, nil
+}
+
+// Controller returns the controller for a port, to be passed to the NES.
+func (s *Session) Controller(port int) system.Controller {
+	return &s.ports[port]
+}
+
+// Start begins the game from the current state of nes, which must match the
+// other peer's, as it does at power on.
+func (s *Session) Start(nes system.NES) error {
+	s.nes = nes
+	s.lastHeard = time.Now()
+	return s.saveState()
+}
+
+// Frame returns the number of frames run.
+func (s *Session) Frame() int {
+	return s.frame
+}
+
+// Confirmed returns the number of frames whose input from both players is
+// known.
+func (s *Session) Confirmed() int {
+	if len(s.remoteInputs) < s.frame {
+		return len(s.remoteInputs)
+	}
+	return s.frame
+}
+
+// Acknowledged indicates if the other peer has received the local input for
+// every frame run.
+func (s *Session) Acknowledged() bool {
+	return s.remoteAck >= s.frame
+}
+
+// AdvanceFrame runs the next frame with the local controller's input. It
+// returns false without running a frame if the session is too far ahead of
+// the other peer.
+func (s *Session) AdvanceFrame() (bool, error) {
+	if err := s.sync(pollWait); err != nil {
+		return false, err
+	}
+	if s.frame-len(s.remoteInputs) >= s.cfg.MaxRollback {
+		// wait briefly for input, so stalled peers do not spin
+		if err := s.sync(stallWait); err != nil {
+			return false, err
+		}
+		if s.frame-len(s.remoteInputs) >= s.cfg.MaxRollback {
+			return false, s.send()
+		}
+	}
+
+	s.localInputs = append(s.localInputs, system.ReadButtons(s.local))
+	if err := s.runFrame(true); err != nil {
+		return false, err
+	}
+	s.hashConfirmed()
+	return true, s.send()
+}
+
+// Sync receives input and replays mispredicted frames without running a new
+// frame, as is needed to confirm the last frames of a game.
+func (s *Session) Sync() error {
+	if err := s.sync(stallWait); err != nil {
+		return err
+	}
+	return s.send()
+}
+
+// Finish stops running frames and exchanges input until both peers have
+// confirmed every frame run, so that their last states can be compared.
+func (s *Session) Finish() error {
+	for s.Confirmed() < s.frame || !s.Acknowledged() {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+	for end := time.Now().Add(lingerTime); time.Now().Before(end); {
+		if err := s.receive(stallWait); err != nil {
+			return err
+		}
+		if err := s.send(); err != nil {
+			return err
+		}
+	}
+	return s.desync
+}
+
+func (s *Session) sync(wait time.Duration) error {
+	if err := s.receive(wait); err != nil {
+		return err
+	}
+	if s.desync != nil {
+		return s.desync
+	}
+	if time.Since(s.lastHeard) > s.cfg.Timeout {
+		return errors.New("timed out waiting for the other player")
+	}
+	if err := s.rollback(); err != nil {
+		return err
+	}
+	s.hashConfirmed()
+	return s.desync
+}
+
+// runFrame runs s.frame with the best known input, and saves the state after
+// it.
+func (s *Session) runFrame(video bool) error {
+	f := s.frame
+	remote := s.remoteInput(f)
+	if f < len(s.usedRemote) {
+		s.usedRemote[f] = remote
+	} else {
+		s.usedRemote = append(s.usedRemote, remote)
+	}
+
+	s.ports[s.cfg.Player] = s.localInputs[f]
+	s.ports[1-s.cfg.Player] = remote
+	s.nes.SetVideoEnabled(video)
+	err := s.nes.RunFrame()
+	s.nes.SetVideoEnabled(true)
+	if err != nil {
+		return err
+	}
+	s.frame++
+	return s.saveState()
+}
+
+// remoteInput returns the other peer's input for a frame, or predicts it
+// from the last input received.
+func (s *Session) remoteInput(f int) system.Buttons {
+	if f < len(s.remoteInputs) {
+		return s.remoteInputs[f]
+	}
+	if len(s.remoteInputs) > 0 {
+		return s.remoteInputs[len(s.remoteInputs)-1]
+	}
+	return 0
+}
+
+// rollback replays the frames since the earliest wrong prediction.
+func (s *Session) rollback() error {
+	f := s.mispredicted
+	if f < 0 {
+		return nil
+	}
+	s.mispredicted = -1
+
+	state := s.state(f)
+	if state == nil {
+		return errors.New(fmt.Sprintf("cannot roll back to frame %d", f))
+	}
+	if err := s.nes.LoadState(bytes.NewReader(state)); err != nil {
+		return err
+	}
+	end := s.frame
+	s.frame = f
+	for s.frame < end {
+		s.Rollbacks++
+		// only the last replayed frame is shown
+		if err := s.runFrame(s.frame == end-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Session) saveState() error {
+	var b bytes.Buffer
+	if err := s.nes.SaveState(&b); err != nil {
+		return err
+	}
+	i := s.frame % len(s.states)
+	s.states[i] = b.Bytes()
+	s.stateFrames[i] = s.frame
+	return nil
+}
+
+// state returns the save state from the start of frame f, if it is kept.
+func (s *Session) state(f int) []uint8 {
+	i := f % len(s.states)
+	if s.stateFrames[i] != f {
+		return nil
+	}
+	return s.states[i]
+}
+
+// hashConfirmed hashes the states of newly confirmed frames.
+func (s *Session) hashConfirmed() {
+	for f := s.lastHashed + 1; f <= s.Confirmed(); f++ {
+		s.lastHashed = f
+		delete(s.remoteHashes, f-hashHistory)
+		state := s.state(f)
+		if state == nil {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write(state)
+		s.hashes[f] = h.Sum64()
+		delete(s.hashes, f-hashHistory)
+		s.compareHash(f)
+	}
+}
+
+// compareHash flags a desync if both peers have hashed a frame differently.
+func (s *Session) compareHash(f int) {
+	local, ok := s.hashes[f]
+	if !ok {
+		return
+	}
+	remote, ok := s.remoteHashes[f]
+	if !ok {
+		return
+	}
+	delete(s.remoteHashes, f)
+	if local != remote {
+		s.desync = errors.New(fmt.Sprintf("desync detected at frame %d", f))
+	}
+}
+
+// StateHash returns the hash of the state at the start of a confirmed frame,
+// if it is still kept.
+func (s *Session) StateHash(frame int) (uint64, bool) {
+	h, ok := s.hashes[frame]
+	return h, ok
+}
+
+func (s *Session) send() error {
+	p := &packet{
+		ack:       uint32(len(s.remoteInputs)),
+		start:     uint32(s.remoteAck),
+		hashFrame: noHash,
+	}
+	end := len(s.localInputs)
+	if end-s.remoteAck > maxPacketInputs {
+		end = s.remoteAck + maxPacketInputs
+	}
+	p.inputs = s.localInputs[s.remoteAck:end]
+	if h, ok := s.hashes[s.lastHashed]; ok {
+		p.hashFrame = int32(s.lastHashed)
+		p.hash = h
+	}
+	_, err := s.conn.WriteTo(p.encode(), s.remote)
+	return err
+}
+
+// receive handles every packet that has arrived, waiting up to wait for the
+// first.
+func (s *Session) receive(wait time.Duration) error {
+	buf := make([]uint8, 2048)
+	deadline := time.Now().Add(wait)
+	for {
+		if err := s.conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				return nil
+			}
+			return err
+		}
+		// later packets are only taken if already waiting
+		deadline = time.Now()
+		if addr.String() != s.remote.String() {
+			continue
+		}
+		p, err := decodePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.handle(p)
+	}
+}
+
+func (s *Session) handle(p *packet) {
+	s.lastHeard = time.Now()
+	if int(p.ack) > s.remoteAck && int(p.ack) <= len(s.localInputs) {
+		s.remoteAck = int(p.ack)
+	}
+
+	for i, in := range p.inputs {
+		f := int(p.start) + i
+		if f != len(s.remoteInputs) {
+			continue
+		}
+		s.remoteInputs = append(s.remoteInputs, in)
+		if f < s.frame && s.usedRemote[f] != in && (s.mispredicted < 0 || f < s.mispredicted) {
+			s.mispredicted = f
+		}
+	}
+
+	if f := int(p.hashFrame); p.hashFrame != noHash && f > s.lastHashed-hashHistory {
+		s.remoteHashes[f] = p.hash
+		s.compareHash(f)
+	}
+}
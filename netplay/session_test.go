@@ -0,0 +1,121 @@
+package netplay
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rhallman96/nesquack/internal/testrom"
+	"github.com/rhallman96/nesquack/system"
+)
+
+// lossyConn drops every third packet written.
+type lossyConn struct {
+	net.PacketConn
+	writes int
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.writes++
+	if c.writes%3 == 0 {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+type peerResult struct {
+	hash      uint64
+	rollbacks int
+	err       error
+}
+
+// runPeer plays frames with input that changes every few frames, and returns
+// the hash of the final confirmed state.
+func runPeer(conn net.PacketConn, remote net.Addr, cfg Config, opts system.Options, frames int) peerResult {
+	player := cfg.Player
+	input := new(system.Buttons)
+	s, err := NewSession(&lossyConn{PacketConn: conn}, remote, input, cfg)
+	if err != nil {
+		return peerResult{err: err}
+	}
+	nes, err := system.NewNESWithControllers(testrom.Pad, nil, s.Controller(0), s.Controller(1), opts)
+	if err != nil {
+		return peerResult{err: err}
+	}
+	if err := s.Start(nes); err != nil {
+		return peerResult{err: err}
+	}
+
+	for s.Frame() < frames {
+		*input = system.Buttons((s.Frame() / (3 + player)) * (17 + player))
+		if _, err := s.AdvanceFrame(); err != nil {
+			return peerResult{err: err}
+		}
+	}
+	if err := s.Finish(); err != nil {
+		return peerResult{err: err}
+	}
+	hash, _ := s.StateHash(frames)
+	return peerResult{hash: hash, rollbacks: s.Rollbacks}
+}
+
+// runPeers plays a game between two sessions over loopback.
+func runPeers(t *testing.T, opts [2]system.Options, frames int) [2]peerResult {
+	var conns [2]net.PacketConn
+	for i := range conns {
+		c, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Skipf("loopback unavailable: %s", err)
+		}
+		defer c.Close()
+		conns[i] = c
+	}
+
+	var results [2]peerResult
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cfg := DefaultConfig(i)
+			// without input delay, remote input is predicted almost every
+			// frame it changes
+			cfg.InputDelay = 0
+			cfg.Timeout = time.Second
+			results[i] = runPeer(conns[i], conns[1-i].LocalAddr(), cfg, opts[i], frames)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+func TestLoopback(t *testing.T) {
+	const frames = 300
+	results := runPeers(t, [2]system.Options{}, frames)
+
+	for i, r := range results {
+		if r.err != nil {
+			t.Fatalf("player %d: %s", i+1, r.err)
+		}
+	}
+	if results[0].hash != results[1].hash {
+		t.Errorf("final states differ: %x and %x", results[0].hash, results[1].hash)
+	}
+	// input changes every few frames, so some predictions must fail
+	if results[0].rollbacks+results[1].rollbacks == 0 {
+		t.Error("neither player rolled back")
+	}
+}
+
+func TestDesync(t *testing.T) {
+	// different power-on RAM makes the peers' states differ from the start
+	results := runPeers(t, [2]system.Options{{}, {RAM: system.RAMOnes}}, 300)
+	for _, r := range results {
+		if r.err != nil && strings.Contains(r.err.Error(), "desync") {
+			return
+		}
+	}
+	t.Errorf("desync not detected: %v, %v", results[0].err, results[1].err)
+}
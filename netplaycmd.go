@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/rhallman96/nesquack/gui"
+	"github.com/rhallman96/nesquack/harness"
+	"github.com/rhallman96/nesquack/netplay"
+	"github.com/rhallman96/nesquack/system"
+)
+
+// runNetplay implements the netplay command, which plays a rom with another
+// instance over UDP. It returns the process exit code.
+func runNetplay(args []string) int {
+	flags := flag.NewFlagSet("netplay", flag.ExitOnError)
+	local := flags.String("local", ":7000", "UDP address to listen on")
+	remote := flags.String("remote", "", "UDP address of the other player")
+	player := flags.Int("player", 1, "controller the local player uses, 1 or 2")
+	delay := flags.Int("delay", netplay.DefaultInputDelay, "frames to delay local input by")
+	maxRollback := flags.Int("max-rollback", netplay.DefaultMaxRollback, "most frames to roll back")
+	timeout := flags.Duration("timeout", netplay.DefaultTimeout, "time to wait for the other player")
+	headless := flags.Bool("headless", false, "run without a window, with input from -script")
+	script := flags.String("script", "", "input script for headless mode")
+	frames := flags.Int("frames", 0, "frames to run in headless mode (defaults to the script's frame count)")
+	systemFlags := addOptionFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: nesquack netplay -remote host:port [flags] rom")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 || *remote == "" {
+		flags.Usage()
+		return 2
+	}
+	if *player != 1 && *player != 2 {
+		fmt.Println("player must be 1 or 2")
+		return 2
+	}
+
+	filename := flags.Arg(0)
+	rom, err := load(filename, "", nil)
+	if err != nil {
+		fmt.Println("failed to load rom from " + filename + ": " + err.Error())
+		return 1
+	}
+
+	// both players must pass the same options, or their games desync
+	options, err := systemFlags.options(rom)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", *remote)
+	if err != nil {
+		fmt.Println("invalid remote address " + *remote + ": " + err.Error())
+		return 2
+	}
+	conn, err := net.ListenPacket("udp", *local)
+	if err != nil {
+		fmt.Println("failed to listen on " + *local + ": " + err.Error())
+		return 1
+	}
+	defer conn.Close()
+
+	cfg := netplay.Config{
+		Player:      *player - 1,
+		InputDelay:  *delay,
+		MaxRollback: *maxRollback,
+		Timeout:     *timeout,
+	}
+
+	if !*headless {
+		gui.Launch(rom, gui.Config{
+			Options:       options,
+			NetplayConn:   conn,
+			NetplayRemote: addr,
+			Netplay:       cfg,
+		})
+		return 0
+	}
+
+	var s *harness.Script
+	if *script != "" {
+		s, err = loadScript(*script)
+		if err != nil {
+			fmt.Println("failed to load script from " + *script + ": " + err.Error())
+			return 2
+		}
+		if *frames == 0 {
+			*frames = s.Frames
+		}
+	}
+	if *frames <= 0 {
+		fmt.Println("headless netplay needs -frames or a script with a frame count")
+		return 2
+	}
+	if err := playHeadless(conn, addr, rom, options, s, *frames, cfg); err != nil {
+		fmt.Println("netplay failed: " + err.Error())
+		return 1
+	}
+	return 0
+}
+
+// playHeadless runs a netplay game driven by a script, and prints the state
+// hash of its last frame so that the peers can be compared.
+func playHeadless(conn net.PacketConn, remote net.Addr, rom []uint8, opts system.Options, script *harness.Script, frames int, cfg netplay.Config) error {
+	input := harness.NewScriptController(script)
+	s, err := netplay.NewSession(conn, remote, input, cfg)
+	if err != nil {
+		return err
+	}
+	nes, err := system.NewNESWithControllers(rom, nil, s.Controller(0), s.Controller(1), opts)
+	if err != nil {
+		return err
+	}
+	if err := s.Start(nes); err != nil {
+		return err
+	}
+
+	for s.Frame() < frames {
+		ran, err := s.AdvanceFrame()
+		if err != nil {
+			return err
+		}
+		if ran {
+			input.Advance()
+		}
+	}
+	if err := s.Finish(); err != nil {
+		return err
+	}
+
+	hash, _ := s.StateHash(frames)
+	fmt.Printf("frames %d rollbacks %d hash %016x\n", frames, s.Rollbacks, hash)
+	return nil
+}
+
+func loadScript(path string) (*harness.Script, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return harness.ParseScript(f)
+}
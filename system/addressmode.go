@@ -2,8 +2,10 @@ package system
 
 type addressMode func(c *cpu, bus memoryDevice, ac bool) (uint16, error)
 
+// accumulator and implied instructions make a dummy read of the next byte.
 func accumulator(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
-	return 0, nil
+	_, err := bus.read(c.pc)
+	return 0, err
 }
 
 func absolute(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
@@ -15,6 +17,14 @@ func absolute(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
 	return a, nil
 }
 
+// absoluteDeferred leaves the operand unread for the instruction, since JSR
+// fetches its high byte only after pushing the return address.
+func absoluteDeferred(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
+	a := c.pc
+	c.pc += 2
+	return a, nil
+}
+
 func absoluteX(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
 	a, err := readWord(bus, c.pc)
 	if err != nil {
@@ -22,10 +32,7 @@ func absoluteX(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
 	}
 	c.pc += 2
 	result := a + uint16(c.x)
-	if ac && result&0xff00 != a&0xff00 {
-		c.clock++
-	}
-	return result, nil
+	return result, indexedDummyRead(bus, a, result, ac)
 }
 
 func absoluteY(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
@@ -35,10 +42,7 @@ func absoluteY(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
 	}
 	c.pc += 2
 	result := a + uint16(c.y)
-	if ac && result&0xff00 != a&0xff00 {
-		c.clock++
-	}
-	return result, nil
+	return result, indexedDummyRead(bus, a, result, ac)
 }
 
 func immediate(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
@@ -48,7 +52,8 @@ func immediate(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
 }
 
 func implied(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
-	return 0, nil
+	_, err := bus.read(c.pc)
+	return 0, err
 }
 
 func indirect(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
@@ -70,6 +75,10 @@ func indirectX(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
 		return 0, err
 	}
 	c.pc++
+	// the base address is read while x is added
+	if _, err := bus.read(uint16(v)); err != nil {
+		return 0, err
+	}
 	a, err := readWordZeroPage(bus, v+c.x)
 	if err != nil {
 		return 0, err
@@ -88,10 +97,7 @@ func indirectY(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
 		return 0, err
 	}
 	result := a + uint16(c.y)
-	if ac && a&0xff00 != result&0xff00 {
-		c.clock++
-	}
-	return result, nil
+	return result, indexedDummyRead(bus, a, result, ac)
 }
 
 func relative(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
@@ -118,6 +124,9 @@ func zeroPageX(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
 		return 0, err
 	}
 	c.pc++
+	if _, err := bus.read(uint16(v)); err != nil {
+		return 0, err
+	}
 	return uint16(v + c.x), nil
 }
 
@@ -127,5 +136,19 @@ func zeroPageY(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
 		return 0, err
 	}
 	c.pc++
+	if _, err := bus.read(uint16(v)); err != nil {
+		return 0, err
+	}
 	return uint16(v + c.y), nil
 }
+
+// indexedDummyRead makes the read performed by indexed addressing before the
+// high byte of the address is fixed. Instructions that only read (ac) skip it
+// unless indexing crosses a page; writes always make it.
+func indexedDummyRead(bus memoryDevice, base, a uint16, ac bool) error {
+	if ac && !pageCrossed(base, a) {
+		return nil
+	}
+	_, err := bus.read((base & 0xff00) | (a & 0x00ff))
+	return err
+}
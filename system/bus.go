@@ -17,8 +17,10 @@ const (
 	ppuRegistersMirror   uint16 = 0x8
 
 	// mapped device registers
-	ppuOAMAddr   uint16 = 0x4014
-	p1JoypadAddr uint16 = 0x4016
+	ppuOAMDataAddr uint16 = 0x2004
+	ppuOAMAddr     uint16 = 0x4014
+	p1JoypadAddr   uint16 = 0x4016
+	p2JoypadAddr   uint16 = 0x4017
 
 	// cartridge address range
 	cartridgeLowAddr  uint16 = 0x4020
@@ -56,18 +58,55 @@ type cpuBus struct {
 	ppu       *ppu
 	cartridge cartridge
 
-	joypad1 *joypad
+	joypad1, joypad2 *joypad
+
+	// set if the cartridge needs to be clocked along with the CPU
+	clocked clockedCartridge
+
+	// set by writes to the OAM DMA register until the CPU performs the DMA
+	dmaPending bool
+	dmaPage    uint8
+
+	// the last value on the data bus, returned for unmapped reads
+	openBus uint8
 }
 
-func newCPUBus(p *ppu, c cartridge, j1 *joypad) *cpuBus {
+// errOpenBus is returned by cartridges for reads of unmapped addresses, which
+// the CPU bus answers with its open bus value.
+var errOpenBus = errors.New("open bus")
+
+func newCPUBus(p *ppu, c cartridge, j1, j2 *joypad) *cpuBus {
+	clocked, _ := c.(clockedCartridge)
 	return &cpuBus{
 		ppu:       p,
 		cartridge: c,
 		joypad1:   j1,
+		joypad2:   j2,
+		clocked:   clocked,
+	}
+}
+
+func (b *cpuBus) takeDMA() (uint8, bool) {
+	if !b.dmaPending {
+		return 0, false
+	}
+	b.dmaPending = false
+	return b.dmaPage, true
+}
+
+// tick advances every device on the bus by one CPU cycle.
+func (b *cpuBus) tick() error {
+	if err := b.ppu.step(1); err != nil {
+		return err
+	}
+	if b.clocked != nil {
+		return b.clocked.step(1)
 	}
+	return nil
 }
 
 func (b *cpuBus) write(a uint16, v uint8) error {
+	b.openBus = v
 	switch {
 	case a <= wramHighAddr:
 		i := mirrorIndex(a, wramLowAddr, wramMirror)
@@ -76,9 +115,12 @@ func (b *cpuBus) write(a uint16, v uint8) error {
 		i := mirrorIndex(a, ppuRegistersLowAddr, ppuRegistersMirror)
 		return b.ppu.write(i, v)
 	case a == p1JoypadAddr:
+		// both ports share the strobe line
 		b.joypad1.write(v)
+		b.joypad2.write(v)
 	case a == ppuOAMAddr:
-		return b.ppu.oamDMA(v, b)
+		b.dmaPending = true
+		b.dmaPage = v
 	case a >= cartridgeLowAddr && a <= cartridgeHighAddr:
 		return b.cartridge.write(a, v)
 	default:
@@ -88,6 +130,18 @@ func (b *cpuBus) write(a uint16, v uint8) error {
 }
 
 func (b *cpuBus) read(a uint16) (uint8, error) {
+	v, err := b.readDevice(a)
+	if err == errOpenBus {
+		return b.openBus, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	b.openBus = v
+	return v, nil
+}
+
+func (b *cpuBus) readDevice(a uint16) (uint8, error) {
 	switch {
 	case a <= wramHighAddr:
 		i := mirrorIndex(a, wramLowAddr, wramMirror)
@@ -96,13 +150,31 @@ func (b *cpuBus) read(a uint16) (uint8, error) {
 		i := mirrorIndex(a, ppuRegistersLowAddr, ppuRegistersMirror)
 		return b.ppu.read(i)
 	case a == p1JoypadAddr:
-		return b.joypad1.read(), nil
+		// only the low bits are driven by the controller port
+		return b.joypad1.read() | (b.openBus & 0xe0), nil
+	case a == p2JoypadAddr:
+		return b.joypad2.read() | (b.openBus & 0xe0), nil
 	case a >= cartridgeLowAddr && a <= cartridgeHighAddr:
 		return b.cartridge.read(a)
-	default:
-		// TODO: test features and APU
 	}
-	return 0, nil
+	// TODO: APU registers
+	return 0, errOpenBus
+}
+
+// peek reads memory without side effects, for debugging. Registers return
+// the open bus value.
+func (b *cpuBus) peek(a uint16) uint8 {
+	switch {
+	case a <= wramHighAddr:
+		return b.wram[mirrorIndex(a, wramLowAddr, wramMirror)]
+	case a >= prgRAMLowAddr:
+		v, err := b.cartridge.read(a)
+		if err != nil {
+			return b.openBus
+		}
+		return v
+	}
+	return b.openBus
 }
 
 // ppuBus handles all memory accesses from the ppu.
@@ -137,7 +209,8 @@ func (b *ppuBus) write(a uint16, v uint8) error {
 		if i%4 == 0 {
 			i %= 0x10
 		}
-		b.paletteRAM[i] = v
+		// palette entries are 6 bits wide
+		b.paletteRAM[i] = v & 0x3f
 	}
 	return nil
 }
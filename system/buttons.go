@@ -0,0 +1,38 @@
+package system
+
+// Buttons is a set of held joypad buttons. A pointer to Buttons is a
+// Controller reporting whatever buttons it holds at the time.
+type Buttons uint8
+
+// joypad buttons, in the order the joypad reports them
+const (
+	ButtonA Buttons = 1 << iota
+	ButtonB
+	ButtonSelect
+	ButtonStart
+	ButtonUp
+	ButtonDown
+	ButtonLeft
+	ButtonRight
+)
+
+// ReadButtons returns the buttons a Controller holds.
+func ReadButtons(c Controller) Buttons {
+	var b Buttons
+	held := []bool{c.A(), c.B(), c.Select(), c.Start(), c.Up(), c.Down(), c.Left(), c.Right()}
+	for i, h := range held {
+		if h {
+			b |= 1 << uint(i)
+		}
+	}
+	return b
+}
+
+func (b Buttons) Up() bool     { return b&ButtonUp != 0 }
+func (b Buttons) Down() bool   { return b&ButtonDown != 0 }
+func (b Buttons) Left() bool   { return b&ButtonLeft != 0 }
+func (b Buttons) Right() bool  { return b&ButtonRight != 0 }
+func (b Buttons) A() bool      { return b&ButtonA != 0 }
+func (b Buttons) B() bool      { return b&ButtonB != 0 }
+func (b Buttons) Start() bool  { return b&ButtonStart != 0 }
+func (b Buttons) Select() bool { return b&ButtonSelect != 0 }
@@ -17,6 +17,7 @@ const (
 	chrBankSize    = 0x2000 // 4 KB
 	headerSize     = 0x10
 	trainerSize    = 0x200
+	trainerAddr    = 0x7000
 
 	prgRAMLowAddr  = 0x6000
 	prgRAMHighAddr = 0x7fff
@@ -40,22 +41,65 @@ type cartridge interface {
 
 	// used for mappers with scanline counters (mmc3)
 	incScanline(c *cpu) error
+
+	// saves or restores RAM and mapper registers for save states
+	serialize(s *stateCodec)
+}
+
+// clockedCartridge is implemented by cartridges with components driven by
+// the CPU clock rather than by PPU scanlines.
+type clockedCartridge interface {
+	step(cpuCycles uint64) error
+}
+
+// romInfo describes the board a ROM expects, as read from its header and
+// optionally corrected by the game database.
+type romInfo struct {
+	mapper     int
+	submapper  int
+	mirror     mirrorMode
+	prgRAMSize int
+	chrRAMSize int
+	battery    bool
+	region     Region
 }
 
 // createCartridge creates a cartridge based on the ROM's raw binary data.
 // The cartridge header is assumed to be in the iNES format (NES 2.0 is not
-// currently supported).
-func createCartridge(rom []uint8) (cartridge, error) {
+// currently supported) unless the ROM is a UNIF, Famicom Disk System, or
+// NSF image. Unless disabled by opts, known iNES games have their header values
+// corrected by the game database. The region the ROM was made for is also
+// returned.
+func createCartridge(rom []uint8, opts Options, meminit *memoryInit) (cartridge, Region, error) {
+	if isUNIF(rom) {
+		c, err := createUNIFCartridge(rom, meminit)
+		return c, RegionNTSC, err
+	}
+	if IsDiskImage(rom) {
+		f, err := newFDS(rom, opts.FDSBIOS)
+		if err != nil {
+			return nil, RegionNTSC, err
+		}
+		meminit.fill(f.prgRAM[:])
+		return f, RegionNTSC, nil
+	}
+	if IsNSF(rom) {
+		c, err := newNSF(rom)
+		if err != nil {
+			return nil, RegionNTSC, err
+		}
+		return c, c.region, nil
+	}
+
 	// load iNES flags
-	if !reflect.DeepEqual(rom[:4], inesPrefix) {
-		return nil, errors.New("rom is not in iNES format")
+	if len(rom) < headerSize || !reflect.DeepEqual(rom[:4], inesPrefix) {
+		return nil, RegionNTSC, errors.New("rom is not in iNES format")
 	}
 	prgROMSize := int(rom[4]) * prgROMBankSize
 	chrROMSize := int(rom[5]) * chrBankSize
-	mapper := (rom[7] & 0xf0) | (rom[6] >> 4)
 
 	vMirror := isBitSet(rom[6], 0)
-	// hasBattery := isBitSet(data[6], 1)
+	hasBattery := isBitSet(rom[6], 1)
 	hasTrainer := isBitSet(rom[6], 2)
 	ignoreMirror := isBitSet(rom[6], 3)
 
@@ -68,10 +112,14 @@ func createCartridge(rom []uint8) (cartridge, error) {
 		}
 	}
 
-	// initialize prgROM, prgRAM, and CHR
-	prgRAMSize := int(rom[8]) * prgRAMBankSize
-	if prgRAMSize == 0 {
-		prgRAMSize = prgRAMBankSize
+	info := romInfo{
+		mapper:     int((rom[7] & 0xf0) | (rom[6] >> 4)),
+		mirror:     ciMirror,
+		prgRAMSize: int(rom[8]) * prgRAMBankSize,
+		battery:    hasBattery,
+	}
+	if isBitSet(rom[9], 0) {
+		info.region = RegionPAL
 	}
 
 	prgROMIndex := headerSize
@@ -79,15 +127,51 @@ func createCartridge(rom []uint8) (cartridge, error) {
 		prgROMIndex += trainerSize
 	}
 	chrROMIndex := prgROMIndex + prgROMSize
+	if chrROMIndex+chrROMSize > len(rom) {
+		return nil, RegionNTSC, errors.New("rom is smaller than its header describes")
+	}
 	prgROM := rom[prgROMIndex : prgROMIndex+prgROMSize]
-	prgRAM := make([]uint8, prgRAMSize, prgRAMSize)
 	chr := rom[chrROMIndex : chrROMIndex+chrROMSize]
 
-	if chrROMSize == 0 {
-		chr = make([]uint8, 0x2000)
+	var trainer []uint8
+	if hasTrainer {
+		trainer = rom[headerSize : headerSize+trainerSize]
+	}
+
+	if !opts.DisableGameDB {
+		info = correctROMInfo(info, rom[prgROMIndex:chrROMIndex+chrROMSize])
+	}
+
+	c, err := newCartridge(info, prgROM, chr, trainer, meminit)
+	return c, info.region, err
+}
+
+// newCartridge creates a cartridge for the board described by info. PRG RAM
+// is filled by meminit, then if a trainer is provided, it is loaded into PRG RAM
+// at 0x7000.
+func newCartridge(info romInfo, prgROM, chr, trainer []uint8, meminit *memoryInit) (cartridge, error) {
+	// initialize prgRAM and CHR RAM
+	prgRAMSize := info.prgRAMSize
+	if prgRAMSize == 0 {
+		prgRAMSize = prgRAMBankSize
+	}
+	prgRAM := make([]uint8, prgRAMSize, prgRAMSize)
+	meminit.fill(prgRAM)
+	if len(trainer) > 0 {
+		i := mirrorIndex(trainerAddr, prgRAMLowAddr, uint16(prgRAMSize))
+		copy(prgRAM[i:], trainer)
+		log.Printf("Trainer: %d bytes at 0x%x", len(trainer), trainerAddr)
+	}
+
+	if len(chr) == 0 {
+		chrRAMSize := info.chrRAMSize
+		if chrRAMSize == 0 {
+			chrRAMSize = chrBankSize
+		}
+		chr = make([]uint8, chrRAMSize)
 	}
 
-	log.Printf("iNES mapper %d", mapper)
+	log.Printf("iNES mapper %d", info.mapper)
 	log.Printf("PRG ROM: %d bytes", len(prgROM))
 	log.Printf("PRG RAM: %d bytes", len(prgRAM))
 	log.Printf("CHR: %d bytes", len(chr))
@@ -95,20 +179,20 @@ func createCartridge(rom []uint8) (cartridge, error) {
 	// create a cartridge corresponding to iNES metadata
 	var c cartridge
 
-	switch mapper {
+	switch info.mapper {
 	case nromHeader:
 		c = &nrom{
 			prgROM: prgROM,
 			prgRAM: prgRAM,
 			chr:    chr,
-			mirror: ciMirror,
+			mirror: info.mirror,
 		}
 	case mmc1Header:
 		c = &mmc1{
 			prgROM:         prgROM,
 			prgRAM:         prgRAM,
 			chr:            chr,
-			mirror:         ciMirror,
+			mirror:         info.mirror,
 			prgROMBankMode: prgROMBankModeFixLast,
 			prgRAMEnabled:  true,
 		}
@@ -117,11 +201,11 @@ func createCartridge(rom []uint8) (cartridge, error) {
 			prgROM:      prgROM,
 			prgRAM:      prgRAM,
 			chr:         chr,
-			mirror:      ciMirror,
+			mirror:      info.mirror,
 			mmcRegister: true,
 		}
 	default:
-		return nil, errors.New(fmt.Sprintf("unsupported iNES mapper 0x%x", mapper))
+		return nil, errors.New(fmt.Sprintf("unsupported iNES mapper 0x%x", info.mapper))
 	}
 
 	return c, nil
@@ -0,0 +1,88 @@
+package system
+
+import (
+	"testing"
+
+	"github.com/rhallman96/nesquack/internal/testrom"
+)
+
+type nopDrawer struct{}
+
+func (d nopDrawer) DrawPixel(col, row, rgb int) {}
+func (d nopDrawer) CompleteFrame()              {}
+
+type nopController struct{}
+
+func (c nopController) Up() bool     { return false }
+func (c nopController) Down() bool   { return false }
+func (c nopController) Left() bool   { return false }
+func (c nopController) Right() bool  { return false }
+func (c nopController) A() bool      { return false }
+func (c nopController) B() bool      { return false }
+func (c nopController) Start() bool  { return false }
+func (c nopController) Select() bool { return false }
+
+func TestTrainerLoadedIntoPRGRAM(t *testing.T) {
+	trainer := make([]uint8, trainerSize)
+	for i := range trainer {
+		trainer[i] = uint8(i) ^ 0x5a
+	}
+
+	// copy the first and last trainer bytes into WRAM, then spin
+	program := []uint8{
+		0xad, 0x00, 0x70, // LDA $7000
+		0x85, 0x00, // STA $00
+		0xad, 0xff, 0x71, // LDA $71FF
+		0x85, 0x01, // STA $01
+		0x4c, 0x0a, 0xc0, // JMP $C00A
+	}
+
+	for _, mapper := range []uint8{nromHeader, mmc1Header, mmc3Header} {
+		n, err := NewNES(testrom.Build(mapper, trainer, program), nopDrawer{}, nopController{})
+		if err != nil {
+			t.Fatalf("mapper %d: %s", mapper, err)
+		}
+		for i := 0; i < 5; i++ {
+			if err := n.Step(); err != nil {
+				t.Fatalf("mapper %d: %s", mapper, err)
+			}
+		}
+
+		wram := n.(*nes).bus.wram
+		if wram[0] != trainer[0] || wram[1] != trainer[trainerSize-1] {
+			t.Errorf("mapper %d: read 0x%x 0x%x from trainer, expected 0x%x 0x%x",
+				mapper, wram[0], wram[1], trainer[0], trainer[trainerSize-1])
+		}
+	}
+}
+
+func TestMMC1IgnoresRMWDummyWrite(t *testing.T) {
+	// INC writes the old value back before the new one; the MMC1 takes
+	// only the first, so each INC of $8020 ($01) shifts in a 1
+	program := make([]uint8, 0x21)
+	copy(program, testrom.Spin([]uint8{
+		0xa9, 0x80, 0x8d, 0x00, 0x80, // LDA #$80, STA $8000
+		0xa9, 0x00, 0x8d, 0x00, 0x80, // LDA #$00, STA $8000
+		0xee, 0x20, 0x80, // INC $8020
+		0xee, 0x20, 0x80, // INC $8020
+		0xee, 0x20, 0x80, // INC $8020
+		0x8d, 0x00, 0x80, // STA $8000
+	}))
+	program[0x20] = 0x01
+	n, err := NewNES(testrom.Build(mmc1Header, nil, program), nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		if err := n.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the control register is %01110: vertical mirroring, fixed last bank
+	c := n.(*nes).cartridge.(*mmc1)
+	if c.mirror != vertical || c.prgROMBankMode != prgROMBankModeFixLast || c.sr != mmc1SRClearValue {
+		t.Errorf("control register writes gave mirroring %d, PRG mode %d, shift register $%02x",
+			c.mirror, c.prgROMBankMode, c.sr)
+	}
+}
@@ -0,0 +1,283 @@
+package system
+
+import (
+	"fmt"
+)
+
+// instructionSet65C02 is the WDC 65C02 instruction set. It extends the
+// official NMOS instructions, and every opcode that was unofficial on the
+// NMOS 6502 is either a new instruction or a NOP.
+var instructionSet65C02 = build65C02InstructionSet()
+
+func build65C02InstructionSet() [256]*instruction {
+	r := instructionSet
+
+	// undefined opcodes are NOPs of varying lengths and timings
+	for op := 0x02; op <= 0xe2; op += 0x20 {
+		if op != 0xa2 {
+			r[op] = &instruction{"NOP", nop, immediate, 2, false}
+		}
+	}
+	for op := 0x03; op <= 0xff; op += 0x08 {
+		r[op] = &instruction{"NOP", nop, singleCycle, 1, false}
+	}
+	r[0x44] = &instruction{"NOP", nop, immediate, 3, false}
+	r[0x54] = &instruction{"NOP", nop, immediate, 4, false}
+	r[0xd4] = &instruction{"NOP", nop, immediate, 4, false}
+	r[0xf4] = &instruction{"NOP", nop, immediate, 4, false}
+	r[0x5c] = &instruction{"NOP", nop, absolute, 8, false}
+	r[0xdc] = &instruction{"NOP", nop, absolute, 4, false}
+	r[0xfc] = &instruction{"NOP", nop, absolute, 4, false}
+
+	// zero page indirect addressing
+	r[0x12] = &instruction{"ORA", ora, zeroPageIndirect, 5, false}
+	r[0x32] = &instruction{"AND", and, zeroPageIndirect, 5, false}
+	r[0x52] = &instruction{"EOR", eor, zeroPageIndirect, 5, false}
+	r[0x72] = &instruction{"ADC", adc, zeroPageIndirect, 5, false}
+	r[0x92] = &instruction{"STA", sta, zeroPageIndirect, 5, false}
+	r[0xb2] = &instruction{"LDA", lda, zeroPageIndirect, 5, false}
+	r[0xd2] = &instruction{"CMP", cmp, zeroPageIndirect, 5, false}
+	r[0xf2] = &instruction{"SBC", sbc, zeroPageIndirect, 5, false}
+
+	// BIT
+	r[0x89] = &instruction{"BIT", bitImmediate, immediate, 2, false}
+	r[0x34] = &instruction{"BIT", bit, zeroPageX, 4, false}
+	r[0x3c] = &instruction{"BIT", bit, absoluteX, 4, true}
+
+	// BRA
+	r[0x80] = &instruction{"BRA", bra, relative, 2, false}
+
+	// DEC, INC
+	r[0x3a] = &instruction{"DEC", decAcc, accumulator, 2, false}
+	r[0x1a] = &instruction{"INC", incAcc, accumulator, 2, false}
+
+	// JMP, without the page wrapping bug of the NMOS 6502
+	r[0x6c] = &instruction{"JMP", jmp, absoluteIndirect, 6, false}
+	r[0x7c] = &instruction{"JMP", jmp, absoluteIndirectX, 6, false}
+
+	// PHX, PHY, PLX, PLY
+	r[0xda] = &instruction{"PHX", phx, implied, 3, false}
+	r[0x5a] = &instruction{"PHY", phy, implied, 3, false}
+	r[0xfa] = &instruction{"PLX", plx, implied, 4, false}
+	r[0x7a] = &instruction{"PLY", ply, implied, 4, false}
+
+	// read-modify-write shifts only take an extra cycle when indexing
+	// crosses a page
+	r[0x1e] = &instruction{"ASL", asl, absoluteX, 6, true}
+	r[0x5e] = &instruction{"LSR", lsr, absoluteX, 6, true}
+	r[0x3e] = &instruction{"ROL", rol, absoluteX, 6, true}
+	r[0x7e] = &instruction{"ROR", ror, absoluteX, 6, true}
+
+	// STZ
+	r[0x64] = &instruction{"STZ", stz, zeroPage, 3, false}
+	r[0x74] = &instruction{"STZ", stz, zeroPageX, 4, false}
+	r[0x9c] = &instruction{"STZ", stz, absolute, 4, false}
+	r[0x9e] = &instruction{"STZ", stz, absoluteX, 5, false}
+
+	// TRB, TSB
+	r[0x14] = &instruction{"TRB", trb, zeroPage, 5, false}
+	r[0x1c] = &instruction{"TRB", trb, absolute, 6, false}
+	r[0x04] = &instruction{"TSB", tsb, zeroPage, 5, false}
+	r[0x0c] = &instruction{"TSB", tsb, absolute, 6, false}
+
+	// RMB, SMB, BBR, BBS
+	for b := uint8(0); b < 8; b++ {
+		op := b << 4
+		r[op|0x07] = &instruction{fmt.Sprintf("RMB%d", b), rmb(b), zeroPage, 5, false}
+		r[op|0x87] = &instruction{fmt.Sprintf("SMB%d", b), smb(b), zeroPage, 5, false}
+		r[op|0x0f] = &instruction{fmt.Sprintf("BBR%d", b), bbr(b), zeroPage, 5, false}
+		r[op|0x8f] = &instruction{fmt.Sprintf("BBS%d", b), bbs(b), zeroPage, 5, false}
+	}
+
+	// STP, WAI
+	r[0xdb] = &instruction{"STP", kil, implied, 3, false}
+	r[0xcb] = &instruction{"WAI", wai, implied, 3, false}
+
+	return r
+}
+
+// singleCycle is used by NOPs that take a single cycle, without the dummy
+// read made by implied instructions.
+func singleCycle(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
+	return 0, nil
+}
+
+func zeroPageIndirect(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
+	v, err := bus.read(c.pc)
+	if err != nil {
+		return 0, err
+	}
+	c.pc++
+	return readWordZeroPage(bus, v)
+}
+
+func absoluteIndirect(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
+	a, err := readWord(bus, c.pc)
+	if err != nil {
+		return 0, err
+	}
+	c.pc += 2
+	return readWord(bus, a)
+}
+
+func absoluteIndirectX(c *cpu, bus memoryDevice, ac bool) (uint16, error) {
+	a, err := readWord(bus, c.pc)
+	if err != nil {
+		return 0, err
+	}
+	c.pc += 2
+	return readWord(bus, a+uint16(c.x))
+}
+
+// bitImmediate only sets the zero flag, as the operand is not in memory.
+func bitImmediate(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := bus.read(a)
+	if err != nil {
+		return err
+	}
+	c.setZeroFlag(v & c.a)
+	return nil
+}
+
+func bra(c *cpu, bus memoryDevice, a uint16) error {
+	return branch(c, a)
+}
+
+func decAcc(c *cpu, bus memoryDevice, a uint16) error {
+	c.a--
+	c.setSignFlag(c.a)
+	c.setZeroFlag(c.a)
+	return nil
+}
+
+func incAcc(c *cpu, bus memoryDevice, a uint16) error {
+	c.a++
+	c.setSignFlag(c.a)
+	c.setZeroFlag(c.a)
+	return nil
+}
+
+func phx(c *cpu, bus memoryDevice, a uint16) error {
+	return c.push(bus, c.x)
+}
+
+func phy(c *cpu, bus memoryDevice, a uint16) error {
+	return c.push(bus, c.y)
+}
+
+func plx(c *cpu, bus memoryDevice, a uint16) error {
+	err := c.stackDummyRead(bus)
+	if err != nil {
+		return err
+	}
+	v, err := c.pull(bus)
+	if err != nil {
+		return err
+	}
+	c.x = v
+	c.setSignFlag(c.x)
+	c.setZeroFlag(c.x)
+	return nil
+}
+
+func ply(c *cpu, bus memoryDevice, a uint16) error {
+	err := c.stackDummyRead(bus)
+	if err != nil {
+		return err
+	}
+	v, err := c.pull(bus)
+	if err != nil {
+		return err
+	}
+	c.y = v
+	c.setSignFlag(c.y)
+	c.setZeroFlag(c.y)
+	return nil
+}
+
+func stz(c *cpu, bus memoryDevice, a uint16) error {
+	return bus.write(a, 0)
+}
+
+func trb(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := readModifyWrite(c, bus, a)
+	if err != nil {
+		return err
+	}
+	c.setZeroFlag(v & c.a)
+	return bus.write(a, v&^c.a)
+}
+
+func tsb(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := readModifyWrite(c, bus, a)
+	if err != nil {
+		return err
+	}
+	c.setZeroFlag(v & c.a)
+	return bus.write(a, v|c.a)
+}
+
+// rmb returns an operation that resets a bit in zero page memory.
+func rmb(b uint8) operation {
+	return func(c *cpu, bus memoryDevice, a uint16) error {
+		v, err := readModifyWrite(c, bus, a)
+		if err != nil {
+			return err
+		}
+		return bus.write(a, v&^(1<<b))
+	}
+}
+
+// smb returns an operation that sets a bit in zero page memory.
+func smb(b uint8) operation {
+	return func(c *cpu, bus memoryDevice, a uint16) error {
+		v, err := readModifyWrite(c, bus, a)
+		if err != nil {
+			return err
+		}
+		return bus.write(a, v|(1<<b))
+	}
+}
+
+// bbr returns an operation that branches if a bit in zero page memory is
+// reset.
+func bbr(b uint8) operation {
+	return func(c *cpu, bus memoryDevice, a uint16) error {
+		return branchOnBit(c, bus, a, b, false)
+	}
+}
+
+// bbs returns an operation that branches if a bit in zero page memory is set.
+func bbs(b uint8) operation {
+	return func(c *cpu, bus memoryDevice, a uint16) error {
+		return branchOnBit(c, bus, a, b, true)
+	}
+}
+
+// branchOnBit tests a bit at a zero page address, then reads the relative
+// branch target that follows the address operand. The branch itself takes
+// extra cycles like any other.
+func branchOnBit(c *cpu, bus memoryDevice, a uint16, b uint8, set bool) error {
+	v, err := bus.read(a)
+	if err != nil {
+		return err
+	}
+	// the zero page value is read again while the bit is tested
+	if _, err := bus.read(a); err != nil {
+		return err
+	}
+	dest, err := relative(c, bus, false)
+	if err != nil {
+		return err
+	}
+	if ((v & (1 << b)) != 0) == set {
+		return branch(c, dest)
+	}
+	return nil
+}
+
+// wai waits for an interrupt, clocking the rest of the system meanwhile.
+func wai(c *cpu, bus memoryDevice, a uint16) error {
+	c.waiting = true
+	return nil
+}
@@ -0,0 +1,41 @@
+package system
+
+import (
+	"testing"
+
+	"github.com/rhallman96/nesquack/internal/testrom"
+)
+
+func TestBranchOnBitCycles(t *testing.T) {
+	// $10 is zero, so BBR0 branches and BBS0 does not
+	rom := testrom.NROM([]uint8{
+		0x0f, 0x10, 0x00, // BBR0 $10, +0
+		0x8f, 0x10, 0x00, // BBS0 $10, +0
+		0x0f, 0x10, 0x80, // BBR0 $10, -128, into the previous page
+	})
+	n, err := NewNESWithOptions(rom, nil, nopController{}, Options{CPU: CPU65C02})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cycles uint64
+		pc     uint16
+	}{
+		{"taken", 6, 0xc003},
+		{"not taken", 5, 0xc006},
+		{"taken across a page", 7, 0xbf89},
+	}
+	for _, tt := range tests {
+		start := n.CPUState().Cycles
+		if err := n.Step(); err != nil {
+			t.Fatal(err)
+		}
+		s := n.CPUState()
+		if s.Cycles-start != tt.cycles || s.PC != tt.pc {
+			t.Errorf("%s: took %d cycles to $%04X, want %d cycles to $%04X",
+				tt.name, s.Cycles-start, s.PC, tt.cycles, tt.pc)
+		}
+	}
+}
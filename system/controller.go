@@ -27,6 +27,8 @@ type Controller interface {
 	Select() bool
 }
 
+// joypad is a controller port. An unplugged port has no controller and
+// reads as no buttons held.
 type joypad struct {
 	controller Controller
 	strobe     bool
@@ -42,6 +44,9 @@ func (j *joypad) read() uint8 {
 	if j.input <= buttonRight {
 		j.input++
 	}
+	if j.controller == nil {
+		return 0
+	}
 
 	var result uint8 = 0
 
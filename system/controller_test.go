@@ -0,0 +1,32 @@
+package system
+
+import "testing"
+
+func TestControllerPorts(t *testing.T) {
+	p1, p2 := ButtonA|ButtonRight, ButtonStart|ButtonUp
+	b := &cpuBus{joypad1: &joypad{controller: p1}, joypad2: &joypad{controller: p2}}
+
+	// a single strobe latches both ports
+	b.write(p1JoypadAddr, 1)
+	b.write(p1JoypadAddr, 0)
+	var got [2]Buttons
+	for i := uint(0); i < 8; i++ {
+		v1, _ := b.read(p1JoypadAddr)
+		v2, _ := b.read(p2JoypadAddr)
+		got[0] |= Buttons(v1&1) << i
+		got[1] |= Buttons(v2&1) << i
+	}
+	if got[0] != p1 || got[1] != p2 {
+		t.Errorf("read %08b and %08b, want %08b and %08b", got[0], got[1], p1, p2)
+	}
+
+	// an unplugged port reads as no buttons held
+	b.joypad2.controller = nil
+	b.write(p1JoypadAddr, 1)
+	b.write(p1JoypadAddr, 0)
+	for i := 0; i < 8; i++ {
+		if v, _ := b.read(p2JoypadAddr); v&1 != 0 {
+			t.Fatalf("unplugged port reported button %d held", i)
+		}
+	}
+}
@@ -5,9 +5,13 @@ import (
 	"fmt"
 )
 
-// cpu implements the Ricoh 2A03 (a MOS 6502 derivative) instruction set.
+// cpu implements the Ricoh 2A03 (a MOS 6502 derivative) instruction set, or
+// optionally that of another 6502 variant.
 type cpu struct {
-	bus *cpuBus
+	bus cpuMemory
+
+	variant      CPUVariant
+	instructions *[256]*instruction
 
 	pc             uint16
 	a, x, y, p, sp uint8
@@ -15,6 +19,21 @@ type cpu struct {
 
 	// nmi is falling edge sensitive
 	irq, nmi bool
+
+	// interrupts as polled during the last two cycles. Interrupts are
+	// serviced based on the poll made during an instruction's penultimate
+	// cycle, which delays the effect of CLI, SEI and PLP by one instruction.
+	runIRQ, prevRunIRQ bool
+	runNMI, prevRunNMI bool
+
+	// set by KIL opcodes, which lock up the CPU until it is reset
+	halted bool
+
+	// set by the 65C02 WAI opcode until an interrupt arrives
+	waiting bool
+
+	// logs each instruction when set
+	tracer *tracer
 }
 
 const (
@@ -23,12 +42,13 @@ const (
 	flagOverflow  uint8 = 1 << 6
 	flagBreakHi   uint8 = 1 << 5
 	flagBreak     uint8 = 1 << 4
-	flagDecimal   uint8 = 1 << 3 // not supported, but may still be set
+	flagDecimal   uint8 = 1 << 3 // ignored by the 2A03, but may still be set
 	flagInterrupt uint8 = 1 << 2
 	flagZero      uint8 = 1 << 1
 	flagCarry     uint8 = 1
 
-	// boot up register values
+	// boot up register values. At power on the stack pointer is 0 until the
+	// reset sequence moves it to 0xfd; spStartValue is used to restart NSFs.
 	spStartValue     uint8 = 0xff
 	statusStartValue uint8 = 0x34
 
@@ -37,25 +57,74 @@ const (
 	nmiVector            = 0xfffa
 	resetVector          = 0xfffc
 	irqVector            = 0xfffe
-
-	// cpu cycles used for interrupt branching
-	interruptCycles = 7
 )
 
-func newCPU(bus *cpuBus) (*cpu, error) {
+// cpuMemory is the address space of the CPU, along with the devices clocked
+// by it.
+type cpuMemory interface {
+	memoryDevice
+
+	// tick advances the devices by one CPU cycle.
+	tick() error
+
+	// peek reads memory without side effects.
+	peek(a uint16) uint8
+
+	// takeDMA returns the page of a pending OAM DMA, and clears it.
+	takeDMA() (uint8, bool)
+}
+
+// newCPU creates a CPU in its power-up state. It must be reset before it
+// executes instructions.
+func newCPU(bus cpuMemory, variant CPUVariant) *cpu {
 	r := &cpu{
-		bus: bus,
-		sp:  spStartValue,
-		p:   statusStartValue,
+		bus:          bus,
+		variant:      variant,
+		instructions: &instructionSet,
+		p:            statusStartValue,
+	}
+	if variant == CPU65C02 {
+		r.instructions = &instructionSet65C02
+	}
+	return r
+}
+
+// reset performs the 7 cycle reset sequence, which behaves like an interrupt
+// with writes to the stack suppressed.
+func (c *cpu) reset() error {
+	c.halted = false
+	c.waiting = false
+	c.nmi = false
+	c.runNMI, c.prevRunNMI = false, false
+	c.runIRQ, c.prevRunIRQ = false, false
+
+	for i := 0; i < 5; i++ {
+		if err := c.tick(); err != nil {
+			return err
+		}
 	}
+	c.sp -= 3
+	c.setFlag(flagInterrupt)
 
-	pc, err := readWord(bus, resetVector)
+	pc, err := readWord(c, resetVector)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	r.pc = pc
+	c.pc = pc
+	return nil
+}
 
-	return r, nil
+func (c *cpu) state() CPUState {
+	return CPUState{
+		PC:     c.pc,
+		A:      c.a,
+		X:      c.x,
+		Y:      c.y,
+		P:      c.p,
+		SP:     c.sp,
+		Cycles: c.clock,
+		Halted: c.halted,
+	}
 }
 
 func (c *cpu) String() string {
@@ -65,14 +134,39 @@ func (c *cpu) String() string {
 // step performs the next instruction in memory and returns how many cycles
 // it took to execute.
 func (c *cpu) step() error {
+	// a halted CPU stops fetching instructions and ignores interrupts, but
+	// the clock still runs for the rest of the system
+	if c.halted {
+		return c.tick()
+	}
+
+	// a waiting CPU resumes when an interrupt is signalled, even if IRQs
+	// are disabled
+	if c.waiting {
+		if err := c.tick(); err != nil {
+			return err
+		}
+		if c.irq || c.nmi {
+			c.waiting = false
+			return c.serviceInterrupts(c.runNMI, c.runIRQ)
+		}
+		return nil
+	}
+
+	if c.tracer != nil {
+		if err := c.tracer.trace(c); err != nil {
+			return err
+		}
+	}
+
 	// fetch the next opcode
-	op, err := c.bus.read(c.pc)
+	op, err := c.read(c.pc)
 	if err != nil {
 		return err
 	}
 
 	// decode
-	i := instructionSet[op]
+	i := c.instructions[op]
 	if i == nil {
 		return errors.New(fmt.Sprintf("unknown op 0x%x at addr 0x%x", op, c.pc))
 	}
@@ -83,22 +177,97 @@ func (c *cpu) step() error {
 		return err
 	}
 
-	// handle interrupts
-	if c.nmi {
-		c.clock += interruptCycles
+	return c.serviceInterrupts(c.prevRunNMI, c.prevRunIRQ)
+}
+
+// serviceInterrupts begins an NMI or IRQ sequence if one was polled.
+func (c *cpu) serviceInterrupts(nmi, irq bool) error {
+	switch {
+	case nmi:
 		// indicates that the interrupt was not handled during a brk instruction
 		c.setFlagValue(flagBreak, false)
-		err = c.interrupt(c.bus, nmiVector, false)
-		c.nmi = false
-	} else if !c.isFlagSet(flagInterrupt) && c.irq {
-		c.clock += interruptCycles
+		return c.hardwareInterrupt(nmiVector)
+	case irq:
 		c.setFlagValue(flagBreak, false)
-		err = c.interrupt(c.bus, irqVector, false)
+		return c.hardwareInterrupt(irqVector)
 	}
+	return nil
+}
+
+// tick advances the clock and the rest of the system by one CPU cycle, then
+// polls the interrupt lines.
+func (c *cpu) tick() error {
+	c.clock++
+	err := c.bus.tick()
 
+	c.prevRunIRQ, c.prevRunNMI = c.runIRQ, c.runNMI
+	c.runIRQ = c.irq && !c.isFlagSet(flagInterrupt)
+	c.runNMI = c.nmi
 	return err
 }
 
+// read performs a single clocked memory access. The CPU passes itself as the
+// bus to instructions so that every access, including dummy ones, takes a
+// cycle and lands on the right PPU dot.
+func (c *cpu) read(a uint16) (uint8, error) {
+	if err := c.tick(); err != nil {
+		return 0, err
+	}
+	return c.bus.read(a)
+}
+
+func (c *cpu) write(a uint16, v uint8) error {
+	if err := c.tick(); err != nil {
+		return err
+	}
+	if err := c.bus.write(a, v); err != nil {
+		return err
+	}
+	if page, ok := c.bus.takeDMA(); ok {
+		return c.oamDMA(page)
+	}
+	return nil
+}
+
+// oamDMA halts the CPU while a page of memory is copied to OAM through
+// OAMDATA. The copy takes 513 cycles, plus one to align reads to even cycles
+// when it begins on an odd cycle. The APU is not emulated, so there is no DMC
+// DMA to interleave with.
+func (c *cpu) oamDMA(page uint8) error {
+	// halt cycle
+	if err := c.tick(); err != nil {
+		return err
+	}
+	if c.clock%2 == 1 {
+		if err := c.tick(); err != nil {
+			return err
+		}
+	}
+
+	a := uint16(page) << 8
+	for i := uint16(0); i < oamSize; i++ {
+		v, err := c.read(a + i)
+		if err != nil {
+			return err
+		}
+		if err := c.write(ppuOAMDataAddr, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hardwareInterrupt performs the 7 cycle NMI or IRQ sequence, which begins
+// with two dummy reads of the next instruction.
+func (c *cpu) hardwareInterrupt(v uint16) error {
+	for i := 0; i < 2; i++ {
+		if _, err := c.read(c.pc); err != nil {
+			return err
+		}
+	}
+	return c.interrupt(c, v, false)
+}
+
 // setIRQ sets the value of the IRQ line. True represents a low state, and false
 // represents a high state.
 func (c *cpu) setIRQ(v bool) {
@@ -106,8 +275,8 @@ func (c *cpu) setIRQ(v bool) {
 }
 
 // triggerNMI sets the NMI flag to true, which represents a level transition for
-// the NMI line. The NMI flag is set to false as soon as the interrupt is handled
-// during the next invocation of step.
+// the NMI line. The NMI flag is set to false once the interrupt sequence
+// fetches the NMI vector.
 func (c *cpu) triggerNMI() {
 	c.nmi = true
 }
@@ -129,6 +298,19 @@ func (c *cpu) interrupt(bus memoryDevice, v uint16, isBreak bool) error {
 	c.push(bus, status)
 	c.setFlag(flagInterrupt)
 
+	// the 65C02 also leaves decimal mode
+	if c.variant == CPU65C02 {
+		c.setFlagValue(flagDecimal, false)
+	}
+
+	// an NMI detected before the vector is fetched hijacks BRK and IRQ
+	// sequences, and is not serviced again afterwards
+	if c.nmi {
+		v = nmiVector
+		c.nmi = false
+		c.runNMI, c.prevRunNMI = false, false
+	}
+
 	dest, err := readWord(bus, v)
 	if err != nil {
 		return err
@@ -143,6 +325,13 @@ func (c *cpu) push(bus memoryDevice, v uint8) error {
 	return err
 }
 
+// stackDummyRead is the internal cycle in which the CPU reads the stack
+// before adjusting the stack pointer, as in pulls, JSR and RTS.
+func (c *cpu) stackDummyRead(bus memoryDevice) error {
+	_, err := bus.read(spBaseAddress + uint16(c.sp))
+	return err
+}
+
 func (c *cpu) pull(bus memoryDevice) (uint8, error) {
 	c.sp++
 	v, err := bus.read(spBaseAddress + uint16(c.sp))
@@ -0,0 +1,85 @@
+package system
+
+// Memory is the address space of a CPU running outside an NES.
+type Memory interface {
+	Read(a uint16) uint8
+	Write(a uint16, v uint8)
+}
+
+// CPU is a 6502 family CPU running on its own, for use in other 6502 systems
+// and with CPU test suites. Every memory access takes one cycle.
+type CPU struct {
+	cpu *cpu
+}
+
+// flatMemory adapts a Memory for the CPU, with no other devices to clock.
+type flatMemory struct {
+	m Memory
+}
+
+func (f flatMemory) read(a uint16) (uint8, error) {
+	return f.m.Read(a), nil
+}
+
+func (f flatMemory) write(a uint16, v uint8) error {
+	f.m.Write(a, v)
+	return nil
+}
+
+func (f flatMemory) tick() error {
+	return nil
+}
+
+func (f flatMemory) peek(a uint16) uint8 {
+	return f.m.Read(a)
+}
+
+func (f flatMemory) takeDMA() (uint8, bool) {
+	return 0, false
+}
+
+// NewCPU creates a CPU of a variant attached to memory, and performs the
+// reset sequence, which loads the reset vector.
+func NewCPU(m Memory, variant CPUVariant) (*CPU, error) {
+	c := &CPU{cpu: newCPU(flatMemory{m}, variant)}
+	if err := c.cpu.reset(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Step runs the next instruction, followed by the sequence of any interrupt
+// it polled. A halted or waiting CPU runs a single cycle instead.
+func (c *CPU) Step() error {
+	return c.cpu.step()
+}
+
+// Reset performs the reset sequence.
+func (c *CPU) Reset() error {
+	return c.cpu.reset()
+}
+
+// State returns the CPU registers.
+func (c *CPU) State() CPUState {
+	return c.cpu.state()
+}
+
+// SetState sets the CPU registers, such as to start a test program at its
+// entry point.
+func (c *CPU) SetState(s CPUState) {
+	c.cpu.pc = s.PC
+	c.cpu.a, c.cpu.x, c.cpu.y = s.A, s.X, s.Y
+	c.cpu.p, c.cpu.sp = s.P, s.SP
+	c.cpu.clock = s.Cycles
+	c.cpu.halted = s.Halted
+}
+
+// SetIRQ sets the level of the IRQ line, where true is asserted.
+func (c *CPU) SetIRQ(v bool) {
+	c.cpu.setIRQ(v)
+}
+
+// NMI signals a falling edge on the NMI line.
+func (c *CPU) NMI() {
+	c.cpu.triggerNMI()
+}
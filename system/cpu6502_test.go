@@ -0,0 +1,229 @@
+package system
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// flatRAM is a 64 KB address space of RAM.
+type flatRAM [0x10000]uint8
+
+func (r *flatRAM) Read(a uint16) uint8 {
+	return r[a]
+}
+
+func (r *flatRAM) Write(a uint16, v uint8) {
+	r[a] = v
+}
+
+const flagsNVZC = flagSign | flagOverflow | flagZero | flagCarry
+
+// binaryADC returns the accumulator and flags of a binary ADC.
+func binaryADC(a, b uint8, carry bool) (uint8, uint8) {
+	sum := int(a) + int(b)
+	if carry {
+		sum++
+	}
+	r := uint8(sum)
+	var p uint8
+	if sum > 0xff {
+		p |= flagCarry
+	}
+	if (a^r)&(b^r)&0x80 != 0 {
+		p |= flagOverflow
+	}
+	return r, p | signZero(r)
+}
+
+func signZero(v uint8) uint8 {
+	p := v & flagSign
+	if v == 0 {
+		p |= flagZero
+	}
+	return p
+}
+
+// decimalADC and decimalSBC follow the sequences of Bruce Clark's "Decimal
+// Mode" tutorial, including for invalid BCD operands.
+func decimalADC(a, b uint8, carry, cmos bool) (uint8, uint8) {
+	c := 0
+	if carry {
+		c = 1
+	}
+	low := int(a&0x0f) + int(b&0x0f) + c
+	if low >= 0x0a {
+		low = ((low + 0x06) & 0x0f) + 0x10
+	}
+	sum := int(a&0xf0) + int(b&0xf0) + low
+	signed := int(int8(a&0xf0)) + int(int8(b&0xf0)) + low
+	if sum >= 0xa0 {
+		sum += 0x60
+	}
+	r := uint8(sum)
+
+	var p uint8
+	if sum >= 0x100 {
+		p |= flagCarry
+	}
+	if signed < -128 || signed > 127 {
+		p |= flagOverflow
+	}
+	if cmos {
+		return r, p | signZero(r)
+	}
+	p |= uint8(signed) & flagSign
+	if a+b+uint8(c) == 0 {
+		p |= flagZero
+	}
+	return r, p
+}
+
+func decimalSBC(a, b uint8, carry, cmos bool) (uint8, uint8) {
+	c := 0
+	if carry {
+		c = 1
+	}
+	_, p := binaryADC(a, ^b, carry)
+	low := int(a&0x0f) - int(b&0x0f) + c - 1
+	var diff int
+	if cmos {
+		diff = int(a) - int(b) + c - 1
+		if diff < 0 {
+			diff -= 0x60
+		}
+		if low < 0 {
+			diff -= 0x06
+		}
+		r := uint8(diff)
+		return r, p&(flagOverflow|flagCarry) | signZero(r)
+	}
+	if low < 0 {
+		low = ((low - 0x06) & 0x0f) - 0x10
+	}
+	diff = int(a&0xf0) - int(b&0xf0) + low
+	if diff < 0 {
+		diff -= 0x60
+	}
+	return uint8(diff), p
+}
+
+func TestDecimalMode(t *testing.T) {
+	tests := []struct {
+		variant CPUVariant
+		adc     func(a, b uint8, carry bool) (uint8, uint8)
+		sbc     func(a, b uint8, carry bool) (uint8, uint8)
+		cycles  uint64
+	}{
+		{
+			CPU2A03,
+			binaryADC,
+			func(a, b uint8, carry bool) (uint8, uint8) { return binaryADC(a, ^b, carry) },
+			2,
+		},
+		{
+			CPUNMOS6502,
+			func(a, b uint8, carry bool) (uint8, uint8) { return decimalADC(a, b, carry, false) },
+			func(a, b uint8, carry bool) (uint8, uint8) { return decimalSBC(a, b, carry, false) },
+			2,
+		},
+		{
+			CPU65C02,
+			func(a, b uint8, carry bool) (uint8, uint8) { return decimalADC(a, b, carry, true) },
+			func(a, b uint8, carry bool) (uint8, uint8) { return decimalSBC(a, b, carry, true) },
+			3,
+		},
+	}
+
+	for _, tt := range tests {
+		ram := &flatRAM{}
+		c, err := NewCPU(ram, tt.variant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops := []struct {
+			opcode uint8
+			want   func(a, b uint8, carry bool) (uint8, uint8)
+		}{
+			{0x69, tt.adc}, // ADC #
+			{0xe9, tt.sbc}, // SBC #
+		}
+		for _, op := range ops {
+			ram[0x0200] = op.opcode
+			for i := 0; i < 0x20000; i++ {
+				a, b, carry := uint8(i), uint8(i>>8), i >= 0x10000
+				p := flagBreakHi | flagDecimal
+				if carry {
+					p |= flagCarry
+				}
+				ram[0x0201] = b
+				c.SetState(CPUState{PC: 0x0200, A: a, P: p, SP: 0xfd})
+				if err := c.Step(); err != nil {
+					t.Fatal(err)
+				}
+
+				s := c.State()
+				wantA, wantP := op.want(a, b, carry)
+				if s.A != wantA || s.P&flagsNVZC != wantP || s.Cycles != tt.cycles {
+					t.Fatalf("variant %d: opcode $%02X with A=$%02X, operand $%02X, carry %t: got A=$%02X P=%08b in %d cycles, want A=$%02X P=%08b in %d cycles",
+						tt.variant, op.opcode, a, b, carry, s.A, s.P&flagsNVZC, s.Cycles, wantA, wantP, tt.cycles)
+				}
+			}
+		}
+	}
+}
+
+// Klaus Dormann's functional test is available from
+// https://github.com/Klaus2m5/6502_65C02_functional_tests as
+// bin_files/6502_functional_test.bin and is placed in testdata to run the
+// test; it is skipped without it, and in short mode as it is slow. It is
+// loaded at $0000 and started at $0400, and traps in a loop at
+// dormannSuccessAddr when every test passes, or elsewhere on a failure.
+const (
+	dormannFunctionalTest = "testdata/6502_functional_test.bin"
+	dormannStartAddr      = 0x0400
+	dormannSuccessAddr    = 0x3469
+
+	// the test takes about 96 million cycles
+	dormannMaxCycles = 200000000
+)
+
+func TestDormannFunctional(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the functional test takes hundreds of millions of cycles")
+	}
+	image, err := ioutil.ReadFile(dormannFunctionalTest)
+	if os.IsNotExist(err) {
+		t.Skipf("%s is missing", dormannFunctionalTest)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, variant := range []CPUVariant{CPUNMOS6502, CPU65C02} {
+		ram := &flatRAM{}
+		copy(ram[:], image)
+		c, err := NewCPU(ram, variant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetState(CPUState{PC: dormannStartAddr, P: flagBreakHi | flagInterrupt, SP: 0xfd})
+
+		for {
+			pc := c.State().PC
+			if err := c.Step(); err != nil {
+				t.Fatalf("variant %d: %s at $%04X", variant, err, pc)
+			}
+			s := c.State()
+			if s.PC == pc {
+				if pc != dormannSuccessAddr {
+					t.Errorf("variant %d: trapped at $%04X after %d cycles", variant, pc, s.Cycles)
+				}
+				break
+			}
+			if s.Cycles > dormannMaxCycles {
+				t.Fatalf("variant %d: no trap after %d cycles, at $%04X", variant, s.Cycles, s.PC)
+			}
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package system
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rhallman96/nesquack/internal/testrom"
+)
+
+func TestResetSequence(t *testing.T) {
+	// the reset vector points past a loop at the origin, so the CPU only
+	// runs the program if it loads the vector
+	rom := testrom.Build(nromHeader, nil, testrom.Spin([]uint8{
+		0x4c, 0x00, 0xc0, // JMP $C000
+		0x58,       // CLI
+		0xa9, 0x12, // LDA #$12
+		0xa2, 0x34, // LDX #$34
+		0xa0, 0x56, // LDY #$56
+	}))
+	testrom.SetVector(rom, testrom.ResetVector, 0xc003)
+
+	n, err := NewNES(rom, nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CPUState{PC: 0xc003, P: 0x34, SP: 0xfd, Cycles: 7}
+	if got := n.CPUState(); got != want {
+		t.Errorf("power on state is %+v, want %+v", got, want)
+	}
+
+	for i := 0; i < 4; i++ {
+		if err := n.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := n.CPUState()
+	if err := n.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reset keeps the registers, moves the stack pointer as if three bytes
+	// were pushed, and sets the interrupt flag
+	want = CPUState{PC: 0xc003, A: 0x12, X: 0x34, Y: 0x56, P: before.P | flagInterrupt, SP: 0xfa, Cycles: before.Cycles + 7}
+	if got := n.CPUState(); got != want {
+		t.Errorf("state after reset is %+v, want %+v", got, want)
+	}
+}
+
+const (
+	testNMIHandler = 0x0500
+	testIRQHandler = 0x0600
+)
+
+// interruptRAM is a flat address space that calls fire on one of its
+// accesses, counted from 1. A line changed by fire is seen from the next
+// cycle on, as if a device changed it while that cycle was clocked.
+type interruptRAM struct {
+	flatRAM
+	accesses, at int
+	fire         func()
+}
+
+func (r *interruptRAM) access() {
+	r.accesses++
+	if r.accesses == r.at && r.fire != nil {
+		r.fire()
+	}
+}
+
+func (r *interruptRAM) Read(a uint16) uint8 {
+	r.access()
+	return r.flatRAM.Read(a)
+}
+
+func (r *interruptRAM) Write(a uint16, v uint8) {
+	r.access()
+	r.flatRAM.Write(a, v)
+}
+
+// newInterruptCPU returns an NMOS CPU about to run program from pc, with
+// NOPs everywhere else in the first pages and the interrupt handlers.
+func newInterruptCPU(t *testing.T, pc uint16, program []uint8, p uint8, stack []uint8) (*CPU, *interruptRAM) {
+	m := &interruptRAM{}
+	for a := 0x0200; a < 0x0700; a++ {
+		m.flatRAM[a] = 0xea
+	}
+	copy(m.flatRAM[pc:], program)
+	m.flatRAM[0xfffa], m.flatRAM[0xfffb] = testNMIHandler&0xff, testNMIHandler>>8
+	m.flatRAM[0xfffe], m.flatRAM[0xffff] = testIRQHandler&0xff, testIRQHandler>>8
+
+	c, err := NewCPU(m, CPUNMOS6502)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first pull returns the first byte of stack
+	sp := uint8(0xfd - len(stack))
+	copy(m.flatRAM[0x0100+int(sp)+1:], stack)
+	c.SetState(CPUState{PC: pc, P: p, SP: sp})
+	m.accesses = 0
+	return c, m
+}
+
+// pushed returns the status and return address pushed by an interrupt.
+func pushed(c *CPU, m *interruptRAM) (uint8, uint16) {
+	sp := 0x0100 + int(c.State().SP)
+	return m.flatRAM[sp+1], uint16(m.flatRAM[sp+2]) | uint16(m.flatRAM[sp+3])<<8
+}
+
+func TestInterruptFlagLatency(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []uint8
+		p       uint8
+		stack   []uint8
+		steps   int
+		ret     uint16
+		pushedI bool
+	}{
+		// CLI and PLP change I after the IRQ is polled, so one more
+		// instruction runs first
+		{"CLI", []uint8{0x58}, 0x24, nil, 2, 0x0202, false},
+		{"PLP clearing I", []uint8{0x28}, 0x24, []uint8{0x20}, 2, 0x0202, false},
+		// SEI and PLP still take an IRQ polled before I was set, and push
+		// the new I flag
+		{"SEI", []uint8{0x78}, 0x20, nil, 1, 0x0201, true},
+		{"PLP setting I", []uint8{0x28}, 0x20, []uint8{0x24}, 1, 0x0201, true},
+		// RTI restores I before the poll, so the IRQ is taken immediately
+		{"RTI clearing I", []uint8{0x40}, 0x24, []uint8{0x20, 0x10, 0x02}, 1, 0x0210, false},
+	}
+	for _, tt := range tests {
+		c, m := newInterruptCPU(t, 0x0200, tt.program, tt.p, tt.stack)
+		c.SetIRQ(true)
+		for i := 1; i <= tt.steps; i++ {
+			if err := c.Step(); err != nil {
+				t.Fatal(err)
+			}
+			if pc := c.State().PC; (pc == testIRQHandler) != (i == tt.steps) {
+				t.Errorf("%s: PC is $%04x after %d instructions", tt.name, pc, i)
+			}
+		}
+		p, ret := pushed(c, m)
+		if ret != tt.ret || (p&flagInterrupt != 0) != tt.pushedI || p&flagBreak != 0 {
+			t.Errorf("%s: pushed P=$%02x and $%04x, want $%04x with I %t", tt.name, p, ret, tt.ret, tt.pushedI)
+		}
+	}
+}
+
+func TestBranchDelaysInterrupts(t *testing.T) {
+	tests := []struct {
+		name    string
+		pc      uint16
+		program []uint8
+		p       uint8
+		nmi     bool
+		steps   int
+		ret     uint16
+	}{
+		// the interrupt is first polled at the end of the second cycle,
+		// which a taken branch that does not cross a page ignores
+		{"taken branch", 0x0200, []uint8{0xf0, 0x02}, 0x22, false, 2, 0x0205},
+		{"page crossing branch", 0x02f0, []uint8{0xf0, 0x10}, 0x22, false, 1, 0x0302},
+		{"untaken branch", 0x0200, []uint8{0xf0, 0x02}, 0x20, false, 2, 0x0203},
+		{"three cycle instruction", 0x0200, []uint8{0xa5, 0x00}, 0x20, false, 1, 0x0202},
+		{"taken branch, NMI", 0x0200, []uint8{0xf0, 0x02}, 0x22, true, 2, 0x0205},
+		{"page crossing branch, NMI", 0x02f0, []uint8{0xf0, 0x10}, 0x22, true, 1, 0x0302},
+		{"untaken branch, NMI", 0x0200, []uint8{0xf0, 0x02}, 0x20, true, 2, 0x0203},
+	}
+	for _, tt := range tests {
+		c, m := newInterruptCPU(t, tt.pc, tt.program, tt.p, nil)
+		handler := uint16(testIRQHandler)
+		m.at, m.fire = 1, func() { c.SetIRQ(true) }
+		if tt.nmi {
+			handler = testNMIHandler
+			m.fire = c.NMI
+		}
+		for i := 1; i <= tt.steps; i++ {
+			if err := c.Step(); err != nil {
+				t.Fatal(err)
+			}
+			if pc := c.State().PC; (pc == handler) != (i == tt.steps) {
+				t.Errorf("%s: PC is $%04x after %d instructions", tt.name, pc, i)
+			}
+		}
+		if _, ret := pushed(c, m); ret != tt.ret {
+			t.Errorf("%s: interrupt returns to $%04x, want $%04x", tt.name, ret, tt.ret)
+		}
+	}
+}
+
+func TestNMIHijack(t *testing.T) {
+	step := func(c *CPU) uint16 {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+		return c.State().PC
+	}
+
+	// an NMI during BRK's pushes takes its vector, but the pushed status
+	// still has B set
+	c, m := newInterruptCPU(t, 0x0200, []uint8{0x00}, 0x20, nil)
+	m.at, m.fire = 3, c.NMI
+	if pc := step(c); pc != testNMIHandler {
+		t.Fatalf("hijacked BRK went to $%04x", pc)
+	}
+	if p, ret := pushed(c, m); p&flagBreak == 0 || ret != 0x0202 {
+		t.Errorf("hijacked BRK pushed P=$%02x and $%04x", p, ret)
+	}
+	if pc := step(c); pc != testNMIHandler+1 {
+		t.Errorf("hijacking NMI was serviced again")
+	}
+
+	// an NMI after the vector is fetched runs after the first instruction
+	// of the BRK handler
+	c, m = newInterruptCPU(t, 0x0200, []uint8{0x00}, 0x20, nil)
+	m.at, m.fire = 7, c.NMI
+	if pc := step(c); pc != testIRQHandler {
+		t.Fatalf("BRK went to $%04x", pc)
+	}
+	if pc := step(c); pc != testNMIHandler {
+		t.Fatalf("late NMI not taken after the handler's first instruction, PC is $%04x", pc)
+	}
+	if _, ret := pushed(c, m); ret != testIRQHandler+1 {
+		t.Errorf("late NMI returns to $%04x", ret)
+	}
+
+	// an NMI during an IRQ sequence takes its vector, with B clear
+	c, m = newInterruptCPU(t, 0x0200, nil, 0x20, nil)
+	c.SetIRQ(true)
+	m.at, m.fire = 5, c.NMI
+	if pc := step(c); pc != testNMIHandler {
+		t.Fatalf("hijacked IRQ went to $%04x", pc)
+	}
+	if p, ret := pushed(c, m); p&flagBreak != 0 || ret != 0x0201 {
+		t.Errorf("hijacked IRQ pushed P=$%02x and $%04x", p, ret)
+	}
+	if pc := step(c); pc != testNMIHandler+1 {
+		t.Errorf("hijacking NMI was serviced again")
+	}
+}
+
+// accessLog is a flat address space that records each access in order.
+type accessLog struct {
+	flatRAM
+	accesses []string
+}
+
+func (r *accessLog) Read(a uint16) uint8 {
+	r.accesses = append(r.accesses, fmt.Sprintf("R $%04X", a))
+	return r.flatRAM.Read(a)
+}
+
+func (r *accessLog) Write(a uint16, v uint8) {
+	r.accesses = append(r.accesses, fmt.Sprintf("W $%04X", a))
+	r.flatRAM.Write(a, v)
+}
+
+func TestStackCycles(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  []uint8
+		accesses []string
+	}{
+		{"JSR", []uint8{0x20, 0x34, 0x12}, []string{
+			"R $0200", "R $0201", "R $01FD", "W $01FD", "W $01FC", "R $0202",
+		}},
+		{"RTS", []uint8{0x60}, []string{
+			"R $0200", "R $0201", "R $01FD", "R $01FE", "R $01FF", "R $1234",
+		}},
+		{"RTI", []uint8{0x40}, []string{
+			"R $0200", "R $0201", "R $01FD", "R $01FE", "R $01FF", "R $0100",
+		}},
+		{"PHA", []uint8{0x48}, []string{"R $0200", "R $0201", "W $01FD"}},
+		{"PHP", []uint8{0x08}, []string{"R $0200", "R $0201", "W $01FD"}},
+		{"PLA", []uint8{0x68}, []string{"R $0200", "R $0201", "R $01FD", "R $01FE"}},
+		{"PLP", []uint8{0x28}, []string{"R $0200", "R $0201", "R $01FD", "R $01FE"}},
+	}
+	for _, tt := range tests {
+		m := &accessLog{}
+		copy(m.flatRAM[0x0200:], tt.program)
+		m.flatRAM[0x01fe], m.flatRAM[0x01ff], m.flatRAM[0x0100] = 0x34, 0x12, 0x00
+		c, err := NewCPU(m, CPUNMOS6502)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetState(CPUState{PC: 0x0200, P: 0x24, SP: 0xfd})
+		m.accesses = nil
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(m.accesses, ", "); got != strings.Join(tt.accesses, ", ") {
+			t.Errorf("%s: accesses are %s, want %s", tt.name, got, strings.Join(tt.accesses, ", "))
+		}
+	}
+}
+
+// dotRecorder records the address and PPU position of each cartridge read.
+type dotRecorder struct {
+	cartridge
+	ppu   *ppu
+	reads []cartridgeRead
+}
+
+type cartridgeRead struct {
+	a   uint16
+	dot int
+}
+
+func (r *dotRecorder) read(a uint16) (uint8, error) {
+	r.reads = append(r.reads, cartridgeRead{a, r.ppu.scanline*341 + r.ppu.dot})
+	return r.cartridge.read(a)
+}
+
+func TestJSRRTSDotPositions(t *testing.T) {
+	program := make([]uint8, 0x11)
+	copy(program, []uint8{
+		0x20, 0x10, 0xc0, // JSR $C010
+		0x4c, 0x03, 0xc0, // JMP $C003
+	})
+	program[0x10] = 0x60 // RTS
+	n, err := NewNES(testrom.NROM(program), nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := n.(*nes).bus
+	r := &dotRecorder{cartridge: b.cartridge, ppu: b.ppu}
+	b.cartridge = r
+	for i := 0; i < 3; i++ {
+		if err := n.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// dots from the JSR opcode fetch; JSR reads its high byte in its last
+	// cycle, and RTS reads the pulled address before the next opcode fetch
+	want := []cartridgeRead{
+		{0xc000, 0}, {0xc001, 3}, {0xc002, 15}, // JSR
+		{0xc010, 18}, {0xc011, 21}, {0xc002, 33}, // RTS
+		{0xc003, 36},
+	}
+	if len(r.reads) < len(want) {
+		t.Fatalf("cartridge reads are %v, want %v", r.reads, want)
+	}
+	start := r.reads[0].dot
+	for i, w := range want {
+		got := cartridgeRead{r.reads[i].a, r.reads[i].dot - start}
+		if got != w {
+			t.Errorf("cartridge read %d is $%04x at dot %d, want $%04x at dot %d", i, got.a, got.dot, w.a, w.dot)
+		}
+	}
+}
+
+func TestOAMDMA(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix []uint8
+		stall  uint64
+	}{
+		// the copy reads on even cycles, so a halt cycle on an odd cycle
+		// is followed by one more to align it
+		{"halt on even cycle", []uint8{0x24, 0x00}, 513}, // BIT $00
+		{"halt on odd cycle", nil, 514},
+	}
+	for _, tt := range tests {
+		program := []uint8{
+			0xa9, 0x10, 0x8d, 0x03, 0x20, // LDA #$10, STA $2003
+			0xa2, 0x00, // LDX #$00
+			0x8a, 0x49, 0x5a, // TXA, EOR #$5A
+			0x9d, 0x00, 0x02, // STA $0200,X
+			0xe8, 0xd0, 0xf7, // INX, BNE
+			0xa9, 0x02, // LDA #$02
+		}
+		program = append(program, tt.prefix...)
+		dma := testrom.Origin + uint16(len(program))
+		program = append(program, 0x8d, 0x14, 0x40) // STA $4014
+		n, err := NewNES(testrom.NROM(testrom.Spin(program)), nil, nopController{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n.CPUState().PC != dma {
+			if err := n.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		before := n.CPUState().Cycles
+		if err := n.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if stall := n.CPUState().Cycles - before - 4; stall != tt.stall {
+			t.Errorf("%s: DMA took %d cycles, want %d", tt.name, stall, tt.stall)
+		}
+
+		// the copy goes through OAMDATA, so it starts at OAMADDR and wraps
+		p := n.(*nes).ppu
+		for i := 0; i < 0x100; i++ {
+			if v := p.oam[uint8(0x10+i)]; v != uint8(i)^0x5a {
+				t.Fatalf("%s: OAM $%02x is $%02x, want $%02x", tt.name, uint8(0x10+i), v, uint8(i)^0x5a)
+			}
+		}
+		if p.oamAddr != 0x10 {
+			t.Errorf("%s: OAMADDR is $%02x after DMA", tt.name, p.oamAddr)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package system
+
+// Binary-coded decimal arithmetic, which the 2A03 lacks but the NMOS 6502
+// and 65C02 perform when the decimal flag is set. The algorithms follow
+// Bruce Clark's "Decimal Mode" tutorial, including the flags left by invalid
+// BCD operands.
+
+// decimalMode indicates if ADC and SBC operate on BCD values.
+func (c *cpu) decimalMode() bool {
+	return c.variant != CPU2A03 && c.isFlagSet(flagDecimal)
+}
+
+// decimalPenalty takes the extra cycle used by the 65C02 for decimal ADC and
+// SBC.
+func (c *cpu) decimalPenalty() error {
+	if c.variant == CPU65C02 && c.isFlagSet(flagDecimal) {
+		return c.tick()
+	}
+	return nil
+}
+
+func (c *cpu) addDecimal(v uint8) {
+	var carry int
+	if c.isFlagSet(flagCarry) {
+		carry = 1
+	}
+
+	low := int(c.a&0x0f) + int(v&0x0f) + carry
+	if low >= 0x0a {
+		low = ((low + 0x06) & 0x0f) + 0x10
+	}
+	r := int(c.a&0xf0) + int(v&0xf0) + low
+
+	// the NMOS 6502 sets N and V before the high digit is corrected, and Z
+	// from the binary sum
+	binary := c.a + v + uint8(carry)
+	c.setSignFlag(uint8(r))
+	c.setZeroFlag(binary)
+	o := (((c.a ^ v) & 0x80) == 0) && (((c.a ^ uint8(r)) & 0x80) != 0)
+	c.setFlagValue(flagOverflow, o)
+
+	if r >= 0xa0 {
+		r += 0x60
+	}
+	c.setFlagValue(flagCarry, r >= 0x100)
+	c.a = uint8(r)
+
+	// the 65C02 sets N and Z from the result
+	if c.variant == CPU65C02 {
+		c.setSignFlag(c.a)
+		c.setZeroFlag(c.a)
+	}
+}
+
+func (c *cpu) subtractDecimal(v uint8) {
+	var borrow int
+	if !c.isFlagSet(flagCarry) {
+		borrow = 1
+	}
+
+	low := int(c.a&0x0f) - int(v&0x0f) - borrow
+	var r int
+	if c.variant == CPU65C02 {
+		r = int(c.a) - int(v) - borrow
+		if r < 0 {
+			r -= 0x60
+		}
+		if low < 0 {
+			r -= 0x06
+		}
+	} else {
+		if low < 0 {
+			low = ((low - 0x06) & 0x0f) - 0x10
+		}
+		r = int(c.a&0xf0) - int(v&0xf0) + low
+		if r < 0 {
+			r -= 0x60
+		}
+	}
+
+	// carry and overflow are set as for binary subtraction, as are N and Z
+	// on the NMOS 6502
+	c.addBinary(^v)
+	c.a = uint8(r)
+	if c.variant == CPU65C02 {
+		c.setSignFlag(c.a)
+		c.setZeroFlag(c.a)
+	}
+}
@@ -1,5 +1,9 @@
 package system
 
+import (
+	"image"
+)
+
 // Drawer is an abstraction to draw pixel data to the screen.
 // It is not implemented in this package and should instead
 // be implemented using the emulator's respective graphics library.
@@ -10,3 +14,49 @@ type Drawer interface {
 	// presented in the emulator's screen.
 	CompleteFrame()
 }
+
+// frameBuffer captures frames for the Framebuffer API and counts them, while
+// passing pixels on to the embedder's Drawer, if any.
+type frameBuffer struct {
+	drawer Drawer
+	back   *image.RGBA
+	front  *image.RGBA
+	frames int
+
+	// set to skip drawing frames that are not shown
+	disabled bool
+}
+
+func newFrameBuffer(drawer Drawer) *frameBuffer {
+	bounds := image.Rect(0, 0, DrawWidth, DrawHeight)
+	return &frameBuffer{
+		drawer: drawer,
+		back:   image.NewRGBA(bounds),
+		front:  image.NewRGBA(bounds),
+	}
+}
+
+func (f *frameBuffer) DrawPixel(col, row, rgb int) {
+	if f.disabled {
+		return
+	}
+	i := f.back.PixOffset(col, row)
+	f.back.Pix[i] = uint8(rgb >> 16)
+	f.back.Pix[i+1] = uint8(rgb >> 8)
+	f.back.Pix[i+2] = uint8(rgb)
+	f.back.Pix[i+3] = 0xff
+	if f.drawer != nil {
+		f.drawer.DrawPixel(col, row, rgb)
+	}
+}
+
+func (f *frameBuffer) CompleteFrame() {
+	f.frames++
+	if f.disabled {
+		return
+	}
+	f.front, f.back = f.back, f.front
+	if f.drawer != nil {
+		f.drawer.CompleteFrame()
+	}
+}
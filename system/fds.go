@@ -0,0 +1,418 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+const (
+	fdsBIOSSize    = 0x2000
+	fdsPRGRAMSize  = 0x8000
+	fdsBIOSLowAddr = 0xe000
+
+	// disk registers
+	fdsIRQReloadLowAddr  = 0x4020
+	fdsIRQReloadHighAddr = 0x4021
+	fdsIRQControlAddr    = 0x4022
+	fdsMasterIOAddr      = 0x4023
+	fdsWriteDataAddr     = 0x4024
+	fdsControlAddr       = 0x4025
+	fdsExtWriteAddr      = 0x4026
+	fdsStatusAddr        = 0x4030
+	fdsReadDataAddr      = 0x4031
+	fdsDriveStatusAddr   = 0x4032
+	fdsExtReadAddr       = 0x4033
+
+	// sound registers
+	fdsAudioLowAddr  = 0x4040
+	fdsAudioHighAddr = 0x4092
+
+	fdsNoDisk = -1
+
+	// CPU cycles taken by the drive to reach the first byte after the head
+	// returns to the start of the disk, and to transfer each following byte
+	fdsSeekCycles = 50000
+	fdsByteCycles = 150
+
+	// CPU cycles a disk remains ejected when switching sides, so that the
+	// BIOS notices the change
+	fdsSwitchCycles = 1789773
+)
+
+// fds implements the Famicom Disk System RAM adapter.
+// CPU banks
+// 0x4020 - 0x4092: disk and sound registers
+// 0x6000 - 0xdfff: PRG RAM
+// 0xe000 - 0xffff: BIOS ROM
+// PPU banks
+// 0x0000 - 0x1fff: CHR RAM
+type fds struct {
+	cpu *cpu
+
+	bios   []uint8
+	prgRAM [fdsPRGRAMSize]uint8
+	chr    [chrBankSize]uint8
+	mirror mirrorMode
+
+	// disk sides as bit streams, including gaps
+	sides       [][]uint8
+	header      []uint8
+	side        int
+	pendingSide int
+	switchDelay int
+
+	// timer IRQ
+	irqReload, irqCounter uint16
+	irqEnabled, irqRepeat bool
+	timerIRQ              bool
+
+	diskRegEnabled, soundRegEnabled bool
+
+	// disk drive
+	motorOn, resetTransfer, readMode bool
+	crcControl, prevCRCControl       bool
+	diskReady, diskIRQEnabled        bool
+	diskIRQ, transferComplete        bool
+	endOfHead, scanning, gapEnded    bool
+	position, delay                  int
+	readData, writeData, extWrite    uint8
+	crc                              uint16
+
+	audio fdsAudio
+}
+
+func newFDS(image, bios []uint8) (*fds, error) {
+	if len(bios) != fdsBIOSSize {
+		return nil, errors.New(fmt.Sprintf("fds bios must be %d bytes, got %d", fdsBIOSSize, len(bios)))
+	}
+	header, sides, err := splitFDSImage(image)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &fds{
+		bios:        bios,
+		header:      image[:header],
+		mirror:      horizontal,
+		side:        0,
+		pendingSide: fdsNoDisk,
+	}
+	for _, s := range sides {
+		c.sides = append(c.sides, addFDSGaps(s))
+	}
+
+	log.Printf("FDS disk sides: %d", len(c.sides))
+
+	return c, nil
+}
+
+func (c *fds) read(a uint16) (uint8, error) {
+	switch {
+	case a >= fdsBIOSLowAddr:
+		return c.bios[a-fdsBIOSLowAddr], nil
+	case a >= prgRAMLowAddr:
+		return c.prgRAM[a-prgRAMLowAddr], nil
+	case a >= fdsAudioLowAddr && a <= fdsAudioHighAddr:
+		if !c.soundRegEnabled {
+			return 0, errOpenBus
+		}
+		return c.audio.read(a), nil
+	case !c.diskRegEnabled:
+		return 0, errOpenBus
+	case a == fdsStatusAddr:
+		return c.readStatus(), nil
+	case a == fdsReadDataAddr:
+		c.transferComplete = false
+		c.setDiskIRQ(false)
+		return c.readData, nil
+	case a == fdsDriveStatusAddr:
+		return c.readDriveStatus(), nil
+	case a == fdsExtReadAddr:
+		// bit 7 reports a good battery
+		return 0x80 | (c.extWrite & 0x7f), nil
+	}
+	return 0, errOpenBus
+}
+
+func (c *fds) write(a uint16, v uint8) error {
+	switch {
+	case a >= fdsBIOSLowAddr:
+		// BIOS ROM is read only
+	case a >= prgRAMLowAddr:
+		c.prgRAM[a-prgRAMLowAddr] = v
+	case a >= fdsAudioLowAddr && a <= fdsAudioHighAddr:
+		if c.soundRegEnabled {
+			c.audio.write(a, v)
+		}
+	case a == fdsMasterIOAddr:
+		c.diskRegEnabled = isBitSet(v, 0)
+		c.soundRegEnabled = isBitSet(v, 1)
+		if !c.diskRegEnabled {
+			c.irqEnabled = false
+			c.setTimerIRQ(false)
+			c.setDiskIRQ(false)
+		}
+	case !c.diskRegEnabled:
+	case a == fdsIRQReloadLowAddr:
+		c.irqReload = (c.irqReload & 0xff00) | uint16(v)
+	case a == fdsIRQReloadHighAddr:
+		c.irqReload = (c.irqReload & 0x00ff) | (uint16(v) << 8)
+	case a == fdsIRQControlAddr:
+		c.irqRepeat = isBitSet(v, 0)
+		c.irqEnabled = isBitSet(v, 1)
+		if c.irqEnabled {
+			c.irqCounter = c.irqReload
+		} else {
+			c.setTimerIRQ(false)
+		}
+	case a == fdsWriteDataAddr:
+		c.writeData = v
+		c.transferComplete = false
+		c.setDiskIRQ(false)
+	case a == fdsControlAddr:
+		c.writeControl(v)
+	case a == fdsExtWriteAddr:
+		c.extWrite = v
+	}
+	return nil
+}
+
+func (c *fds) writeControl(v uint8) {
+	c.setDiskIRQ(false)
+	c.motorOn = isBitSet(v, 0)
+	c.resetTransfer = isBitSet(v, 1)
+	c.readMode = isBitSet(v, 2)
+	if isBitSet(v, 3) {
+		c.mirror = horizontal
+	} else {
+		c.mirror = vertical
+	}
+	c.crcControl = isBitSet(v, 4)
+	c.diskReady = isBitSet(v, 6)
+	c.diskIRQEnabled = isBitSet(v, 7)
+}
+
+func (c *fds) readStatus() uint8 {
+	var r uint8
+	if c.timerIRQ {
+		r |= 0x01
+	}
+	if c.transferComplete {
+		r |= 0x02
+	}
+	if c.endOfHead {
+		r |= 0x40
+	}
+
+	c.transferComplete = false
+	c.setTimerIRQ(false)
+	c.setDiskIRQ(false)
+	return r
+}
+
+func (c *fds) readDriveStatus() uint8 {
+	var r uint8 = 0x40
+	if c.side == fdsNoDisk {
+		// no disk inserted, not ready, and write protected
+		return r | 0x07
+	}
+	if !c.scanning {
+		r |= 0x02
+	}
+	return r
+}
+
+func (c *fds) readCHR(a uint16) (uint8, error) {
+	return c.chr[a], nil
+}
+
+func (c *fds) writeCHR(a uint16, v uint8) error {
+	c.chr[a] = v
+	return nil
+}
+
+func (c *fds) vramMirror() mirrorMode {
+	return c.mirror
+}
+
+func (c *fds) incScanline(cp *cpu) error {
+	return nil
+}
+
+func (c *fds) setTimerIRQ(v bool) {
+	c.timerIRQ = v
+	c.updateIRQ()
+}
+
+func (c *fds) setDiskIRQ(v bool) {
+	c.diskIRQ = v
+	c.updateIRQ()
+}
+
+func (c *fds) updateIRQ() {
+	if c.cpu != nil {
+		c.cpu.setIRQ(c.timerIRQ || c.diskIRQ)
+	}
+}
+
+// step advances the timer IRQ, disk drive, and sound channel.
+func (c *fds) step(cpuCycles uint64) error {
+	for i := uint64(0); i < cpuCycles; i++ {
+		c.stepTimer()
+		c.stepDrive()
+		c.audio.step()
+	}
+	return nil
+}
+
+func (c *fds) stepTimer() {
+	if !c.irqEnabled {
+		return
+	}
+	if c.irqCounter == 0 {
+		c.setTimerIRQ(true)
+		c.irqCounter = c.irqReload
+		if !c.irqRepeat {
+			c.irqEnabled = false
+		}
+	} else {
+		c.irqCounter--
+	}
+}
+
+func (c *fds) stepDrive() {
+	if c.switchDelay > 0 {
+		c.switchDelay--
+		if c.switchDelay == 0 {
+			c.side = c.pendingSide
+			c.pendingSide = fdsNoDisk
+		}
+	}
+
+	if c.side == fdsNoDisk || !c.motorOn {
+		c.endOfHead = true
+		c.scanning = false
+		return
+	}
+	if c.resetTransfer && !c.scanning {
+		return
+	}
+	if c.endOfHead {
+		c.delay = fdsSeekCycles
+		c.endOfHead = false
+		c.position = 0
+		c.gapEnded = false
+		return
+	}
+	if c.delay > 0 {
+		c.delay--
+		return
+	}
+
+	c.scanning = true
+	disk := c.sides[c.side]
+	needIRQ := c.diskIRQEnabled
+
+	if c.readMode {
+		v := disk[c.position]
+		if !c.prevCRCControl {
+			c.crc = updateFDSCRC(c.crc, v)
+		}
+		if !c.diskReady {
+			c.gapEnded = false
+			c.crc = 0
+		} else if v != 0 && !c.gapEnded {
+			// the gap end mark itself is not reported to the CPU
+			c.gapEnded = true
+			needIRQ = false
+		}
+		if c.gapEnded {
+			c.transferComplete = true
+			c.readData = v
+			if needIRQ {
+				c.setDiskIRQ(true)
+			}
+		}
+	} else {
+		var v uint8
+		if !c.crcControl {
+			c.transferComplete = true
+			v = c.writeData
+			if needIRQ {
+				c.setDiskIRQ(true)
+			}
+		}
+		if !c.diskReady {
+			v = 0
+		}
+		if !c.crcControl {
+			c.crc = updateFDSCRC(c.crc, v)
+		} else {
+			if !c.prevCRCControl {
+				c.crc = updateFDSCRC(c.crc, 0)
+				c.crc = updateFDSCRC(c.crc, 0)
+			}
+			v = uint8(c.crc)
+			c.crc >>= 8
+		}
+		disk[c.position] = v
+		c.gapEnded = false
+	}
+
+	c.prevCRCControl = c.crcControl
+	c.position++
+	if c.position >= len(disk) {
+		c.motorOn = false
+	} else {
+		c.delay = fdsByteCycles
+	}
+}
+
+// insertDisk inserts a disk side. If a disk is already inserted, it is
+// ejected first, and the new side is inserted after a delay.
+func (c *fds) insertDisk(side int) error {
+	if side < 0 || side >= len(c.sides) {
+		return errors.New(fmt.Sprintf("disk side %d does not exist", side))
+	}
+	if c.side == fdsNoDisk && c.switchDelay == 0 {
+		c.side = side
+		return nil
+	}
+	c.side = fdsNoDisk
+	c.pendingSide = side
+	c.switchDelay = fdsSwitchCycles
+	return nil
+}
+
+func (c *fds) ejectDisk() {
+	c.side = fdsNoDisk
+	c.pendingSide = fdsNoDisk
+	c.switchDelay = 0
+}
+
+// image returns the disk contents, including any writes, as a .fds image.
+func (c *fds) image() []uint8 {
+	r := append([]uint8{}, c.header...)
+	for _, s := range c.sides {
+		r = append(r, removeFDSGaps(s)...)
+	}
+	return r
+}
+
+// serialize saves or restores the RAM adapter and the disk contents, so that
+// loading a state also undoes later writes to the disk.
+func (c *fds) serialize(s *stateCodec) {
+	s.fixed(&c.prgRAM, &c.chr)
+	for _, side := range c.sides {
+		s.bytes(side)
+	}
+	s.ints((*int)(&c.mirror), &c.side, &c.pendingSide, &c.switchDelay, &c.position, &c.delay)
+	s.fixed(&c.irqReload, &c.irqCounter, &c.irqEnabled, &c.irqRepeat, &c.timerIRQ,
+		&c.diskRegEnabled, &c.soundRegEnabled,
+		&c.motorOn, &c.resetTransfer, &c.readMode, &c.crcControl, &c.prevCRCControl,
+		&c.diskReady, &c.diskIRQEnabled, &c.diskIRQ, &c.transferComplete,
+		&c.endOfHead, &c.scanning, &c.gapEnded,
+		&c.readData, &c.writeData, &c.extWrite, &c.crc)
+	c.audio.serialize(s)
+}
@@ -0,0 +1,324 @@
+package system
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rhallman96/nesquack/internal/testrom"
+	"github.com/rhallman96/nesquack/loader"
+)
+
+// fdsTestBIOS returns a BIOS that runs program from $E000.
+func fdsTestBIOS(program []uint8) []uint8 {
+	bios := make([]uint8, fdsBIOSSize)
+	copy(bios, program)
+	bios[0x1ffc], bios[0x1ffd] = 0x00, 0xe0
+	return bios
+}
+
+// fdsTestSide returns a .fds disk side holding one file with data.
+func fdsTestSide(data []uint8) []uint8 {
+	side := make([]uint8, 0, fdsSideSize)
+
+	info := make([]uint8, fdsDiskInfoSize)
+	copy(info, fdsDiskPrefix)
+	side = append(side, info...)
+	side = append(side, fdsFileAmtBlock, 1)
+
+	head := make([]uint8, fdsFileHeadSize)
+	head[0] = fdsFileHeadBlock
+	head[fdsFileSizeOffset] = uint8(len(data))
+	head[fdsFileSizeOffset+1] = uint8(len(data) >> 8)
+	side = append(side, head...)
+	side = append(side, fdsFileDataBlock)
+	side = append(side, data...)
+
+	return append(side, make([]uint8, fdsSideSize-len(side))...)
+}
+
+// fdsTestImage returns a headerless .fds image with one side per file.
+func fdsTestImage(files ...string) []uint8 {
+	var r []uint8
+	for _, f := range files {
+		r = append(r, fdsTestSide([]uint8(f))...)
+	}
+	return r
+}
+
+// newTestFDS runs a disk image with a BIOS that spins, and returns the RAM
+// adapter with its disk and sound registers enabled.
+func newTestFDS(t *testing.T, image []uint8) (NES, *fds) {
+	n, err := NewNESWithOptions(image, nil, nopController{}, Options{FDSBIOS: fdsTestBIOS(testrom.Spin(nil))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := n.(*nes).cartridge.(*fds)
+	if err := f.write(fdsMasterIOAddr, 0x03); err != nil {
+		t.Fatal(err)
+	}
+	return n, f
+}
+
+func TestSplitFDSImage(t *testing.T) {
+	image := fdsTestImage("side A", "side B", "side C")
+
+	header, sides, err := splitFDSImage(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != 0 || len(sides) != 3 {
+		t.Errorf("headerless image split into a %d byte header and %d sides", header, len(sides))
+	}
+
+	withHeader := append(append(append([]uint8{}, fdsPrefix...), 3), make([]uint8, fdsHeaderSize-5)...)
+	withHeader = append(withHeader, image...)
+	header, sides, err = splitFDSImage(withHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != fdsHeaderSize || len(sides) != 3 || !bytes.Equal(sides[2], image[2*fdsSideSize:]) {
+		t.Errorf("fwNES image split into a %d byte header and %d sides", header, len(sides))
+	}
+	if !IsDiskImage(image) || !IsDiskImage(withHeader) || IsDiskImage(testrom.NROM(nil)) {
+		t.Error("disk images not detected")
+	}
+
+	if _, _, err := splitFDSImage(image[:fdsSideSize-1]); err == nil {
+		t.Error("image smaller than a side was split")
+	}
+}
+
+func TestFDSGaps(t *testing.T) {
+	side := fdsTestSide([]uint8("file data"))
+	raw := addFDSGaps(side)
+	if len(raw) != fdsRawSideSize {
+		t.Fatalf("raw side is %d bytes, want %d", len(raw), fdsRawSideSize)
+	}
+
+	// the disk info block follows the lead-in gap and a gap end mark, and is
+	// followed by its CRC and the next gap
+	i := fdsLeadInGap
+	if raw[i-1] != 0 || raw[i] != fdsGapEndMark {
+		t.Fatalf("gap end mark not at $%x", i)
+	}
+	info := raw[i+1 : i+1+fdsDiskInfoSize]
+	if !bytes.Equal(info, side[:fdsDiskInfoSize]) {
+		t.Error("disk info block not copied after the lead-in gap")
+	}
+	crc := fdsCRC(info)
+	i += 1 + fdsDiskInfoSize
+	if raw[i] != uint8(crc) || raw[i+1] != uint8(crc>>8) {
+		t.Error("disk info block CRC not stored")
+	}
+	i += fdsCRCSize
+	if raw[i+fdsBlockGap-1] != 0 || raw[i+fdsBlockGap] != fdsGapEndMark || raw[i+fdsBlockGap+1] != fdsFileAmtBlock {
+		t.Error("file amount block not found after the block gap")
+	}
+
+	if !bytes.Equal(removeFDSGaps(raw), side) {
+		t.Error("removing gaps did not restore the side")
+	}
+}
+
+func TestFDSDiskDiff(t *testing.T) {
+	original := fdsTestImage("side A", "side B")
+	n, f := newTestFDS(t, original)
+	runFrames(t, n, 2)
+
+	// an untouched disk produces an empty patch
+	if diff := loader.CreateIPS(original, n.DiskImage()); !bytes.Equal(diff, []uint8("PATCHEOF")) {
+		t.Errorf("untouched disk gave a %d byte patch", len(diff))
+	}
+
+	// a write to side B only produces records within side B
+	i := bytes.Index(f.sides[1], []uint8("side B"))
+	f.sides[1][i] = 'S'
+	diff := loader.CreateIPS(original, n.DiskImage())
+	if len(diff) <= len("PATCHEOF") {
+		t.Fatal("disk write not in patch")
+	}
+	offset := int(diff[5])<<16 | int(diff[6])<<8 | int(diff[7])
+	if offset < fdsSideSize {
+		t.Errorf("patch record at $%x touches side A", offset)
+	}
+
+	patched, err := loader.ApplyPatch(original, diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(patched, n.DiskImage()) {
+		t.Error("patched image does not match the disk")
+	}
+	if !bytes.Equal(patched[:fdsSideSize], original[:fdsSideSize]) {
+		t.Error("side A changed")
+	}
+}
+
+func TestFDSDriveRead(t *testing.T) {
+	_, f := newTestFDS(t, fdsTestImage("side A"))
+
+	// motor on, read mode, disk ready
+	if err := f.write(fdsControlAddr, 0x45); err != nil {
+		t.Fatal(err)
+	}
+	want := append([]uint8{fdsGapEndMark}, fdsDiskPrefix...)
+	var got []uint8
+	for cycles := 0; len(got) < len(want) && cycles < 1000000; cycles++ {
+		if err := f.step(1); err != nil {
+			t.Fatal(err)
+		}
+		if f.readStatus()&0x02 != 0 {
+			v, err := f.read(fdsReadDataAddr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, v)
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("drive read %q, want %q", got, want)
+	}
+}
+
+func TestFDSTimerIRQ(t *testing.T) {
+	n, f := newTestFDS(t, fdsTestImage("side A"))
+	c := n.(*nes).cpu
+
+	write := func(a uint16, v uint8) {
+		if err := f.write(a, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(fdsIRQReloadLowAddr, 10)
+	write(fdsIRQReloadHighAddr, 0)
+
+	// one shot: the IRQ fires after reload + 1 cycles and the timer stops
+	write(fdsIRQControlAddr, 0x02)
+	f.stepTimer()
+	for i := 0; i < 10; i++ {
+		if c.irq {
+			t.Fatalf("IRQ after %d cycles", i+1)
+		}
+		f.stepTimer()
+	}
+	if !c.irq || f.readStatus()&0x01 == 0 {
+		t.Fatal("IRQ not raised after 11 cycles")
+	}
+	if c.irq {
+		t.Error("reading $4030 did not acknowledge the IRQ")
+	}
+	for i := 0; i < 30; i++ {
+		f.stepTimer()
+	}
+	if c.irq {
+		t.Error("one shot timer repeated")
+	}
+
+	// repeating: the IRQ fires every reload + 1 cycles
+	write(fdsIRQControlAddr, 0x03)
+	for period := 0; period < 3; period++ {
+		for i := 0; i < 11; i++ {
+			f.stepTimer()
+		}
+		if !c.irq {
+			t.Fatalf("repeating IRQ not raised in period %d", period)
+		}
+		f.readStatus()
+	}
+
+	// disabling the disk registers stops the timer and clears the IRQ
+	for i := 0; i < 11; i++ {
+		f.stepTimer()
+	}
+	write(fdsMasterIOAddr, 0x00)
+	if c.irq || f.irqEnabled {
+		t.Error("disabling disk registers left the timer running")
+	}
+}
+
+func TestFDSSoundRegisters(t *testing.T) {
+	_, f := newTestFDS(t, fdsTestImage("side A"))
+
+	read := func(a uint16) uint8 {
+		v, err := f.read(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	// waveform RAM is writable only while $4089 bit 7 is set
+	f.write(fdsWaveTableLowAddr, 0x21)
+	if v := read(fdsWaveTableLowAddr); v != 0 {
+		t.Errorf("protected waveform RAM written with $%02x", v)
+	}
+	f.write(fdsWaveWriteAddr, 0x80)
+	f.write(fdsWaveTableLowAddr+5, 0xff)
+	if v := read(fdsWaveTableLowAddr + 5); v != 0x3f {
+		t.Errorf("waveform RAM reads $%02x, want $3f", v)
+	}
+
+	// a disabled envelope sets its gain directly
+	f.write(fdsVolumeEnvAddr, 0x80|0x12)
+	f.write(fdsModEnvAddr, 0x80|0x05)
+	if read(fdsVolumeGainAddr) != 0x12 || read(fdsModGainAddr) != 0x05 {
+		t.Error("envelope gains not readable")
+	}
+
+	// sound registers are open bus while disabled
+	f.write(fdsMasterIOAddr, 0x01)
+	if _, err := f.read(fdsVolumeGainAddr); err != errOpenBus {
+		t.Error("disabled sound registers were readable")
+	}
+}
+
+func TestFDSSoundOutput(t *testing.T) {
+	_, f := newTestFDS(t, fdsTestImage("side A"))
+	s := &f.audio
+
+	f.write(fdsWaveWriteAddr, 0x80)
+	for i := uint16(0); i < fdsWaveTableSize; i++ {
+		f.write(fdsWaveTableLowAddr+i, uint8(i))
+	}
+	f.write(fdsWaveWriteAddr, 0x00)
+	f.write(fdsVolumeEnvAddr, 0x80|fdsMaxGain)
+
+	// a pitch of $400 advances the waveform one step every 64 cycles
+	f.write(fdsModFreqHighAddr, 0x80)
+	f.write(fdsFreqLowAddr, 0x00)
+	f.write(fdsFreqHighAddr, 0x04)
+	for i := 0; i < 3*64; i++ {
+		s.step()
+	}
+	if v := s.sample(); v != 3*fdsMaxGain {
+		t.Errorf("sample is %d, want %d", v, 3*fdsMaxGain)
+	}
+
+	// master volume 2/4 halves the output
+	f.write(fdsWaveWriteAddr, 0x02)
+	for i := 0; i < 64; i++ {
+		s.step()
+	}
+	if v := s.sample(); v != 4*fdsMaxGain/2 {
+		t.Errorf("sample is %d, want %d", v, 4*fdsMaxGain/2)
+	}
+
+	// mod table writes fill two entries while the mod unit is halted
+	f.write(fdsModTableAddr, 0x03)
+	if s.modTable[0] != 3 || s.modTable[1] != 3 || s.modPos != 2 {
+		t.Errorf("mod table write gave %v at position %d", s.modTable[:2], s.modPos)
+	}
+
+	// a mod counter of 16 with gain 32 raises the pitch by half
+	f.write(fdsModCounterAddr, 0x10)
+	f.write(fdsModEnvAddr, 0x80|0x20)
+	if p := s.pitch(); p != 0x600 {
+		t.Errorf("modulated pitch is $%x, want $600", p)
+	}
+
+	// halting the waveform resets its position
+	f.write(fdsFreqHighAddr, 0x80)
+	if s.wavePos != 0 || s.waveAcc != 0 {
+		t.Error("halting the waveform kept its position")
+	}
+}
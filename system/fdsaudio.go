@@ -0,0 +1,249 @@
+package system
+
+const (
+	fdsWaveTableLowAddr  = 0x4040
+	fdsWaveTableHighAddr = 0x407f
+	fdsVolumeEnvAddr     = 0x4080
+	fdsFreqLowAddr       = 0x4082
+	fdsFreqHighAddr      = 0x4083
+	fdsModEnvAddr        = 0x4084
+	fdsModCounterAddr    = 0x4085
+	fdsModFreqLowAddr    = 0x4086
+	fdsModFreqHighAddr   = 0x4087
+	fdsModTableAddr      = 0x4088
+	fdsWaveWriteAddr     = 0x4089
+	fdsEnvSpeedAddr      = 0x408a
+	fdsVolumeGainAddr    = 0x4090
+	fdsModGainAddr       = 0x4092
+
+	fdsWaveTableSize = 64
+	fdsModTableSize  = 64
+	fdsMaxGain       = 32
+
+	// modulation table entry that resets the counter instead of adjusting it
+	fdsModReset = 4
+)
+
+// mod table entries adjust the mod counter by these amounts
+var fdsModAdjust = [8]int{0, 1, 2, 4, 0, -4, -2, -1}
+
+// master volume is scaled by 2/2, 2/3, 2/4, or 2/5
+var fdsMasterVolume = [4]int{30, 20, 15, 12}
+
+// fdsEnvelope is the volume or modulation envelope of the FDS sound channel.
+type fdsEnvelope struct {
+	speed    uint8
+	gain     uint8
+	increase bool
+	disabled bool
+	timer    int
+}
+
+func (e *fdsEnvelope) write(v uint8) {
+	e.speed = v & 0x3f
+	e.increase = isBitSet(v, 6)
+	e.disabled = isBitSet(v, 7)
+	if e.disabled {
+		e.gain = e.speed
+	}
+	e.timer = 0
+}
+
+// step clocks the envelope, which adjusts its gain once every
+// 8 * (speed + 1) * master speed CPU cycles.
+func (e *fdsEnvelope) step(masterSpeed uint8) {
+	if e.disabled {
+		return
+	}
+	e.timer++
+	if e.timer < 8*(int(e.speed)+1)*int(masterSpeed) {
+		return
+	}
+	e.timer = 0
+	if e.increase && e.gain < fdsMaxGain {
+		e.gain++
+	} else if !e.increase && e.gain > 0 {
+		e.gain--
+	}
+}
+
+// fdsAudio is the RAM adapter's wavetable sound channel, with a single
+// 64-step waveform whose pitch is modulated by a second table.
+type fdsAudio struct {
+	waveTable [fdsWaveTableSize]uint8
+	waveWrite bool
+	waveHalt  bool
+	waveFreq  uint16
+	waveAcc   uint32
+	wavePos   uint8
+
+	modTable   [fdsModTableSize]uint8
+	modHalt    bool
+	modFreq    uint16
+	modAcc     uint32
+	modPos     uint8
+	modCounter int8
+
+	volumeEnv, modEnv fdsEnvelope
+	envHalt           bool
+	envSpeed          uint8
+	masterVolume      uint8
+
+	// the output level is latched at the start of each waveform step
+	output int
+}
+
+func (s *fdsAudio) read(a uint16) uint8 {
+	switch {
+	case a <= fdsWaveTableHighAddr:
+		return s.waveTable[a-fdsWaveTableLowAddr]
+	case a == fdsVolumeGainAddr:
+		return s.volumeEnv.gain
+	case a == fdsModGainAddr:
+		return s.modEnv.gain
+	}
+	return 0
+}
+
+func (s *fdsAudio) write(a uint16, v uint8) {
+	switch {
+	case a <= fdsWaveTableHighAddr:
+		if s.waveWrite {
+			s.waveTable[a-fdsWaveTableLowAddr] = v & 0x3f
+		}
+	case a == fdsVolumeEnvAddr:
+		s.volumeEnv.write(v)
+	case a == fdsFreqLowAddr:
+		s.waveFreq = (s.waveFreq & 0xf00) | uint16(v)
+	case a == fdsFreqHighAddr:
+		s.waveFreq = (s.waveFreq & 0xff) | (uint16(v&0xf) << 8)
+		s.waveHalt = isBitSet(v, 7)
+		s.envHalt = isBitSet(v, 6)
+		if s.waveHalt {
+			s.waveAcc = 0
+			s.wavePos = 0
+		}
+	case a == fdsModEnvAddr:
+		s.modEnv.write(v)
+	case a == fdsModCounterAddr:
+		s.modCounter = int8(v<<1) >> 1
+	case a == fdsModFreqLowAddr:
+		s.modFreq = (s.modFreq & 0xf00) | uint16(v)
+	case a == fdsModFreqHighAddr:
+		s.modFreq = (s.modFreq & 0xff) | (uint16(v&0xf) << 8)
+		s.modHalt = isBitSet(v, 7)
+		if s.modHalt {
+			s.modAcc = 0
+		}
+	case a == fdsModTableAddr:
+		// each write fills two consecutive entries, and only while halted
+		if s.modHalt {
+			s.modTable[s.modPos] = v & 0x7
+			s.modTable[(s.modPos+1)%fdsModTableSize] = v & 0x7
+			s.modPos = (s.modPos + 2) % fdsModTableSize
+		}
+	case a == fdsWaveWriteAddr:
+		s.waveWrite = isBitSet(v, 7)
+		s.masterVolume = v & 0x3
+	case a == fdsEnvSpeedAddr:
+		s.envSpeed = v
+	}
+}
+
+// step clocks the channel by a single CPU cycle.
+func (s *fdsAudio) step() {
+	if !s.envHalt && !s.waveHalt && s.envSpeed != 0 {
+		s.volumeEnv.step(s.envSpeed)
+		s.modEnv.step(s.envSpeed)
+	}
+
+	if !s.modHalt && s.modFreq != 0 {
+		s.modAcc += uint32(s.modFreq)
+		if s.modAcc >= 0x10000 {
+			s.modAcc -= 0x10000
+			s.stepMod()
+		}
+	}
+
+	if s.waveHalt || s.waveWrite {
+		return
+	}
+	s.waveAcc += uint32(s.pitch())
+	if s.waveAcc >= 0x10000 {
+		s.waveAcc -= 0x10000
+		s.wavePos = (s.wavePos + 1) % fdsWaveTableSize
+		s.updateOutput()
+	}
+}
+
+func (s *fdsAudio) stepMod() {
+	v := s.modTable[s.modPos]
+	s.modPos = (s.modPos + 1) % fdsModTableSize
+	if v == fdsModReset {
+		s.modCounter = 0
+		return
+	}
+	// the counter is 7 bits wide and wraps
+	s.modCounter = int8(uint8(int(s.modCounter)+fdsModAdjust[v])<<1) >> 1
+}
+
+// pitch returns the waveform frequency after modulation is applied.
+func (s *fdsAudio) pitch() int {
+	temp := int(s.modCounter) * int(s.modEnv.gain)
+	remainder := temp & 0xf
+	temp >>= 4
+	if remainder > 0 && (temp&0x80) == 0 {
+		if s.modCounter < 0 {
+			temp--
+		} else {
+			temp += 2
+		}
+	}
+
+	if temp >= 192 {
+		temp -= 256
+	} else if temp < -64 {
+		temp += 256
+	}
+
+	temp = int(s.waveFreq) * temp
+	remainder = temp & 0x3f
+	temp >>= 6
+	if remainder >= 32 {
+		temp++
+	}
+
+	r := int(s.waveFreq) + temp
+	if r < 0 {
+		return 0
+	}
+	return r
+}
+
+func (s *fdsAudio) updateOutput() {
+	gain := int(s.volumeEnv.gain)
+	if gain > fdsMaxGain {
+		gain = fdsMaxGain
+	}
+	s.output = int(s.waveTable[s.wavePos]) * gain * fdsMasterVolume[s.masterVolume] / 30
+}
+
+// sample returns the channel's current output level. There is no APU mixer
+// yet, so nothing routes it to an audio device.
+func (s *fdsAudio) sample() int {
+	return s.output
+}
+
+func (e *fdsEnvelope) serialize(s *stateCodec) {
+	s.fixed(&e.speed, &e.gain, &e.increase, &e.disabled)
+	s.ints(&e.timer)
+}
+
+func (s *fdsAudio) serialize(sc *stateCodec) {
+	sc.fixed(&s.waveTable, &s.waveWrite, &s.waveHalt, &s.waveFreq, &s.waveAcc, &s.wavePos,
+		&s.modTable, &s.modHalt, &s.modFreq, &s.modAcc, &s.modPos, &s.modCounter,
+		&s.envHalt, &s.envSpeed, &s.masterVolume)
+	s.volumeEnv.serialize(sc)
+	s.modEnv.serialize(sc)
+	sc.ints(&s.output)
+}
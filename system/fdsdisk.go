@@ -0,0 +1,182 @@
+package system
+
+import (
+	"errors"
+	"reflect"
+)
+
+var (
+	fdsPrefix     = []uint8{0x46, 0x44, 0x53, 0x1a} // "FDS\x1a"
+	fdsDiskPrefix = []uint8("\x01*NINTENDO-HVC*")
+)
+
+const (
+	fdsHeaderSize = 0x10
+	fdsSideSize   = 65500
+
+	// raw disk sides include gaps, so they are larger than .fds sides
+	fdsRawSideSize = 68000
+
+	// gaps are measured in bits on the disk surface
+	fdsLeadInGap = 28300 / 8
+	fdsBlockGap  = 976 / 8
+
+	fdsGapEndMark = 0x80
+
+	// block types
+	fdsDiskInfoBlock  = 1
+	fdsFileAmtBlock   = 2
+	fdsFileHeadBlock  = 3
+	fdsFileDataBlock  = 4
+	fdsDiskInfoSize   = 56
+	fdsFileAmtSize    = 2
+	fdsFileHeadSize   = 16
+	fdsFileSizeOffset = 13
+	fdsCRCSize        = 2
+)
+
+// IsDiskImage indicates if a ROM is a Famicom Disk System image, with or
+// without the fwNES header.
+func IsDiskImage(rom []uint8) bool {
+	return (len(rom) >= len(fdsPrefix) && reflect.DeepEqual(rom[:4], fdsPrefix)) ||
+		(len(rom) >= len(fdsDiskPrefix) && reflect.DeepEqual(rom[:len(fdsDiskPrefix)], fdsDiskPrefix))
+}
+
+// splitFDSImage returns the header length of a .fds image and its disk sides.
+func splitFDSImage(image []uint8) (int, [][]uint8, error) {
+	header := 0
+	if reflect.DeepEqual(image[:4], fdsPrefix) {
+		header = fdsHeaderSize
+	}
+
+	data := image[header:]
+	if len(data) < fdsSideSize {
+		return 0, nil, errors.New("fds image is smaller than one disk side")
+	}
+
+	var sides [][]uint8
+	for i := 0; i+fdsSideSize <= len(data); i += fdsSideSize {
+		sides = append(sides, data[i:i+fdsSideSize])
+	}
+	return header, sides, nil
+}
+
+// fdsBlockSize returns the length of the block at the start of data, or 0
+// if data does not begin with a valid block. fileSize is the size taken from
+// the preceding file header block.
+func fdsBlockSize(data []uint8, fileSize int) int {
+	if len(data) == 0 {
+		return 0
+	}
+	switch data[0] {
+	case fdsDiskInfoBlock:
+		return fdsDiskInfoSize
+	case fdsFileAmtBlock:
+		return fdsFileAmtSize
+	case fdsFileHeadBlock:
+		return fdsFileHeadSize
+	case fdsFileDataBlock:
+		return 1 + fileSize
+	}
+	return 0
+}
+
+// fdsFileSize reads the file size from a file header block.
+func fdsFileSize(block []uint8) int {
+	if len(block) < fdsFileHeadSize {
+		return 0
+	}
+	return int(block[fdsFileSizeOffset]) | int(block[fdsFileSizeOffset+1])<<8
+}
+
+// addFDSGaps converts a .fds disk side to the bit stream seen by the drive,
+// which surrounds each block with gaps, a gap end mark, and a CRC.
+func addFDSGaps(side []uint8) []uint8 {
+	r := make([]uint8, fdsLeadInGap, fdsRawSideSize)
+
+	fileSize := 0
+	for i := 0; i < len(side); {
+		n := fdsBlockSize(side[i:], fileSize)
+		if n == 0 || i+n > len(side) {
+			break
+		}
+		block := side[i : i+n]
+		if block[0] == fdsFileHeadBlock {
+			fileSize = fdsFileSize(block)
+		}
+
+		crc := fdsCRC(block)
+		r = append(r, fdsGapEndMark)
+		r = append(r, block...)
+		r = append(r, uint8(crc), uint8(crc>>8))
+		r = append(r, make([]uint8, fdsBlockGap)...)
+		i += n
+	}
+
+	for len(r) < fdsRawSideSize {
+		r = append(r, 0)
+	}
+	return r
+}
+
+// removeFDSGaps converts a drive bit stream back to a .fds disk side.
+func removeFDSGaps(raw []uint8) []uint8 {
+	r := make([]uint8, 0, fdsSideSize)
+
+	fileSize := 0
+	for i := 0; i < len(raw); {
+		// skip the gap preceding each block
+		for i < len(raw) && raw[i] == 0 {
+			i++
+		}
+		if i >= len(raw) || raw[i] != fdsGapEndMark {
+			break
+		}
+		i++
+
+		n := fdsBlockSize(raw[i:], fileSize)
+		if n == 0 || i+n > len(raw) {
+			break
+		}
+		block := raw[i : i+n]
+		if block[0] == fdsFileHeadBlock {
+			fileSize = fdsFileSize(block)
+		}
+		r = append(r, block...)
+		i += n + fdsCRCSize
+	}
+
+	if len(r) > fdsSideSize {
+		r = r[:fdsSideSize]
+	}
+	for len(r) < fdsSideSize {
+		r = append(r, 0)
+	}
+	return r
+}
+
+// fdsCRC computes the CRC stored after each block. The gap end mark is
+// included in the checksum.
+func fdsCRC(block []uint8) uint16 {
+	var crc uint16
+	crc = updateFDSCRC(crc, fdsGapEndMark)
+	for _, v := range block {
+		crc = updateFDSCRC(crc, v)
+	}
+	crc = updateFDSCRC(crc, 0)
+	return updateFDSCRC(crc, 0)
+}
+
+func updateFDSCRC(crc uint16, v uint8) uint16 {
+	for n := uint(0); n < 8; n++ {
+		carry := crc&1 != 0
+		crc >>= 1
+		if carry {
+			crc ^= 0x8408
+		}
+		if v&(1<<n) != 0 {
+			crc ^= 0x8000
+		}
+	}
+	return crc
+}
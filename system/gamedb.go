@@ -0,0 +1,181 @@
+package system
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// gameInfo is a game database entry. Entries are keyed by the CRC32 of the
+// ROM's PRG and CHR data (excluding the header and trainer), as in the
+// NES 2.0 database maintained by the nesdev community.
+type gameInfo struct {
+	name string
+
+	// optional; when set, it must also match for the entry to apply
+	sha1 string
+
+	// the board controls mirroring (or uses four-screen VRAM), so the
+	// header's mirroring is kept
+	boardMirroring bool
+
+	romInfo
+}
+
+// gameDB holds the games whose headers are corrected. It starts as the
+// built-in table generated from the nesdev database (see gamedb_table.go), and
+// further entries can be merged in at runtime with LoadGameDB.
+var gameDB = builtinGameDB
+
+// correctROMInfo replaces header values with those from the game database
+// when the ROM data matches a known game. Each corrected value is logged.
+func correctROMInfo(info romInfo, data []uint8) romInfo {
+	crc := crc32.ChecksumIEEE(data)
+	g, ok := gameDB[crc]
+	if !ok {
+		return info
+	}
+	if g.sha1 != "" {
+		sum := sha1.Sum(data)
+		if !strings.EqualFold(g.sha1, hex.EncodeToString(sum[:])) {
+			return info
+		}
+	}
+
+	var corrections []string
+	if g.mapper != info.mapper {
+		corrections = append(corrections, fmt.Sprintf("mapper %d -> %d", info.mapper, g.mapper))
+	}
+	if g.submapper != info.submapper {
+		corrections = append(corrections, fmt.Sprintf("submapper %d -> %d", info.submapper, g.submapper))
+	}
+	if g.boardMirroring {
+		g.mirror = info.mirror
+	} else if g.mirror != info.mirror {
+		corrections = append(corrections, fmt.Sprintf("mirroring %d -> %d", info.mirror, g.mirror))
+	}
+	if g.prgRAMSize != 0 && g.prgRAMSize != info.prgRAMSize {
+		corrections = append(corrections, fmt.Sprintf("PRG RAM %d -> %d bytes", info.prgRAMSize, g.prgRAMSize))
+	} else {
+		g.prgRAMSize = info.prgRAMSize
+	}
+	if g.chrRAMSize != 0 && g.chrRAMSize != info.chrRAMSize {
+		corrections = append(corrections, fmt.Sprintf("CHR RAM %d -> %d bytes", info.chrRAMSize, g.chrRAMSize))
+	} else {
+		g.chrRAMSize = info.chrRAMSize
+	}
+	if g.battery != info.battery {
+		corrections = append(corrections, fmt.Sprintf("battery %t -> %t", info.battery, g.battery))
+	}
+	if g.region != info.region {
+		corrections = append(corrections, fmt.Sprintf("region %s -> %s", info.region, g.region))
+	}
+
+	if len(corrections) == 0 {
+		log.Printf("Game database: %s (crc32 %08x), header is correct", g.name, crc)
+	} else {
+		log.Printf("Game database: %s (crc32 %08x), corrected %s", g.name, crc, strings.Join(corrections, ", "))
+	}
+	return g.romInfo
+}
+
+// nes20DB mirrors the subset of the nesdev NES 2.0 XML database used by
+// LoadGameDB.
+type nes20DB struct {
+	Games []struct {
+		Comment string `xml:",comment"`
+		ROM     struct {
+			CRC32 string `xml:"crc32,attr"`
+			SHA1  string `xml:"sha1,attr"`
+		} `xml:"rom"`
+		PCB struct {
+			Mapper    int    `xml:"mapper,attr"`
+			Submapper int    `xml:"submapper,attr"`
+			Mirroring string `xml:"mirroring,attr"`
+			Battery   int    `xml:"battery,attr"`
+		} `xml:"pcb"`
+		PRGRAM struct {
+			Size int `xml:"size,attr"`
+		} `xml:"prgram"`
+		PRGNVRAM struct {
+			Size int `xml:"size,attr"`
+		} `xml:"prgnvram"`
+		CHRRAM struct {
+			Size int `xml:"size,attr"`
+		} `xml:"chrram"`
+		Console struct {
+			Region int `xml:"region,attr"`
+		} `xml:"console"`
+	} `xml:"game"`
+}
+
+// LoadGameDB merges entries from a nesdev NES 2.0 XML database (nes20db.xml)
+// into the game database, and returns the number of entries loaded. The game
+// database is left unchanged if any entry is invalid.
+func LoadGameDB(r io.Reader) (int, error) {
+	loaded, err := parseGameDB(r)
+	if err != nil {
+		return 0, err
+	}
+
+	merged := make(map[uint32]gameInfo, len(gameDB)+len(loaded))
+	for crc, g := range gameDB {
+		merged[crc] = g
+	}
+	for crc, g := range loaded {
+		merged[crc] = g
+	}
+	gameDB = merged
+	return len(loaded), nil
+}
+
+// parseGameDB reads a nesdev NES 2.0 XML database into a new table.
+func parseGameDB(r io.Reader) (map[uint32]gameInfo, error) {
+	var db nes20DB
+	if err := xml.NewDecoder(r).Decode(&db); err != nil {
+		return nil, err
+	}
+
+	games := make(map[uint32]gameInfo, len(db.Games))
+	for _, g := range db.Games {
+		crc, err := strconv.ParseUint(g.ROM.CRC32, 16, 32)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid crc32 %q in game database", g.ROM.CRC32))
+		}
+		if g.Console.Region < int(RegionNTSC) || g.Console.Region > int(RegionDendy) {
+			return nil, errors.New(fmt.Sprintf("invalid region %d for crc32 %s in game database", g.Console.Region, g.ROM.CRC32))
+		}
+
+		var mirror mirrorMode = onePage
+		switch g.PCB.Mirroring {
+		case "H":
+			mirror = horizontal
+		case "V":
+			mirror = vertical
+		}
+
+		games[uint32(crc)] = gameInfo{
+			name:           strings.TrimSpace(g.Comment),
+			sha1:           g.ROM.SHA1,
+			boardMirroring: g.PCB.Mirroring != "H" && g.PCB.Mirroring != "V",
+			romInfo: romInfo{
+				mapper:     g.PCB.Mapper,
+				submapper:  g.PCB.Submapper,
+				mirror:     mirror,
+				prgRAMSize: g.PRGRAM.Size + g.PRGNVRAM.Size,
+				chrRAMSize: g.CHRRAM.Size,
+				battery:    g.PCB.Battery != 0,
+				region:     Region(g.Console.Region),
+			},
+		}
+	}
+
+	return games, nil
+}
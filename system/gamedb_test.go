@@ -0,0 +1,249 @@
+package system
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rhallman96/nesquack/internal/testrom"
+)
+
+var gameDBPath = flag.String("gamedb", "", "regenerate gamedb_table.go from this nes20db.xml")
+
+// TestGenerateGameDB rewrites the built-in game database from the nesdev
+// NES 2.0 database when -gamedb is given.
+func TestGenerateGameDB(t *testing.T) {
+	if *gameDBPath == "" {
+		t.Skip("pass -gamedb nes20db.xml to regenerate gamedb_table.go")
+	}
+	f, err := os.Open(*gameDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	games, err := parseGameDB(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := gameDBSource(games, *gameDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("gamedb_table.go", src, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("wrote %d entries to gamedb_table.go", len(games))
+}
+
+// gameDBSource formats a game database read from source as the source of
+// gamedb_table.go.
+func gameDBSource(games map[uint32]gameInfo, source string) ([]uint8, error) {
+	mirrors := map[mirrorMode]string{onePage: "onePage", horizontal: "horizontal", vertical: "vertical"}
+	regions := map[Region]string{RegionNTSC: "RegionNTSC", RegionPAL: "RegionPAL", RegionMulti: "RegionMulti", RegionDendy: "RegionDendy"}
+
+	crcs := make([]uint32, 0, len(games))
+	for crc := range games {
+		crcs = append(crcs, crc)
+	}
+	sort.Slice(crcs, func(i, j int) bool { return crcs[i] < crcs[j] })
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by \"go test ./system -run TestGenerateGameDB -gamedb %s\"; DO NOT EDIT.\n\n", source)
+	b.WriteString("package system\n\n")
+	fmt.Fprintf(&b, "// builtinGameDB is generated from %s, from the nesdev NES 2.0 database.\n", source)
+	b.WriteString("var builtinGameDB = map[uint32]gameInfo{\n")
+	for _, crc := range crcs {
+		g := games[crc]
+		fmt.Fprintf(&b, "0x%08x: {name: %q, sha1: %q, boardMirroring: %t, romInfo: romInfo{"+
+			"mapper: %d, submapper: %d, mirror: %s, prgRAMSize: %d, chrRAMSize: %d, battery: %t, region: %s}},\n",
+			crc, g.name, g.sha1, g.boardMirroring, g.mapper, g.submapper, mirrors[g.mirror],
+			g.prgRAMSize, g.chrRAMSize, g.battery, regions[g.region])
+	}
+	b.WriteString("}\n")
+	return format.Source(b.Bytes())
+}
+
+// gameDBEntry returns a nes20db.xml game element for the given ROM data.
+func gameDBEntry(name, crc string, mapper int, mirroring string, region int) string {
+	return fmt.Sprintf(`<game><!-- %s --><rom crc32="%s"/><pcb mapper="%d" submapper="0" mirroring="%s" battery="0"/><console region="%d"/></game>`,
+		name, crc, mapper, mirroring, region)
+}
+
+func TestGameDBCorrectsHeader(t *testing.T) {
+	defer func(db map[uint32]gameInfo) { gameDB = db }(gameDB)
+
+	// the header says NROM with horizontal mirroring; the database knows
+	// better
+	rom := testrom.NROM(testrom.Spin(nil))
+	crc := fmt.Sprintf("%08X", crc32.ChecksumIEEE(rom[16:]))
+	xml := "<database>" + gameDBEntry("Test Game", crc, 1, "V", int(RegionPAL)) + "</database>"
+	n, err := LoadGameDB(strings.NewReader(xml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("loaded %d entries, want 1", n)
+	}
+
+	c, region, err := createCartridge(rom, Options{}, newMemoryInit(Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(*mmc1); !ok {
+		t.Errorf("cartridge is %T, want *mmc1", c)
+	}
+	if region != RegionPAL {
+		t.Errorf("region is %s, want PAL", region)
+	}
+
+	nes, err := NewNES(rom, nopDrawer{}, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nes.Region() != RegionPAL {
+		t.Errorf("NES region is %s, want PAL", nes.Region())
+	}
+
+	c, region, err = createCartridge(rom, Options{DisableGameDB: true}, newMemoryInit(Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(*nrom); !ok || region != RegionNTSC {
+		t.Errorf("with the game database disabled, got %T and %s, want *nrom and NTSC", c, region)
+	}
+}
+
+func TestGameDBSHA1Mismatch(t *testing.T) {
+	defer func(db map[uint32]gameInfo) { gameDB = db }(gameDB)
+
+	rom := testrom.NROM(testrom.Spin(nil))
+	crc := crc32.ChecksumIEEE(rom[16:])
+	gameDB = map[uint32]gameInfo{
+		crc: {name: "Other Game", sha1: strings.Repeat("0", 40), romInfo: romInfo{mapper: 1}},
+	}
+	info := correctROMInfo(romInfo{mirror: horizontal}, rom[16:])
+	if info.mapper != 0 || info.mirror != horizontal {
+		t.Errorf("entry with a different sha1 was applied: %+v", info)
+	}
+}
+
+func TestLoadGameDBInvalidEntry(t *testing.T) {
+	defer func(db map[uint32]gameInfo) { gameDB = db }(gameDB)
+
+	gameDB = map[uint32]gameInfo{0x1234: {name: "Kept"}}
+	xml := "<database>" +
+		gameDBEntry("Good", "0000ABCD", 4, "H", 0) +
+		gameDBEntry("Bad", "not a crc", 4, "H", 0) +
+		"</database>"
+	if _, err := LoadGameDB(strings.NewReader(xml)); err == nil {
+		t.Fatal("expected an error for an invalid crc32")
+	}
+	if len(gameDB) != 1 || gameDB[0x1234].name != "Kept" {
+		t.Errorf("game database changed by a failed load: %v", gameDB)
+	}
+
+	xml = "<database>" + gameDBEntry("Bad Region", "0000ABCD", 4, "H", 7) + "</database>"
+	if _, err := LoadGameDB(strings.NewReader(xml)); err == nil {
+		t.Fatal("expected an error for an invalid region")
+	}
+	if len(gameDB) != 1 {
+		t.Errorf("game database changed by a failed load: %v", gameDB)
+	}
+}
+
+// builtinGameDBSource is the database gamedb_table.go is generated from.
+const builtinGameDBSource = "testdata/nes20db_subset.xml"
+
+func TestBuiltinGameDB(t *testing.T) {
+	// the table is up to date with its source
+	f, err := os.Open(builtinGameDBSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	games, err := parseGameDB(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := gameDBSource(games, builtinGameDBSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := ioutil.ReadFile("gamedb_table.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, table) {
+		t.Errorf("gamedb_table.go is out of date; run go test ./system -run TestGenerateGameDB -gamedb %s", builtinGameDBSource)
+	}
+
+	// known games resolve from the built-in table
+	g, ok := builtinGameDB[0x3337ec46]
+	if !ok {
+		t.Fatal("Super Mario Bros. is missing from the built-in table")
+	}
+	if g.mapper != nromHeader || g.mirror != vertical || g.region != RegionNTSC || g.boardMirroring {
+		t.Errorf("Super Mario Bros. entry is %+v", g)
+	}
+}
+
+func TestGameDBSource(t *testing.T) {
+	games := map[uint32]gameInfo{
+		0xabcd: {name: `Game "One"`, boardMirroring: true, romInfo: romInfo{mapper: 4, mirror: onePage, prgRAMSize: 8192, battery: true, region: RegionMulti}},
+		0x1234: {name: "Game Two", sha1: "ff", romInfo: romInfo{mapper: 1, mirror: vertical, chrRAMSize: 8192, region: RegionPAL}},
+	}
+	src, err := gameDBSource(games, "nes20db.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`0x00001234: {name: "Game Two", sha1: "ff", boardMirroring: false, romInfo: romInfo{mapper: 1, submapper: 0, mirror: vertical, prgRAMSize: 0, chrRAMSize: 8192, battery: false, region: RegionPAL}},`,
+		`0x0000abcd: {name: "Game \"One\"", sha1: "", boardMirroring: true, romInfo: romInfo{mapper: 4, submapper: 0, mirror: onePage, prgRAMSize: 8192, chrRAMSize: 0, battery: true, region: RegionMulti}},`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated source is missing %s:\n%s", want, src)
+		}
+	}
+	if strings.Index(string(src), "0x00001234") > strings.Index(string(src), "0x0000abcd") {
+		t.Error("generated entries are not sorted by crc32")
+	}
+}
+
+func TestGameDBSmallPRGRAM(t *testing.T) {
+	defer func(db map[uint32]gameInfo) { gameDB = db }(gameDB)
+
+	// MMC6 boards have 1 KB of PRG RAM, mirrored through $6000-$7FFF
+	rom := testrom.Build(mmc3Header, nil, testrom.Spin([]uint8{
+		0xa9, 0x42, 0x8d, 0x00, 0x74, // LDA #$42, STA $7400
+		0xad, 0x00, 0x60, 0x85, 0x00, // LDA $6000, STA $00
+	}))
+	crc := fmt.Sprintf("%08X", crc32.ChecksumIEEE(rom[16:]))
+	xml := fmt.Sprintf(`<database><game><rom crc32="%s"/><pcb mapper="4" submapper="1" mirroring="H"/>`+
+		`<prgram size="512"/><prgnvram size="512"/></game></database>`, crc)
+	if _, err := LoadGameDB(strings.NewReader(xml)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := NewNES(rom, nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := len(n.(*nes).cartridge.(*mmc3).prgRAM); size != 1024 {
+		t.Fatalf("PRG RAM is %d bytes, want 1024", size)
+	}
+	for i := 0; i < 4; i++ {
+		if err := n.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := n.ReadMemory(0x0000); v != 0x42 {
+		t.Errorf("read $%02x from the PRG RAM mirror, want $42", v)
+	}
+}
@@ -3,245 +3,399 @@ package system
 type operation func(c *cpu, bus memoryDevice, a uint16) error
 
 type instruction struct {
+	// unofficial mnemonics are marked with an asterisk, as in nestest logs
+	mnemonic string
+
 	operation         operation
 	addressMode       addressMode
 	cycles            uint64
 	addCyclePageCross bool
 }
 
-// execute modifies the state of the cpu and memory on behalf of an instruction
+// execute modifies the state of the cpu and memory on behalf of an instruction.
+// Memory accesses are clocked as they happen, and internal cycles without an
+// access are clocked once the instruction completes.
 func (i *instruction) execute(c *cpu) error {
+	// the opcode fetch has already been clocked
+	start := c.clock - 1
+
 	c.pc++
-	a, err := i.addressMode(c, c.bus, i.addCyclePageCross)
+	a, err := i.addressMode(c, c, i.addCyclePageCross)
 	if err != nil {
 		return err
 	}
-	c.clock += i.cycles
-	return i.operation(c, c.bus, a)
+	err = i.operation(c, c, a)
+	if err != nil {
+		return err
+	}
+
+	for c.clock < start+i.cycles {
+		if err := c.tick(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// the 2A03 instruction set, excluding undocumented opcodes
+// the 2A03 instruction set, including unofficial opcodes
 var instructionSet = [256]*instruction{
 	// ADC
-	0x69: {adc, immediate, 2, false},
-	0x65: {adc, zeroPage, 3, false},
-	0x75: {adc, zeroPageX, 4, false},
-	0x6d: {adc, absolute, 4, false},
-	0x7d: {adc, absoluteX, 4, true},
-	0x79: {adc, absoluteY, 4, true},
-	0x61: {adc, indirectX, 6, false},
-	0x71: {adc, indirectY, 5, true},
+	0x69: {"ADC", adc, immediate, 2, false},
+	0x65: {"ADC", adc, zeroPage, 3, false},
+	0x75: {"ADC", adc, zeroPageX, 4, false},
+	0x6d: {"ADC", adc, absolute, 4, false},
+	0x7d: {"ADC", adc, absoluteX, 4, true},
+	0x79: {"ADC", adc, absoluteY, 4, true},
+	0x61: {"ADC", adc, indirectX, 6, false},
+	0x71: {"ADC", adc, indirectY, 5, true},
 
 	// AND
-	0x29: {and, immediate, 2, false},
-	0x25: {and, zeroPage, 3, false},
-	0x35: {and, zeroPageX, 4, false},
-	0x2d: {and, absolute, 4, false},
-	0x3d: {and, absoluteX, 4, true},
-	0x39: {and, absoluteY, 4, true},
-	0x21: {and, indirectX, 6, false},
-	0x31: {and, indirectY, 5, true},
+	0x29: {"AND", and, immediate, 2, false},
+	0x25: {"AND", and, zeroPage, 3, false},
+	0x35: {"AND", and, zeroPageX, 4, false},
+	0x2d: {"AND", and, absolute, 4, false},
+	0x3d: {"AND", and, absoluteX, 4, true},
+	0x39: {"AND", and, absoluteY, 4, true},
+	0x21: {"AND", and, indirectX, 6, false},
+	0x31: {"AND", and, indirectY, 5, true},
 
 	// ASL
-	0x0a: {aslAcc, accumulator, 2, false},
-	0x06: {asl, zeroPage, 5, false},
-	0x16: {asl, zeroPageX, 6, false},
-	0x0e: {asl, absolute, 6, false},
-	0x1e: {asl, absoluteX, 7, false},
+	0x0a: {"ASL", aslAcc, accumulator, 2, false},
+	0x06: {"ASL", asl, zeroPage, 5, false},
+	0x16: {"ASL", asl, zeroPageX, 6, false},
+	0x0e: {"ASL", asl, absolute, 6, false},
+	0x1e: {"ASL", asl, absoluteX, 7, false},
 
 	// Branch
-	0x90: {bcc, relative, 2, false},
-	0xb0: {bcs, relative, 2, false},
-	0xf0: {beq, relative, 2, false},
-	0x30: {bmi, relative, 2, false},
-	0xd0: {bne, relative, 2, false},
-	0x10: {bpl, relative, 2, false},
-	0x50: {bvc, relative, 2, false},
-	0x70: {bvs, relative, 2, false},
+	0x90: {"BCC", bcc, relative, 2, false},
+	0xb0: {"BCS", bcs, relative, 2, false},
+	0xf0: {"BEQ", beq, relative, 2, false},
+	0x30: {"BMI", bmi, relative, 2, false},
+	0xd0: {"BNE", bne, relative, 2, false},
+	0x10: {"BPL", bpl, relative, 2, false},
+	0x50: {"BVC", bvc, relative, 2, false},
+	0x70: {"BVS", bvs, relative, 2, false},
 
 	// BIT
-	0x24: {bit, zeroPage, 3, false},
-	0x2c: {bit, absolute, 4, false},
+	0x24: {"BIT", bit, zeroPage, 3, false},
+	0x2c: {"BIT", bit, absolute, 4, false},
 
 	// Break
-	0x00: {brk, implied, 7, false},
+	0x00: {"BRK", brk, implied, 7, false},
 
 	// Clear
-	0x18: {clc, implied, 2, false},
-	0xd8: {cld, implied, 2, false},
-	0x58: {cli, implied, 2, false},
-	0xb8: {clv, implied, 2, false},
+	0x18: {"CLC", clc, implied, 2, false},
+	0xd8: {"CLD", cld, implied, 2, false},
+	0x58: {"CLI", cli, implied, 2, false},
+	0xb8: {"CLV", clv, implied, 2, false},
 
 	// CMP
-	0xc9: {cmp, immediate, 2, false},
-	0xc5: {cmp, zeroPage, 3, false},
-	0xd5: {cmp, zeroPageX, 4, false},
-	0xcd: {cmp, absolute, 4, false},
-	0xdd: {cmp, absoluteX, 4, true},
-	0xd9: {cmp, absoluteY, 4, true},
-	0xc1: {cmp, indirectX, 6, false},
-	0xd1: {cmp, indirectY, 5, true},
+	0xc9: {"CMP", cmp, immediate, 2, false},
+	0xc5: {"CMP", cmp, zeroPage, 3, false},
+	0xd5: {"CMP", cmp, zeroPageX, 4, false},
+	0xcd: {"CMP", cmp, absolute, 4, false},
+	0xdd: {"CMP", cmp, absoluteX, 4, true},
+	0xd9: {"CMP", cmp, absoluteY, 4, true},
+	0xc1: {"CMP", cmp, indirectX, 6, false},
+	0xd1: {"CMP", cmp, indirectY, 5, true},
 
 	// CPX
-	0xe0: {cpx, immediate, 2, false},
-	0xe4: {cpx, zeroPage, 3, false},
-	0xec: {cpx, absolute, 4, false},
+	0xe0: {"CPX", cpx, immediate, 2, false},
+	0xe4: {"CPX", cpx, zeroPage, 3, false},
+	0xec: {"CPX", cpx, absolute, 4, false},
 
 	// CPY
-	0xc0: {cpy, immediate, 2, false},
-	0xc4: {cpy, zeroPage, 3, false},
-	0xcc: {cpy, absolute, 4, false},
+	0xc0: {"CPY", cpy, immediate, 2, false},
+	0xc4: {"CPY", cpy, zeroPage, 3, false},
+	0xcc: {"CPY", cpy, absolute, 4, false},
 
 	// DEC
-	0xc6: {dec, zeroPage, 5, false},
-	0xd6: {dec, zeroPageX, 6, false},
-	0xce: {dec, absolute, 6, false},
-	0xde: {dec, absoluteX, 7, false},
+	0xc6: {"DEC", dec, zeroPage, 5, false},
+	0xd6: {"DEC", dec, zeroPageX, 6, false},
+	0xce: {"DEC", dec, absolute, 6, false},
+	0xde: {"DEC", dec, absoluteX, 7, false},
 
 	// Dec Registers
-	0xca: {dex, implied, 2, false},
-	0x88: {dey, implied, 2, false},
+	0xca: {"DEX", dex, implied, 2, false},
+	0x88: {"DEY", dey, implied, 2, false},
 
 	// EOR
-	0x49: {eor, immediate, 2, false},
-	0x45: {eor, zeroPage, 3, false},
-	0x55: {eor, zeroPageX, 4, false},
-	0x4d: {eor, absolute, 4, false},
-	0x5d: {eor, absoluteX, 4, true},
-	0x59: {eor, absoluteY, 4, true},
-	0x41: {eor, indirectX, 6, false},
-	0x51: {eor, indirectY, 5, true},
+	0x49: {"EOR", eor, immediate, 2, false},
+	0x45: {"EOR", eor, zeroPage, 3, false},
+	0x55: {"EOR", eor, zeroPageX, 4, false},
+	0x4d: {"EOR", eor, absolute, 4, false},
+	0x5d: {"EOR", eor, absoluteX, 4, true},
+	0x59: {"EOR", eor, absoluteY, 4, true},
+	0x41: {"EOR", eor, indirectX, 6, false},
+	0x51: {"EOR", eor, indirectY, 5, true},
 
 	// INC
-	0xe6: {inc, zeroPage, 5, false},
-	0xf6: {inc, zeroPageX, 6, false},
-	0xee: {inc, absolute, 6, false},
-	0xfe: {inc, absoluteX, 7, false},
+	0xe6: {"INC", inc, zeroPage, 5, false},
+	0xf6: {"INC", inc, zeroPageX, 6, false},
+	0xee: {"INC", inc, absolute, 6, false},
+	0xfe: {"INC", inc, absoluteX, 7, false},
 
 	// Inc Registers
-	0xe8: {inx, implied, 2, false},
-	0xc8: {iny, implied, 2, false},
+	0xe8: {"INX", inx, implied, 2, false},
+	0xc8: {"INY", iny, implied, 2, false},
 
 	// JMP
-	0x4c: {jmp, absolute, 3, false},
-	0x6c: {jmp, indirect, 5, false},
+	0x4c: {"JMP", jmp, absolute, 3, false},
+	0x6c: {"JMP", jmp, indirect, 5, false},
 
 	// JSR
-	0x20: {jsr, absolute, 6, false},
+	0x20: {"JSR", jsr, absoluteDeferred, 6, false},
 
 	// LDA
-	0xa9: {lda, immediate, 2, false},
-	0xa5: {lda, zeroPage, 3, false},
-	0xb5: {lda, zeroPageX, 4, false},
-	0xad: {lda, absolute, 4, false},
-	0xbd: {lda, absoluteX, 4, true},
-	0xb9: {lda, absoluteY, 4, true},
-	0xa1: {lda, indirectX, 6, false},
-	0xb1: {lda, indirectY, 5, true},
+	0xa9: {"LDA", lda, immediate, 2, false},
+	0xa5: {"LDA", lda, zeroPage, 3, false},
+	0xb5: {"LDA", lda, zeroPageX, 4, false},
+	0xad: {"LDA", lda, absolute, 4, false},
+	0xbd: {"LDA", lda, absoluteX, 4, true},
+	0xb9: {"LDA", lda, absoluteY, 4, true},
+	0xa1: {"LDA", lda, indirectX, 6, false},
+	0xb1: {"LDA", lda, indirectY, 5, true},
 
 	// LDX
-	0xa2: {ldx, immediate, 2, false},
-	0xa6: {ldx, zeroPage, 3, false},
-	0xb6: {ldx, zeroPageY, 4, false},
-	0xae: {ldx, absolute, 4, false},
-	0xbe: {ldx, absoluteY, 4, true},
+	0xa2: {"LDX", ldx, immediate, 2, false},
+	0xa6: {"LDX", ldx, zeroPage, 3, false},
+	0xb6: {"LDX", ldx, zeroPageY, 4, false},
+	0xae: {"LDX", ldx, absolute, 4, false},
+	0xbe: {"LDX", ldx, absoluteY, 4, true},
 
 	// LDY
-	0xa0: {ldy, immediate, 2, false},
-	0xa4: {ldy, zeroPage, 3, false},
-	0xb4: {ldy, zeroPageX, 4, false},
-	0xac: {ldy, absolute, 4, false},
-	0xbc: {ldy, absoluteX, 4, true},
+	0xa0: {"LDY", ldy, immediate, 2, false},
+	0xa4: {"LDY", ldy, zeroPage, 3, false},
+	0xb4: {"LDY", ldy, zeroPageX, 4, false},
+	0xac: {"LDY", ldy, absolute, 4, false},
+	0xbc: {"LDY", ldy, absoluteX, 4, true},
 
 	// LSR
-	0x4a: {lsrAcc, accumulator, 2, false},
-	0x46: {lsr, zeroPage, 5, false},
-	0x56: {lsr, zeroPageX, 6, false},
-	0x4e: {lsr, absolute, 6, false},
-	0x5e: {lsr, absoluteX, 7, false},
+	0x4a: {"LSR", lsrAcc, accumulator, 2, false},
+	0x46: {"LSR", lsr, zeroPage, 5, false},
+	0x56: {"LSR", lsr, zeroPageX, 6, false},
+	0x4e: {"LSR", lsr, absolute, 6, false},
+	0x5e: {"LSR", lsr, absoluteX, 7, false},
 
 	// NOP
-	0xea: {nop, implied, 2, false},
+	0xea: {"NOP", nop, implied, 2, false},
 
 	// ORA
-	0x09: {ora, immediate, 2, false},
-	0x05: {ora, zeroPage, 3, false},
-	0x15: {ora, zeroPageX, 4, false},
-	0x0d: {ora, absolute, 4, false},
-	0x1d: {ora, absoluteX, 4, true},
-	0x19: {ora, absoluteY, 4, true},
-	0x01: {ora, indirectX, 6, false},
-	0x11: {ora, indirectY, 5, true},
+	0x09: {"ORA", ora, immediate, 2, false},
+	0x05: {"ORA", ora, zeroPage, 3, false},
+	0x15: {"ORA", ora, zeroPageX, 4, false},
+	0x0d: {"ORA", ora, absolute, 4, false},
+	0x1d: {"ORA", ora, absoluteX, 4, true},
+	0x19: {"ORA", ora, absoluteY, 4, true},
+	0x01: {"ORA", ora, indirectX, 6, false},
+	0x11: {"ORA", ora, indirectY, 5, true},
 
 	// Push
-	0x48: {pha, implied, 3, false},
-	0x08: {php, implied, 3, false},
+	0x48: {"PHA", pha, implied, 3, false},
+	0x08: {"PHP", php, implied, 3, false},
 
 	// Pull
-	0x68: {pla, implied, 4, false},
-	0x28: {plp, implied, 4, false},
+	0x68: {"PLA", pla, implied, 4, false},
+	0x28: {"PLP", plp, implied, 4, false},
 
 	// ROL
-	0x2a: {rolAcc, accumulator, 2, false},
-	0x26: {rol, zeroPage, 5, false},
-	0x36: {rol, zeroPageX, 6, false},
-	0x2e: {rol, absolute, 6, false},
-	0x3e: {rol, absoluteX, 7, false},
+	0x2a: {"ROL", rolAcc, accumulator, 2, false},
+	0x26: {"ROL", rol, zeroPage, 5, false},
+	0x36: {"ROL", rol, zeroPageX, 6, false},
+	0x2e: {"ROL", rol, absolute, 6, false},
+	0x3e: {"ROL", rol, absoluteX, 7, false},
 
 	// ROR
-	0x6a: {rorAcc, accumulator, 2, false},
-	0x66: {ror, zeroPage, 5, false},
-	0x76: {ror, zeroPageX, 6, false},
-	0x6e: {ror, absolute, 6, false},
-	0x7e: {ror, absoluteX, 7, false},
+	0x6a: {"ROR", rorAcc, accumulator, 2, false},
+	0x66: {"ROR", ror, zeroPage, 5, false},
+	0x76: {"ROR", ror, zeroPageX, 6, false},
+	0x6e: {"ROR", ror, absolute, 6, false},
+	0x7e: {"ROR", ror, absoluteX, 7, false},
 
 	// RTI
-	0x40: {rti, implied, 6, false},
+	0x40: {"RTI", rti, implied, 6, false},
 
 	// RTS
-	0x60: {rts, implied, 6, false},
+	0x60: {"RTS", rts, implied, 6, false},
 
 	// SBC
-	0xe9: {sbc, immediate, 2, false},
-	0xe5: {sbc, zeroPage, 3, false},
-	0xf5: {sbc, zeroPageX, 4, false},
-	0xed: {sbc, absolute, 4, false},
-	0xfd: {sbc, absoluteX, 4, true},
-	0xf9: {sbc, absoluteY, 4, true},
-	0xe1: {sbc, indirectX, 6, false},
-	0xf1: {sbc, indirectY, 5, true},
+	0xe9: {"SBC", sbc, immediate, 2, false},
+	0xe5: {"SBC", sbc, zeroPage, 3, false},
+	0xf5: {"SBC", sbc, zeroPageX, 4, false},
+	0xed: {"SBC", sbc, absolute, 4, false},
+	0xfd: {"SBC", sbc, absoluteX, 4, true},
+	0xf9: {"SBC", sbc, absoluteY, 4, true},
+	0xe1: {"SBC", sbc, indirectX, 6, false},
+	0xf1: {"SBC", sbc, indirectY, 5, true},
 
 	// Set flags
-	0x38: {sec, implied, 2, false},
-	0xf8: {sed, implied, 2, false},
-	0x78: {sei, implied, 2, false},
+	0x38: {"SEC", sec, implied, 2, false},
+	0xf8: {"SED", sed, implied, 2, false},
+	0x78: {"SEI", sei, implied, 2, false},
 
 	// STA
-	0x85: {sta, zeroPage, 3, false},
-	0x95: {sta, zeroPageX, 4, false},
-	0x8d: {sta, absolute, 4, false},
-	0x9d: {sta, absoluteX, 5, false},
-	0x99: {sta, absoluteY, 5, false},
-	0x81: {sta, indirectX, 6, false},
-	0x91: {sta, indirectY, 6, false},
+	0x85: {"STA", sta, zeroPage, 3, false},
+	0x95: {"STA", sta, zeroPageX, 4, false},
+	0x8d: {"STA", sta, absolute, 4, false},
+	0x9d: {"STA", sta, absoluteX, 5, false},
+	0x99: {"STA", sta, absoluteY, 5, false},
+	0x81: {"STA", sta, indirectX, 6, false},
+	0x91: {"STA", sta, indirectY, 6, false},
 
 	// STX
-	0x86: {stx, zeroPage, 3, false},
-	0x96: {stx, zeroPageY, 4, false},
-	0x8e: {stx, absolute, 4, false},
+	0x86: {"STX", stx, zeroPage, 3, false},
+	0x96: {"STX", stx, zeroPageY, 4, false},
+	0x8e: {"STX", stx, absolute, 4, false},
 
 	// STY
-	0x84: {sty, zeroPage, 3, false},
-	0x94: {sty, zeroPageX, 4, false},
-	0x8c: {sty, absolute, 4, false},
+	0x84: {"STY", sty, zeroPage, 3, false},
+	0x94: {"STY", sty, zeroPageX, 4, false},
+	0x8c: {"STY", sty, absolute, 4, false},
 
 	// Transfers
-	0xaa: {tax, implied, 2, false},
-	0xa8: {tay, implied, 2, false},
-	0xba: {tsx, implied, 2, false},
-	0x8a: {txa, implied, 2, false},
-	0x9a: {txs, implied, 2, false},
-	0x98: {tya, implied, 2, false},
+	0xaa: {"TAX", tax, implied, 2, false},
+	0xa8: {"TAY", tay, implied, 2, false},
+	0xba: {"TSX", tsx, implied, 2, false},
+	0x8a: {"TXA", txa, implied, 2, false},
+	0x9a: {"TXS", txs, implied, 2, false},
+	0x98: {"TYA", tya, implied, 2, false},
+
+	// Unofficial opcodes
+
+	// ALR, ANC, ARR, AXS
+	0x4b: {"*ALR", alr, immediate, 2, false},
+	0x0b: {"*ANC", anc, immediate, 2, false},
+	0x2b: {"*ANC", anc, immediate, 2, false},
+	0x6b: {"*ARR", arr, immediate, 2, false},
+	0xcb: {"*AXS", axs, immediate, 2, false},
+
+	// DCP
+	0xc7: {"*DCP", dcp, zeroPage, 5, false},
+	0xd7: {"*DCP", dcp, zeroPageX, 6, false},
+	0xcf: {"*DCP", dcp, absolute, 6, false},
+	0xdf: {"*DCP", dcp, absoluteX, 7, false},
+	0xdb: {"*DCP", dcp, absoluteY, 7, false},
+	0xc3: {"*DCP", dcp, indirectX, 8, false},
+	0xd3: {"*DCP", dcp, indirectY, 8, false},
+
+	// ISC
+	0xe7: {"*ISB", isc, zeroPage, 5, false},
+	0xf7: {"*ISB", isc, zeroPageX, 6, false},
+	0xef: {"*ISB", isc, absolute, 6, false},
+	0xff: {"*ISB", isc, absoluteX, 7, false},
+	0xfb: {"*ISB", isc, absoluteY, 7, false},
+	0xe3: {"*ISB", isc, indirectX, 8, false},
+	0xf3: {"*ISB", isc, indirectY, 8, false},
+
+	// KIL
+	0x02: {"*KIL", kil, implied, 2, false},
+	0x12: {"*KIL", kil, implied, 2, false},
+	0x22: {"*KIL", kil, implied, 2, false},
+	0x32: {"*KIL", kil, implied, 2, false},
+	0x42: {"*KIL", kil, implied, 2, false},
+	0x52: {"*KIL", kil, implied, 2, false},
+	0x62: {"*KIL", kil, implied, 2, false},
+	0x72: {"*KIL", kil, implied, 2, false},
+	0x92: {"*KIL", kil, implied, 2, false},
+	0xb2: {"*KIL", kil, implied, 2, false},
+	0xd2: {"*KIL", kil, implied, 2, false},
+	0xf2: {"*KIL", kil, implied, 2, false},
+
+	// LAS
+	0xbb: {"*LAS", las, absoluteY, 4, true},
+
+	// LAX
+	0xab: {"*LAX", lax, immediate, 2, false},
+	0xa7: {"*LAX", lax, zeroPage, 3, false},
+	0xb7: {"*LAX", lax, zeroPageY, 4, false},
+	0xaf: {"*LAX", lax, absolute, 4, false},
+	0xbf: {"*LAX", lax, absoluteY, 4, true},
+	0xa3: {"*LAX", lax, indirectX, 6, false},
+	0xb3: {"*LAX", lax, indirectY, 5, true},
+
+	// NOP
+	0x1a: {"*NOP", nop, implied, 2, false},
+	0x3a: {"*NOP", nop, implied, 2, false},
+	0x5a: {"*NOP", nop, implied, 2, false},
+	0x7a: {"*NOP", nop, implied, 2, false},
+	0xda: {"*NOP", nop, implied, 2, false},
+	0xfa: {"*NOP", nop, implied, 2, false},
+	0x80: {"*NOP", nopRead, immediate, 2, false},
+	0x82: {"*NOP", nopRead, immediate, 2, false},
+	0x89: {"*NOP", nopRead, immediate, 2, false},
+	0xc2: {"*NOP", nopRead, immediate, 2, false},
+	0xe2: {"*NOP", nopRead, immediate, 2, false},
+	0x04: {"*NOP", nopRead, zeroPage, 3, false},
+	0x44: {"*NOP", nopRead, zeroPage, 3, false},
+	0x64: {"*NOP", nopRead, zeroPage, 3, false},
+	0x14: {"*NOP", nopRead, zeroPageX, 4, false},
+	0x34: {"*NOP", nopRead, zeroPageX, 4, false},
+	0x54: {"*NOP", nopRead, zeroPageX, 4, false},
+	0x74: {"*NOP", nopRead, zeroPageX, 4, false},
+	0xd4: {"*NOP", nopRead, zeroPageX, 4, false},
+	0xf4: {"*NOP", nopRead, zeroPageX, 4, false},
+	0x0c: {"*NOP", nopRead, absolute, 4, false},
+	0x1c: {"*NOP", nopRead, absoluteX, 4, true},
+	0x3c: {"*NOP", nopRead, absoluteX, 4, true},
+	0x5c: {"*NOP", nopRead, absoluteX, 4, true},
+	0x7c: {"*NOP", nopRead, absoluteX, 4, true},
+	0xdc: {"*NOP", nopRead, absoluteX, 4, true},
+	0xfc: {"*NOP", nopRead, absoluteX, 4, true},
+
+	// RLA
+	0x27: {"*RLA", rla, zeroPage, 5, false},
+	0x37: {"*RLA", rla, zeroPageX, 6, false},
+	0x2f: {"*RLA", rla, absolute, 6, false},
+	0x3f: {"*RLA", rla, absoluteX, 7, false},
+	0x3b: {"*RLA", rla, absoluteY, 7, false},
+	0x23: {"*RLA", rla, indirectX, 8, false},
+	0x33: {"*RLA", rla, indirectY, 8, false},
+
+	// RRA
+	0x67: {"*RRA", rra, zeroPage, 5, false},
+	0x77: {"*RRA", rra, zeroPageX, 6, false},
+	0x6f: {"*RRA", rra, absolute, 6, false},
+	0x7f: {"*RRA", rra, absoluteX, 7, false},
+	0x7b: {"*RRA", rra, absoluteY, 7, false},
+	0x63: {"*RRA", rra, indirectX, 8, false},
+	0x73: {"*RRA", rra, indirectY, 8, false},
+
+	// SAX
+	0x87: {"*SAX", sax, zeroPage, 3, false},
+	0x97: {"*SAX", sax, zeroPageY, 4, false},
+	0x8f: {"*SAX", sax, absolute, 4, false},
+	0x83: {"*SAX", sax, indirectX, 6, false},
+
+	// SBC
+	0xeb: {"*SBC", sbc, immediate, 2, false},
+
+	// SHA, SHX, SHY, TAS
+	0x9f: {"*SHA", sha, absoluteY, 5, false},
+	0x93: {"*SHA", sha, indirectY, 6, false},
+	0x9e: {"*SHX", shx, absoluteY, 5, false},
+	0x9c: {"*SHY", shy, absoluteX, 5, false},
+	0x9b: {"*TAS", tas, absoluteY, 5, false},
+
+	// SLO
+	0x07: {"*SLO", slo, zeroPage, 5, false},
+	0x17: {"*SLO", slo, zeroPageX, 6, false},
+	0x0f: {"*SLO", slo, absolute, 6, false},
+	0x1f: {"*SLO", slo, absoluteX, 7, false},
+	0x1b: {"*SLO", slo, absoluteY, 7, false},
+	0x03: {"*SLO", slo, indirectX, 8, false},
+	0x13: {"*SLO", slo, indirectY, 8, false},
+
+	// SRE
+	0x47: {"*SRE", sre, zeroPage, 5, false},
+	0x57: {"*SRE", sre, zeroPageX, 6, false},
+	0x4f: {"*SRE", sre, absolute, 6, false},
+	0x5f: {"*SRE", sre, absoluteX, 7, false},
+	0x5b: {"*SRE", sre, absoluteY, 7, false},
+	0x43: {"*SRE", sre, indirectX, 8, false},
+	0x53: {"*SRE", sre, indirectY, 8, false},
+
+	// XAA
+	0x8b: {"*XAA", xaa, immediate, 2, false},
 }
 
 func adc(c *cpu, bus memoryDevice, a uint16) error {
@@ -249,19 +403,8 @@ func adc(c *cpu, bus memoryDevice, a uint16) error {
 	if err != nil {
 		return err
 	}
-	tmp := uint16(v) + uint16(c.a)
-	if c.isFlagSet(flagCarry) {
-		tmp++
-	}
-	c.setZeroFlag(uint8(tmp))
-	c.setSignFlag(uint8(tmp))
-	c.setFlagValue(flagCarry, (tmp > 0xff))
-
-	o := (((c.a ^ v) & 0x80) == 0) && (((c.a ^ uint8(tmp)) & 0x80) != 0)
-	c.setFlagValue(flagOverflow, o)
-
-	c.a = uint8(tmp)
-	return nil
+	c.addWithCarry(v)
+	return c.decimalPenalty()
 }
 
 func and(c *cpu, bus memoryDevice, a uint16) error {
@@ -276,7 +419,7 @@ func and(c *cpu, bus memoryDevice, a uint16) error {
 }
 
 func asl(c *cpu, bus memoryDevice, a uint16) error {
-	v, err := bus.read(a)
+	v, err := readModifyWrite(c, bus, a)
 	if err != nil {
 		return err
 	}
@@ -297,21 +440,21 @@ func aslAcc(c *cpu, bus memoryDevice, a uint16) error {
 
 func bcc(c *cpu, bus memoryDevice, a uint16) error {
 	if !c.isFlagSet(flagCarry) {
-		branch(c, a)
+		return branch(c, a)
 	}
 	return nil
 }
 
 func bcs(c *cpu, bus memoryDevice, a uint16) error {
 	if c.isFlagSet(flagCarry) {
-		branch(c, a)
+		return branch(c, a)
 	}
 	return nil
 }
 
 func beq(c *cpu, bus memoryDevice, a uint16) error {
 	if c.isFlagSet(flagZero) {
-		branch(c, a)
+		return branch(c, a)
 	}
 	return nil
 }
@@ -329,21 +472,21 @@ func bit(c *cpu, bus memoryDevice, a uint16) error {
 
 func bmi(c *cpu, bus memoryDevice, a uint16) error {
 	if c.isFlagSet(flagSign) {
-		branch(c, a)
+		return branch(c, a)
 	}
 	return nil
 }
 
 func bne(c *cpu, bus memoryDevice, a uint16) error {
 	if !c.isFlagSet(flagZero) {
-		branch(c, a)
+		return branch(c, a)
 	}
 	return nil
 }
 
 func bpl(c *cpu, bus memoryDevice, a uint16) error {
 	if !c.isFlagSet(flagSign) {
-		branch(c, a)
+		return branch(c, a)
 	}
 	return nil
 }
@@ -355,14 +498,14 @@ func brk(c *cpu, bus memoryDevice, a uint16) error {
 
 func bvc(c *cpu, bus memoryDevice, a uint16) error {
 	if !c.isFlagSet(flagOverflow) {
-		branch(c, a)
+		return branch(c, a)
 	}
 	return nil
 }
 
 func bvs(c *cpu, bus memoryDevice, a uint16) error {
 	if c.isFlagSet(flagOverflow) {
-		branch(c, a)
+		return branch(c, a)
 	}
 	return nil
 }
@@ -392,10 +535,7 @@ func cmp(c *cpu, bus memoryDevice, a uint16) error {
 	if err != nil {
 		return err
 	}
-	c.setFlagValue(flagCarry, (v <= c.a))
-	v = c.a - v
-	c.setSignFlag(v)
-	c.setZeroFlag(v)
+	c.compare(c.a, v)
 	return nil
 }
 
@@ -404,10 +544,7 @@ func cpx(c *cpu, bus memoryDevice, a uint16) error {
 	if err != nil {
 		return err
 	}
-	c.setFlagValue(flagCarry, (v <= c.x))
-	v = c.x - v
-	c.setSignFlag(v)
-	c.setZeroFlag(v)
+	c.compare(c.x, v)
 	return nil
 }
 
@@ -416,15 +553,12 @@ func cpy(c *cpu, bus memoryDevice, a uint16) error {
 	if err != nil {
 		return err
 	}
-	c.setFlagValue(flagCarry, (v <= c.y))
-	v = c.y - v
-	c.setSignFlag(v)
-	c.setZeroFlag(v)
+	c.compare(c.y, v)
 	return nil
 }
 
 func dec(c *cpu, bus memoryDevice, a uint16) error {
-	v, err := bus.read(a)
+	v, err := readModifyWrite(c, bus, a)
 	if err != nil {
 		return err
 	}
@@ -460,7 +594,7 @@ func eor(c *cpu, bus memoryDevice, a uint16) error {
 }
 
 func inc(c *cpu, bus memoryDevice, a uint16) error {
-	v, err := bus.read(a)
+	v, err := readModifyWrite(c, bus, a)
 	if err != nil {
 		return err
 	}
@@ -490,12 +624,24 @@ func jmp(c *cpu, bus memoryDevice, a uint16) error {
 }
 
 func jsr(c *cpu, bus memoryDevice, a uint16) error {
-	c.pc--
-	err := c.pushWord(bus, c.pc)
+	low, err := bus.read(a)
 	if err != nil {
 		return err
 	}
-	c.pc = a
+	err = c.stackDummyRead(bus)
+	if err != nil {
+		return err
+	}
+	// the return address is that of the high byte, which is read last
+	err = c.pushWord(bus, a+1)
+	if err != nil {
+		return err
+	}
+	hi, err := bus.read(a + 1)
+	if err != nil {
+		return err
+	}
+	c.pc = (uint16(hi) << 8) + uint16(low)
 	return nil
 }
 
@@ -533,7 +679,7 @@ func ldy(c *cpu, bus memoryDevice, a uint16) error {
 }
 
 func lsr(c *cpu, bus memoryDevice, a uint16) error {
-	v, err := bus.read(a)
+	v, err := readModifyWrite(c, bus, a)
 	if err != nil {
 		return err
 	}
@@ -576,6 +722,10 @@ func php(c *cpu, bus memoryDevice, a uint16) error {
 }
 
 func pla(c *cpu, bus memoryDevice, a uint16) error {
+	err := c.stackDummyRead(bus)
+	if err != nil {
+		return err
+	}
 	v, err := c.pull(bus)
 	if err != nil {
 		return err
@@ -587,6 +737,10 @@ func pla(c *cpu, bus memoryDevice, a uint16) error {
 }
 
 func plp(c *cpu, bus memoryDevice, a uint16) error {
+	err := c.stackDummyRead(bus)
+	if err != nil {
+		return err
+	}
 	v, err := c.pull(bus)
 	if err != nil {
 		return err
@@ -596,7 +750,7 @@ func plp(c *cpu, bus memoryDevice, a uint16) error {
 }
 
 func rol(c *cpu, bus memoryDevice, a uint16) error {
-	v, err := bus.read(a)
+	v, err := readModifyWrite(c, bus, a)
 	if err != nil {
 		return err
 	}
@@ -624,7 +778,7 @@ func rolAcc(c *cpu, bus memoryDevice, a uint16) error {
 }
 
 func ror(c *cpu, bus memoryDevice, a uint16) error {
-	v, err := bus.read(a)
+	v, err := readModifyWrite(c, bus, a)
 	if err != nil {
 		return err
 	}
@@ -652,6 +806,10 @@ func rorAcc(c *cpu, bus memoryDevice, a uint16) error {
 }
 
 func rti(c *cpu, bus memoryDevice, a uint16) error {
+	err := c.stackDummyRead(bus)
+	if err != nil {
+		return err
+	}
 	v, err := c.pull(bus)
 	if err != nil {
 		return err
@@ -666,10 +824,19 @@ func rti(c *cpu, bus memoryDevice, a uint16) error {
 }
 
 func rts(c *cpu, bus memoryDevice, a uint16) error {
+	err := c.stackDummyRead(bus)
+	if err != nil {
+		return err
+	}
 	v, err := c.pullWord(bus)
 	if err != nil {
 		return err
 	}
+	// the pulled address is read once more before it is incremented
+	_, err = bus.read(v)
+	if err != nil {
+		return err
+	}
 	c.pc = v + 1
 	return nil
 }
@@ -679,20 +846,8 @@ func sbc(c *cpu, bus memoryDevice, a uint16) error {
 	if err != nil {
 		return err
 	}
-	v = ^v
-	tmp := uint16(v) + uint16(c.a)
-	if c.isFlagSet(flagCarry) {
-		tmp++
-	}
-	c.setZeroFlag(uint8(tmp))
-	c.setSignFlag(uint8(tmp))
-	c.setFlagValue(flagCarry, (tmp > 0xff))
-
-	o := (((c.a ^ v) & 0x80) == 0) && (((c.a ^ uint8(tmp)) & 0x80) != 0)
-	c.setFlagValue(flagOverflow, o)
-
-	c.a = uint8(tmp)
-	return nil
+	c.subtractWithBorrow(v)
+	return c.decimalPenalty()
 }
 
 func sec(c *cpu, bus memoryDevice, a uint16) error {
@@ -762,10 +917,84 @@ func tya(c *cpu, bus memoryDevice, a uint16) error {
 	return nil
 }
 
-func branch(c *cpu, a uint16) {
-	c.clock++
+// branch jumps to a, making a dummy read of the next opcode and another of
+// the wrong page if the jump crosses one. An IRQ that first appears on the
+// last cycle of a taken branch without a page cross is delayed until after
+// the next instruction.
+func branch(c *cpu, a uint16) error {
+	if c.runIRQ && !c.prevRunIRQ {
+		c.runIRQ = false
+	}
+	if c.runNMI && !c.prevRunNMI {
+		c.runNMI = false
+	}
+	if _, err := c.read(c.pc); err != nil {
+		return err
+	}
 	if pageCrossed(c.pc, a) {
-		c.clock++
+		if _, err := c.read((c.pc & 0xff00) | (a & 0x00ff)); err != nil {
+			return err
+		}
 	}
 	c.pc = a
+	return nil
+}
+
+// readModifyWrite reads the operand of a read-modify-write instruction. The
+// NMOS CPU writes the unmodified value back while it performs the operation,
+// where the 65C02 reads it again instead.
+func readModifyWrite(c *cpu, bus memoryDevice, a uint16) (uint8, error) {
+	v, err := bus.read(a)
+	if err != nil {
+		return 0, err
+	}
+	if c.variant == CPU65C02 {
+		_, err = bus.read(a)
+		return v, err
+	}
+	return v, bus.write(a, v)
+}
+
+// addWithCarry adds a value and the carry flag to the accumulator.
+func (c *cpu) addWithCarry(v uint8) {
+	if c.decimalMode() {
+		c.addDecimal(v)
+		return
+	}
+	c.addBinary(v)
+}
+
+// subtractWithBorrow subtracts a value and the borrow (the inverted carry
+// flag) from the accumulator.
+func (c *cpu) subtractWithBorrow(v uint8) {
+	if c.decimalMode() {
+		c.subtractDecimal(v)
+		return
+	}
+	c.addBinary(^v)
+}
+
+// addBinary adds a value and the carry flag to the accumulator. Binary
+// subtraction is performed by adding the complement of a value.
+func (c *cpu) addBinary(v uint8) {
+	tmp := uint16(v) + uint16(c.a)
+	if c.isFlagSet(flagCarry) {
+		tmp++
+	}
+	c.setZeroFlag(uint8(tmp))
+	c.setSignFlag(uint8(tmp))
+	c.setFlagValue(flagCarry, (tmp > 0xff))
+
+	o := (((c.a ^ v) & 0x80) == 0) && (((c.a ^ uint8(tmp)) & 0x80) != 0)
+	c.setFlagValue(flagOverflow, o)
+
+	c.a = uint8(tmp)
+}
+
+// compare sets flags as if v were subtracted from a register.
+func (c *cpu) compare(r, v uint8) {
+	c.setFlagValue(flagCarry, (v <= r))
+	v = r - v
+	c.setSignFlag(v)
+	c.setZeroFlag(v)
 }
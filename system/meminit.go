@@ -0,0 +1,85 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// RAMPattern selects the values found in memory at power on.
+type RAMPattern int
+
+const (
+	// RAMZeros fills memory with $00.
+	RAMZeros RAMPattern = iota
+
+	// RAMOnes fills memory with $FF.
+	RAMOnes
+
+	// RAMAlternating fills memory with alternating runs of four $00 and
+	// four $FF bytes, a pattern common to many consoles.
+	RAMAlternating
+
+	// RAMRandom fills memory with random values from Options.Seed.
+	RAMRandom
+)
+
+var ramPatternNames = map[string]RAMPattern{
+	"zeros":       RAMZeros,
+	"ones":        RAMOnes,
+	"alternating": RAMAlternating,
+	"random":      RAMRandom,
+}
+
+// ParseRAMPattern returns the pattern named zeros, ones, alternating, or
+// random.
+func ParseRAMPattern(name string) (RAMPattern, error) {
+	p, ok := ramPatternNames[name]
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("unknown ram pattern %q", name))
+	}
+	return p, nil
+}
+
+// memoryInit fills memory at power on. Every power on with the same options
+// produces the same memory contents.
+type memoryInit struct {
+	pattern RAMPattern
+	rand    *rand.Rand
+}
+
+func newMemoryInit(opts Options) *memoryInit {
+	return &memoryInit{
+		pattern: opts.RAM,
+		rand:    rand.New(rand.NewSource(opts.Seed)),
+	}
+}
+
+func (m *memoryInit) fill(mem []uint8) {
+	for i := range mem {
+		switch m.pattern {
+		case RAMZeros:
+			mem[i] = 0
+		case RAMOnes:
+			mem[i] = 0xff
+		case RAMAlternating:
+			if i&0x4 != 0 {
+				mem[i] = 0xff
+			} else {
+				mem[i] = 0
+			}
+		case RAMRandom:
+			mem[i] = uint8(m.rand.Intn(0x100))
+		}
+	}
+}
+
+// randomizeCPU sets the CPU registers to random values. Interrupts stay
+// disabled, as they are at power on.
+func (m *memoryInit) randomizeCPU(c *cpu) {
+	c.a = uint8(m.rand.Intn(0x100))
+	c.x = uint8(m.rand.Intn(0x100))
+	c.y = uint8(m.rand.Intn(0x100))
+	c.sp = uint8(m.rand.Intn(0x100))
+	c.p = uint8(m.rand.Intn(0x100))&^(flagBreak|flagBreakHi) | flagInterrupt
+}
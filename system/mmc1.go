@@ -16,6 +16,10 @@ const (
 	prgROMBankMode32K      = 0
 	prgROMBankModeFixFirst = 2
 	prgROMBankModeFixLast  = 3
+
+	// SUROM and SXROM boards select a 256 KB half of their 512 KB PRG ROM
+	// with a bit of the CHR bank register
+	mmc1PRGOuterBankSize = 0x40000
 )
 
 // mmc1 CPU banks
@@ -26,6 +30,11 @@ const (
 // mmc1 PPU banks
 // 0x0000 - 0x0fff: switchable CHR bank
 // 0x1000 - 0x1fff: switchable CHR bank
+
+// Boards with CHR RAM reuse the upper CHR bank bits: bit 4 selects the PRG
+// ROM half on SUROM and SXROM, and bits 2-3 select the PRG RAM bank on SOROM
+// (16 KB) and SXROM (32 KB). The variant is told apart by the ROM and RAM
+// sizes, and only the first CHR bank register is used to select banks.
 type mmc1 struct {
 	prgROM []uint8
 	prgRAM []uint8
@@ -40,19 +49,28 @@ type mmc1 struct {
 	prgRAMEnabled bool
 	mirror        mirrorMode
 	sr            uint8
+
+	// the cycle of the last shift register write, used to ignore the
+	// dummy write made by read-modify-write instructions
+	cycle, lastWrite uint64
+}
+
+func (c *mmc1) step(cpuCycles uint64) error {
+	c.cycle += cpuCycles
+	return nil
 }
 
 func (c *mmc1) read(a uint16) (uint8, error) {
 	switch {
 	case (a >= prgRAMLowAddr) && (a <= prgRAMHighAddr):
 		if !c.prgRAMEnabled {
-			return 0, nil
+			return 0, errOpenBus
 		}
-		return c.prgRAM[a-prgRAMLowAddr], nil
+		return c.prgRAM[c.prgRAMIndex(a)], nil
 	case a >= prgROMLowAddr:
 		return c.readPRG(a)
 	default:
-		return 0, errors.New(fmt.Sprintf("oob mmc1 read at 0x%x", a))
+		return 0, errOpenBus
 	}
 }
 
@@ -60,8 +78,7 @@ func (c *mmc1) write(a uint16, v uint8) error {
 	switch {
 	case (a >= prgRAMLowAddr) && (a <= prgRAMHighAddr):
 		if c.prgRAMEnabled {
-			i := mirrorIndex(a, 0x6000, uint16(len(c.prgRAM)))
-			c.prgRAM[i] = v
+			c.prgRAM[c.prgRAMIndex(a)] = v
 		}
 	case a >= prgROMLowAddr:
 		c.writeShiftRegister(a, v)
@@ -86,6 +103,21 @@ func (c *mmc1) writeCHR(a uint16, v uint8) error {
 	return nil
 }
 
+// prgRAMIndex returns the index of a in the selected 8 KB bank of PRG RAM.
+func (c *mmc1) prgRAMIndex(a uint16) int {
+	if len(c.prgRAM) <= prgRAMBankSize {
+		return int(mirrorIndex(a, prgRAMLowAddr, uint16(len(c.prgRAM))))
+	}
+	bank := 0
+	switch len(c.prgRAM) / prgRAMBankSize {
+	case 2:
+		bank = (c.chrLowBank >> 3) & 0x1
+	case 4:
+		bank = (c.chrLowBank >> 2) & 0x3
+	}
+	return bank*prgRAMBankSize + int(a-prgRAMLowAddr)
+}
+
 func (c *mmc1) readPRG(a uint16) (uint8, error) {
 	return c.prgROM[c.getPRGAddress(a)], nil
 }
@@ -95,7 +127,12 @@ func (c *mmc1) vramMirror() mirrorMode {
 }
 
 func (c *mmc1) writeShiftRegister(a uint16, v uint8) {
-	// TODO: ignore successive writes for improved compatibility
+	// writes on consecutive cycles are ignored after the first
+	consecutive := c.lastWrite != 0 && c.cycle == c.lastWrite+1
+	c.lastWrite = c.cycle
+	if consecutive {
+		return
+	}
 
 	if v >= 0x80 {
 		// if bit 7 is set, we revert to the default value
@@ -159,34 +196,53 @@ func (c *mmc1) writePRGBankRegister(v uint8) {
 }
 
 func (c *mmc1) getPRGAddress(a uint16) int {
+	// the fixed banks are those of the selected 256 KB half
+	outer, size := 0, len(c.prgROM)
+	if size > mmc1PRGOuterBankSize {
+		outer = ((c.chrLowBank >> 4) & 0x1) * mmc1PRGOuterBankSize
+		size = mmc1PRGOuterBankSize
+	}
+
 	prgAddr := int(a - prgROMLowAddr)
 	switch c.prgROMBankMode {
 	case prgROMBankModeFixFirst:
 		if prgAddr < prgROMBankSize {
-			return prgAddr
+			return outer + prgAddr
 		}
-		return (prgAddr % prgROMBankSize) + (c.prgROMBank * prgROMBankSize)
+		return outer + (prgAddr % prgROMBankSize) + (c.prgROMBank * prgROMBankSize)
 	case prgROMBankModeFixLast:
 		if prgAddr >= prgROMBankSize {
-			return len(c.prgROM) - (2 * prgROMBankSize) + prgAddr
+			return outer + size - (2 * prgROMBankSize) + prgAddr
 		}
-		return prgAddr + (c.prgROMBank * prgROMBankSize)
+		return outer + prgAddr + (c.prgROMBank * prgROMBankSize)
 	default:
 		bank := c.prgROMBank - (c.prgROMBank % 2)
-		return prgAddr + (bank * prgROMBankSize)
+		return outer + prgAddr + (bank * prgROMBankSize)
 	}
 }
 
 func (c *mmc1) getCHRIndex(a uint16) int {
+	// bank bits past the end of CHR are ignored, as the boards that use
+	// them for PRG banking have 8 KB of CHR RAM
+	var i int
 	if c.chrBank8K {
 		bank := c.chrLowBank - (c.chrLowBank % 2)
-		return int(a) + (bank * chrBankSize)
+		i = int(a) + (bank * chrBankSize)
 	} else if a < chrBankSize {
-		return int(a) + (c.chrLowBank * chrBankSize)
+		i = int(a) + (c.chrLowBank * chrBankSize)
+	} else {
+		i = int(a) - chrBankSize + (c.chrHighBank * chrBankSize)
 	}
-	return int(a) - chrBankSize + (c.chrHighBank * chrBankSize)
+	return i % len(c.chr)
 }
 
 func (c *mmc1) incScanline(cp *cpu) error {
 	return nil
 }
+
+func (c *mmc1) serialize(s *stateCodec) {
+	s.bytes(c.prgRAM)
+	s.bytes(c.chr)
+	s.ints(&c.prgROMBank, &c.chrLowBank, &c.chrHighBank, &c.prgROMBankMode, (*int)(&c.mirror))
+	s.fixed(&c.chrBank8K, &c.prgRAMEnabled, &c.sr, &c.cycle, &c.lastWrite)
+}
@@ -50,7 +50,8 @@ type mmc3 struct {
 func (c *mmc3) read(a uint16) (uint8, error) {
 	switch {
 	case (a >= prgRAMLowAddr) && (a <= prgRAMHighAddr):
-		return c.prgRAM[a-prgRAMLowAddr], nil
+		i := mirrorIndex(a, prgRAMLowAddr, uint16(len(c.prgRAM)))
+		return c.prgRAM[i], nil
 	case (a >= mmc3PRGRomBank1Low) && (a <= mmc3PRGRomBank1High):
 		if c.mmcRegister {
 			// low bank fixed to the second-last 8KB bank
@@ -73,13 +74,14 @@ func (c *mmc3) read(a uint16) (uint8, error) {
 		i := int(a-mmc3PRGRomBank4Low) + len(c.prgROM) - mmc3ROMBankSize
 		return c.prgROM[i], nil
 	}
-	return 0, errors.New(fmt.Sprintf("oob mmc3 read at 0x%x", a))
+	return 0, errOpenBus
 }
 
 func (c *mmc3) write(a uint16, v uint8) error {
 	switch {
 	case (a >= prgRAMLowAddr) && (a <= prgRAMHighAddr):
-		c.prgRAM[a-prgRAMLowAddr] = v
+		i := mirrorIndex(a, prgRAMLowAddr, uint16(len(c.prgRAM)))
+		c.prgRAM[i] = v
 	case (a >= prgROMLowAddr) && (a <= mmc3PRGRomBank1High):
 		if a%2 == 0 {
 			return c.writeBankSelectEven(v)
@@ -228,3 +230,11 @@ func (c *mmc3) incScanline(cp *cpu) error {
 
 	return nil
 }
+
+func (c *mmc3) serialize(s *stateCodec) {
+	s.bytes(c.prgRAM)
+	s.bytes(c.chr)
+	s.fixed(&c.bankRegIndex, &c.bankRegs, &c.mmcRegister, &c.chrA12Inverted, &c.irqEnabled,
+		&c.triggerIrqDisable, &c.triggerReload, &c.irqLatch, &c.irqCounter)
+	s.ints((*int)(&c.mirror))
+}
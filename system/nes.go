@@ -1,57 +1,373 @@
 package system
 
+import (
+	"crypto/sha1"
+	"errors"
+	"image"
+	"io"
+)
+
 // NES represents the system at its highest level.
 type NES interface {
 	// Step executes a single instruction within the NES CPU.
 	Step() error
+
+	// RunFrame runs the system until the PPU completes a frame.
+	RunFrame() error
+
+	// Framebuffer returns the last completed frame. The image is reused for
+	// later frames, so it must be copied to be kept.
+	Framebuffer() *image.RGBA
+
+	// FrameCount returns the number of frames completed since power on.
+	FrameCount() int
+
+	// SetVideoEnabled turns drawing on or off, for frames that are emulated
+	// but never shown. The PPU still runs, so games behave the same.
+	SetVideoEnabled(enabled bool)
+
+	// DiskSides returns the number of Famicom Disk System disk sides, or 0
+	// if a cartridge is loaded instead.
+	DiskSides() int
+
+	// InsertDisk inserts a Famicom Disk System disk side. If a disk is
+	// already inserted, it is ejected long enough for the BIOS to notice.
+	InsertDisk(side int) error
+
+	// EjectDisk ejects the Famicom Disk System disk.
+	EjectDisk()
+
+	// DiskImage returns the Famicom Disk System disk, including any writes,
+	// in the .fds format.
+	DiskImage() []uint8
+
+	// NSFInfo describes the loaded NSF music file, or returns nil if a game
+	// is loaded instead.
+	NSFInfo() *NSFInfo
+
+	// SelectTrack restarts an NSF music file at a track, numbered from 0.
+	SelectTrack(track int) error
+
+	// Reset presses the console's reset button. Memory and cartridge state
+	// are kept, as cartridges do not see the reset line.
+	Reset() error
+
+	// PowerCycle turns the console off and on again, clearing memory and
+	// reinitializing the cartridge. Famicom Disk System disks keep their
+	// contents.
+	PowerCycle() error
+
+	// SetTrace logs each instruction executed between the addresses low and
+	// high (inclusive) to w, in the nestest log format. A nil writer stops
+	// tracing.
+	SetTrace(w io.Writer, low, high uint16)
+
+	// ReadMemory reads a byte from the CPU address space without side
+	// effects. I/O registers return the last value on the data bus.
+	ReadMemory(a uint16) uint8
+
+	// WriteMemory writes a byte to the CPU address space without advancing
+	// time. Writes to registers take effect as if the CPU made them, and an
+	// OAM DMA completes immediately.
+	WriteMemory(a uint16, v uint8) error
+
+	// ReadPPUMemory reads a byte from the PPU address space without side
+	// effects.
+	ReadPPUMemory(a uint16) uint8
+
+	// WritePPUMemory writes a byte to the PPU address space. CHR ROM is not
+	// writable.
+	WritePPUMemory(a uint16, v uint8) error
+
+	// CPUState returns the CPU registers and cycle count.
+	CPUState() CPUState
+
+	// Region returns the console the loaded game was released for.
+	Region() Region
+
+	// Timing returns the clock rates being emulated. Only NTSC timing is
+	// emulated, whatever the region of the game.
+	Timing() Timing
+
+	// SaveState writes a snapshot of the machine, which can only be loaded
+	// with the same ROM.
+	SaveState(w io.Writer) error
+
+	// LoadState restores a snapshot written by SaveState. The machine is left
+	// unchanged if the snapshot is invalid. The framebuffer is not part of
+	// the snapshot, and keeps the last frame drawn until the next one is
+	// complete; this is what lets run-ahead show a frame it rolls back.
+	LoadState(r io.Reader) error
+}
+
+// CPUState is a snapshot of the CPU registers.
+type CPUState struct {
+	PC             uint16
+	A, X, Y, P, SP uint8
+
+	// Cycles is the number of CPU cycles since power on.
+	Cycles uint64
+
+	// Halted is set once the CPU executes a KIL or STP opcode.
+	Halted bool
 }
 
 type nes struct {
-	cpu *cpu
-	ppu *ppu
+	cpu       *cpu
+	bus       *cpuBus
+	ppu       *ppu
+	cartridge cartridge
+	region    Region
+	screen    *frameBuffer
+
+	// identifies the ROM in save states
+	romHash [sha1.Size]uint8
+
+	// kept to power cycle the system
+	rom  []uint8
+	c1   Controller
+	c2   Controller
+	opts Options
+
+	tracer *tracer
 }
 
-// NewNES constructs a new NES
+// NewNES constructs a new NES with controller c1 plugged into port 1. The
+// drawer may be nil if frames are only read through Framebuffer.
 func NewNES(rom []uint8, drawer Drawer, c1 Controller) (NES, error) {
-	cartridge, err := createCartridge(rom)
-	if err != nil {
+	return NewNESWithControllers(rom, drawer, c1, nil, Options{})
+}
+
+// NewNESWithOptions constructs a new NES with non-default behavior.
+func NewNESWithOptions(rom []uint8, drawer Drawer, c1 Controller, opts Options) (NES, error) {
+	return NewNESWithControllers(rom, drawer, c1, nil, opts)
+}
+
+// NewNESWithControllers constructs a new NES with controllers c1 and c2
+// plugged into ports 1 and 2. c2 may be nil to leave port 2 unplugged.
+func NewNESWithControllers(rom []uint8, drawer Drawer, c1, c2 Controller, opts Options) (NES, error) {
+	n := &nes{
+		romHash: hashROM(rom),
+		rom:     rom,
+		screen:  newFrameBuffer(drawer),
+		c1:      c1,
+		c2:      c2,
+		opts:    opts,
+	}
+	if err := n.powerOn(); err != nil {
 		return nil, err
 	}
+	return n, nil
+}
+
+// powerOn creates every component of the system in its power-up state.
+func (n *nes) powerOn() error {
+	meminit := newMemoryInit(n.opts)
+	cartridge, region, err := createCartridge(n.rom, n.opts, meminit)
+	if err != nil {
+		return err
+	}
 
 	// hook up controllers
-	j1 := &joypad{controller: c1}
+	j1 := &joypad{controller: n.c1}
+	j2 := &joypad{controller: n.c2}
 
 	// create system buses
 	ppuBus := newPPUBus(cartridge)
-	ppu := newPPU(drawer, ppuBus)
+	ppu := newPPU(n.screen, ppuBus)
 
-	cpuBus := newCPUBus(ppu, cartridge, j1)
-	cpu, err := newCPU(cpuBus)
+	cpuBus := newCPUBus(ppu, cartridge, j1, j2)
+	cpu := newCPU(cpuBus, n.opts.CPU)
 	ppu.cpu = cpu
+	switch c := cartridge.(type) {
+	case *fds:
+		c.cpu = cpu
+	case *nsf:
+		c.cpu = cpu
+	}
 
-	if err != nil {
-		return nil, err
+	// NSF drivers expect cleared RAM
+	if _, ok := cartridge.(*nsf); !ok {
+		meminit.fill(cpuBus.wram[:])
+	}
+	meminit.fill(ppuBus.vram[:])
+	meminit.fill(ppu.oam[:])
+	if n.opts.RandomizeCPU {
+		meminit.randomizeCPU(cpu)
 	}
 
-	return &nes{
-		cpu: cpu,
-		ppu: ppu,
-	}, nil
+	// the CPU starts with a reset sequence, which loads the reset vector
+	if err := cpu.reset(); err != nil {
+		return err
+	}
+
+	cpu.tracer = n.tracer
+	n.cpu = cpu
+	n.bus = cpuBus
+	n.ppu = ppu
+	n.cartridge = cartridge
+	n.region = region
+	n.screen.frames = 0
+	return nil
 }
 
 // Step fetches and executes one instruction on the NES's CPU.
-// The PPU, MMU and all other peripherals will be updated accordingly.
+// The PPU, MMU and all other peripherals are clocked on each CPU bus access.
 func (n *nes) Step() error {
-	prevCycles := n.cpu.clock
-	err := n.cpu.step()
-	if err != nil {
+	return n.cpu.step()
+}
+
+func (n *nes) RunFrame() error {
+	frame := n.screen.frames
+	for n.screen.frames == frame {
+		if err := n.cpu.step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *nes) Framebuffer() *image.RGBA {
+	return n.screen.front
+}
+
+func (n *nes) FrameCount() int {
+	return n.screen.frames
+}
+
+func (n *nes) SetVideoEnabled(enabled bool) {
+	n.screen.disabled = !enabled
+}
+
+func (n *nes) DiskSides() int {
+	f, ok := n.cartridge.(*fds)
+	if !ok {
+		return 0
+	}
+	return len(f.sides)
+}
+
+func (n *nes) InsertDisk(side int) error {
+	f, ok := n.cartridge.(*fds)
+	if !ok {
+		return errors.New("no disk system is loaded")
+	}
+	return f.insertDisk(side)
+}
+
+func (n *nes) EjectDisk() {
+	if f, ok := n.cartridge.(*fds); ok {
+		f.ejectDisk()
+	}
+}
+
+func (n *nes) DiskImage() []uint8 {
+	f, ok := n.cartridge.(*fds)
+	if !ok {
+		return nil
+	}
+	return f.image()
+}
+
+func (n *nes) NSFInfo() *NSFInfo {
+	c, ok := n.cartridge.(*nsf)
+	if !ok {
+		return nil
+	}
+	info := c.info
+	return &info
+}
+
+func (n *nes) SelectTrack(track int) error {
+	c, ok := n.cartridge.(*nsf)
+	if !ok {
+		return errors.New("no nsf is loaded")
+	}
+	if err := c.selectTrack(track); err != nil {
 		return err
 	}
-	cycles := n.cpu.clock - prevCycles
 
-	err = n.ppu.step(cycles)
-	if err != nil {
+	// restart the driver with cleared RAM and registers
+	n.bus.wram = [wramMirror]uint8{}
+	n.cpu.pc = nsfDriverAddr
+	n.cpu.sp = spStartValue
+	n.cpu.p = statusStartValue
+	n.cpu.a, n.cpu.x, n.cpu.y = 0, 0, 0
+	n.cpu.nmi = false
+	return nil
+}
+
+func (n *nes) Reset() error {
+	if c, ok := n.cartridge.(*nsf); ok {
+		return n.SelectTrack(c.info.Track)
+	}
+	// the APU is not emulated, so there is nothing to silence
+	n.ppu.reset()
+	return n.cpu.reset()
+}
+
+func (n *nes) PowerCycle() error {
+	if f, ok := n.cartridge.(*fds); ok {
+		n.rom = f.image()
+	}
+	return n.powerOn()
+}
+
+func (n *nes) SetTrace(w io.Writer, low, high uint16) {
+	n.tracer = nil
+	if w != nil {
+		n.tracer = &tracer{w: w, low: low, high: high}
+	}
+	n.cpu.tracer = n.tracer
+}
+
+func (n *nes) ReadMemory(a uint16) uint8 {
+	return n.bus.peek(a)
+}
+
+func (n *nes) WriteMemory(a uint16, v uint8) error {
+	b := n.bus
+	if err := b.write(a, v); err != nil {
 		return err
 	}
+	if b.dmaPending {
+		b.dmaPending = false
+		// the copy reads through the bus like the CPU's DMA, so pages of
+		// registers are read with their side effects
+		page := uint16(b.dmaPage) << 8
+		for i := uint16(0); i < 0x100; i++ {
+			v, err := b.read(page | i)
+			if err != nil {
+				return err
+			}
+			if err := b.write(ppuOAMDataAddr, v); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
+
+func (n *nes) ReadPPUMemory(a uint16) uint8 {
+	v, err := n.ppu.bus.read(a)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func (n *nes) WritePPUMemory(a uint16, v uint8) error {
+	return n.ppu.bus.write(a, v)
+}
+
+func (n *nes) CPUState() CPUState {
+	return n.cpu.state()
+}
+
+func (n *nes) Region() Region {
+	return n.region
+}
+
+func (n *nes) Timing() Timing {
+	return ntscTiming
+}
@@ -0,0 +1,223 @@
+package system
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rhallman96/nesquack/internal/testrom"
+)
+
+func TestResetAndPowerCycle(t *testing.T) {
+	rom := testrom.NROM(testrom.Spin([]uint8{
+		0xa9, 0x04, 0x8d, 0x00, 0x20, // LDA #$04, STA $2000
+		0xa9, 0x42, 0x8d, 0x00, 0x03, // LDA #$42, STA $0300
+		0xa9, 0x43, 0x8d, 0x00, 0x60, // LDA #$43, STA $6000
+		0xa2, 0x11, 0xa0, 0x22, // LDX #$11, LDY #$22
+	}))
+	n, err := NewNESWithOptions(rom, nil, nopController{}, Options{RAM: RAMOnes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runFrames(t, n, 2)
+	before := n.CPUState()
+	if !n.(*nes).ppu.vramDownInc {
+		t.Fatal("PPUCTRL was not written")
+	}
+
+	if err := n.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	after := n.CPUState()
+	if after.A != before.A || after.X != 0x11 || after.Y != 0x22 {
+		t.Errorf("reset changed registers: %+v -> %+v", before, after)
+	}
+	if after.PC != testrom.Origin || after.SP != before.SP-3 || after.P&0x04 == 0 {
+		t.Errorf("reset sequence gave %+v", after)
+	}
+	if n.(*nes).ppu.vramDownInc {
+		t.Error("reset kept PPUCTRL")
+	}
+	if v := n.ReadMemory(0x0300); v != 0x42 {
+		t.Errorf("reset changed RAM to $%02x", v)
+	}
+	if v := n.ReadMemory(0x6000); v != 0x43 {
+		t.Errorf("reset changed PRG RAM to $%02x", v)
+	}
+
+	if err := n.PowerCycle(); err != nil {
+		t.Fatal(err)
+	}
+	if s := n.CPUState(); s.A != 0 || s.X != 0 || s.Y != 0 || s.SP != 0xfd || s.PC != testrom.Origin {
+		t.Errorf("power cycle gave %+v", s)
+	}
+	if n.(*nes).ppu.vramDownInc {
+		t.Error("power cycle kept PPUCTRL")
+	}
+	if v := n.ReadMemory(0x0300); v != 0xff {
+		t.Errorf("power cycle left $%02x in RAM", v)
+	}
+	if v := n.ReadMemory(0x6000); v != 0xff {
+		t.Errorf("power cycle left $%02x in PRG RAM", v)
+	}
+}
+
+func TestResetKeepsLatchDecay(t *testing.T) {
+	n, err := NewNES(testrom.NROM(testrom.Spin(nil)), nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := n.(*nes).ppu
+
+	runFrames(t, n, latchDecayFrames+5)
+	if err := n.WriteMemory(0x2002, 0xff); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if p.latch() != 0xff {
+		t.Fatal("reset cleared the I/O latch")
+	}
+	runFrames(t, n, latchDecayFrames+2)
+	if v := p.latch(); v != 0 {
+		t.Errorf("I/O latch is $%02x after reset, want it decayed to 0", v)
+	}
+}
+
+func TestResetKeepsDisk(t *testing.T) {
+	opts := Options{FDSBIOS: fdsTestBIOS(testrom.Spin(nil))}
+	n, err := NewNESWithOptions(fdsTestImage("side A", "side B"), nil, nopController{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.InsertDisk(1); err != nil {
+		t.Fatal(err)
+	}
+	runFrames(t, n, 70)
+
+	// write to the disk as the drive would
+	f := n.(*nes).cartridge.(*fds)
+	i := bytes.Index(f.sides[1], []uint8("side B"))
+	f.sides[1][i] = 'S'
+	written := n.DiskImage()
+
+	if err := n.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if n.(*nes).cartridge != f || f.side != 1 {
+		t.Error("reset changed the inserted disk")
+	}
+	if !bytes.Equal(n.DiskImage(), written) {
+		t.Error("reset lost disk writes")
+	}
+
+	if err := n.PowerCycle(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(n.DiskImage(), written) {
+		t.Error("power cycle lost disk writes")
+	}
+	if !bytes.Contains(n.DiskImage(), []uint8("Side B")) {
+		t.Error("disk image does not hold the write")
+	}
+}
+
+func TestRunFrame(t *testing.T) {
+	n, err := NewNES(testrom.NROM(testrom.Spin(nil)), nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last uint64
+	for i := 1; i <= 4; i++ {
+		if err := n.RunFrame(); err != nil {
+			t.Fatal(err)
+		}
+		if f := n.FrameCount(); f != i {
+			t.Fatalf("frame count is %d after %d frames", f, i)
+		}
+		// with rendering off, every frame is 341 * 262 dots long
+		cycles := n.CPUState().Cycles
+		if d := cycles - last; i > 1 && (d < 29780 || d > 29781) {
+			t.Errorf("frame %d took %d cycles", i, d)
+		}
+		last = cycles
+	}
+}
+
+func TestMemoryRoundTrip(t *testing.T) {
+	n, err := NewNES(testrom.NROM(testrom.Spin(nil)), nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// RAM is mirrored every 2 KB, and PRG RAM is mapped at $6000
+	for _, m := range []struct{ write, read uint16 }{{0x0012, 0x0812}, {0x1fff, 0x07ff}, {0x6000, 0x6000}} {
+		if err := n.WriteMemory(m.write, 0x5a); err != nil {
+			t.Fatal(err)
+		}
+		if v := n.ReadMemory(m.read); v != 0x5a {
+			t.Errorf("wrote $5a to $%04x, read $%02x from $%04x", m.write, v, m.read)
+		}
+	}
+
+	// register writes take effect, but register reads have no side effects
+	for _, w := range []struct {
+		a uint16
+		v uint8
+	}{{0x2006, 0x21}, {0x2006, 0x00}, {0x2007, 0x77}} {
+		if err := n.WriteMemory(w.a, w.v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := n.ReadPPUMemory(0x2100); v != 0x77 {
+		t.Errorf("PPUDATA write left $%02x in VRAM", v)
+	}
+	p := n.(*nes).ppu
+	p.vBlankPeriod = true
+	n.ReadMemory(0x2002)
+	if !p.vBlankPeriod {
+		t.Error("reading PPUSTATUS cleared the vblank flag")
+	}
+
+	if err := n.WritePPUMemory(0x3f01, 0x2a); err != nil {
+		t.Fatal(err)
+	}
+	if v := n.ReadPPUMemory(0x3f01); v != 0x2a {
+		t.Errorf("palette holds $%02x", v)
+	}
+}
+
+func TestWriteMemoryDMA(t *testing.T) {
+	n, err := NewNES(testrom.NROM(testrom.Spin(nil)), nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint16(0); i < 0x100; i++ {
+		if err := n.WriteMemory(0x0300|i, uint8(i)^0xa5); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := n.CPUState()
+	if err := n.WriteMemory(0x4014, 0x03); err != nil {
+		t.Fatal(err)
+	}
+	p := n.(*nes).ppu
+	for i := 0; i < 0x100; i++ {
+		if p.oam[i] != uint8(i)^0xa5 {
+			t.Fatalf("OAM $%02x holds $%02x", i, p.oam[i])
+		}
+	}
+	if after := n.CPUState(); after != before {
+		t.Errorf("DMA advanced the CPU from %+v to %+v", before, after)
+	}
+
+	// a page of registers is read with side effects: the first read of
+	// PPUSTATUS returns and clears the vblank flag
+	p.vBlankPeriod = true
+	if err := n.WriteMemory(0x4014, 0x20); err != nil {
+		t.Fatal(err)
+	}
+	if p.vBlankPeriod || p.oam[0x02]&0x80 == 0 || p.oam[0x0a]&0x80 != 0 {
+		t.Errorf("DMA from PPU registers copied $%02x and $%02x from PPUSTATUS", p.oam[0x02], p.oam[0x0a])
+	}
+}
@@ -0,0 +1,88 @@
+package system
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// nestest.nes and nestest.log are available from
+// https://www.qmtpro.com/~nes/misc/ and are placed in testdata to run the
+// test; it is skipped without them.
+const (
+	nestestROM = "testdata/nestest.nes"
+	nestestLog = "testdata/nestest.log"
+
+	// nestest runs without a PPU in automation mode from this address
+	nestestAutomationAddr = 0xc000
+)
+
+// TestNestest runs nestest and compares each instruction against the golden
+// log from nesdev. The disassembly is not compared, as it shows the values of
+// I/O registers that differ between emulators.
+func TestNestest(t *testing.T) {
+	rom, err := ioutil.ReadFile(nestestROM)
+	if os.IsNotExist(err) {
+		t.Skipf("%s is missing", nestestROM)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := ioutil.ReadFile(nestestLog)
+	if os.IsNotExist(err) {
+		t.Skipf("%s is missing", nestestLog)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := NewNES(rom, nopDrawer{}, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := n.(*nes).cpu
+	cpu.pc = nestestAutomationAddr
+
+	var trace bytes.Buffer
+	n.SetTrace(&trace, 0, 0xffff)
+
+	lines := bufio.NewScanner(bytes.NewReader(golden))
+	for i := 1; lines.Scan(); i++ {
+		want := strings.TrimRight(lines.Text(), "\r")
+		if want == "" {
+			continue
+		}
+
+		trace.Reset()
+		state := cpu.String()
+		if err := n.Step(); err != nil {
+			t.Fatalf("line %d: %s\nstate: %s", i, err, state)
+		}
+		got := strings.TrimRight(trace.String(), "\n")
+
+		if nestestFields(got) != nestestFields(want) {
+			t.Fatalf("line %d diverges\nwant: %s\ngot:  %s\nstate: %s", i, want, got, state)
+		}
+	}
+
+	// nestest stores its result codes at $02 and $03
+	if r := n.(*nes).bus.wram[2:4]; r[0] != 0 || r[1] != 0 {
+		t.Errorf("nestest reported failure codes %02x %02x", r[0], r[1])
+	}
+}
+
+// nestestFields returns the compared parts of a trace line: the program
+// counter, instruction bytes, registers, PPU position and cycle count.
+func nestestFields(line string) string {
+	if len(line) < 16 {
+		return line
+	}
+	regs := strings.Index(line, "A:")
+	if regs < 0 {
+		return line
+	}
+	return strings.TrimSpace(line[:15]) + " " + line[regs:]
+}
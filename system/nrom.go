@@ -26,8 +26,7 @@ func (c *nrom) read(a uint16) (uint8, error) {
 		i := mirrorIndex(a, prgROMLowAddr, uint16(len(c.prgROM)))
 		return c.prgROM[i], nil
 	default:
-		const oobRead = "oob nrom read at 0x%x"
-		return 0, errors.New(fmt.Sprintf(oobRead, a))
+		return 0, errOpenBus
 	}
 }
 
@@ -65,3 +64,8 @@ func (c *nrom) vramMirror() mirrorMode {
 func (c *nrom) incScanline(cp *cpu) error {
 	return nil
 }
+
+func (c *nrom) serialize(s *stateCodec) {
+	s.bytes(c.prgRAM)
+	s.bytes(c.chr)
+}
@@ -0,0 +1,494 @@
+package system
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+)
+
+var (
+	nsfPrefix  = []uint8{0x4e, 0x45, 0x53, 0x4d, 0x1a} // "NESM\x1a"
+	nsfePrefix = []uint8{0x4e, 0x53, 0x46, 0x45}       // "NSFE"
+)
+
+const (
+	nsfHeaderSize      = 0x80
+	nsfBankSize        = 0x1000
+	nsfBankCount       = 8
+	nsfStringSize      = 32
+	nsfDefaultSpeed    = 16666 // microseconds between PLAY calls (60 Hz)
+	nsfDefaultPALSpeed = 20000 // 50 Hz
+	cpuClockRate       = 1789773
+	nsfMicroseconds    = 1000000
+	nsfFDSRAMHighAdr   = 0xdfff
+
+	// bank switching registers
+	nsfFDSBankAddr  = 0x5ff6
+	nsfBankLowAddr  = 0x5ff8
+	nsfBankHighAddr = 0x5fff
+
+	// the player driver is mapped into unused cartridge space
+	nsfDriverAddr   = 0x5f00
+	nsfIdleAddr     = 0x5f0a
+	nsfNMIAddr      = 0x5f0d
+	nsfInitDoneAddr = 0x5f20
+	nsfPlayDoneAddr = 0x5f21
+	nsfDriverSize   = 0x14
+
+	// expansion sound chip flags
+	nsfChipVRC6    = 1 << 0
+	nsfChipVRC7    = 1 << 1
+	nsfChipFDS     = 1 << 2
+	nsfChipMMC5    = 1 << 3
+	nsfChipN163    = 1 << 4
+	nsfChipSunsoft = 1 << 5
+)
+
+var nsfChipNames = map[uint8]string{
+	nsfChipVRC6:    "VRC6",
+	nsfChipVRC7:    "VRC7",
+	nsfChipFDS:     "FDS",
+	nsfChipMMC5:    "MMC5",
+	nsfChipN163:    "Namco 163",
+	nsfChipSunsoft: "Sunsoft 5B",
+}
+
+// NSFInfo describes an NSF music file.
+type NSFInfo struct {
+	Title     string
+	Artist    string
+	Copyright string
+
+	// Tracks is the number of tracks, and Track is the current track,
+	// numbered from 0.
+	Tracks int
+	Track  int
+
+	// TrackTitles holds the title of each track, if the file provides them.
+	TrackTitles []string
+}
+
+// nsf is a synthetic cartridge that plays NSF and NSFe music files. A small
+// driver program calls the tune's INIT routine, then idles while an NMI is
+// raised at the tune's play rate to call its PLAY routine.
+// CPU banks
+// 0x5f00 - 0x5f21: player driver
+// 0x5ff6 - 0x5fff: bank switching registers
+// 0x6000 - 0x7fff: PRG RAM (switchable for FDS tunes)
+// 0x8000 - 0xffff: switchable 4 KB banks of tune data
+type nsf struct {
+	cpu *cpu
+
+	info       NSFInfo
+	data       []uint8
+	loadAddr   uint16
+	initAddr   uint16
+	playAddr   uint16
+	initBanks  [nsfBankCount]uint8
+	bankSwitch bool
+	chips      uint8
+	region     Region
+
+	// initial banks for 0x6000 - 0x7fff of FDS tunes
+	ramBanks [2]uint8
+
+	// banks for 0x6000 - 0xffff, in 4 KB pages, and the bank numbers last
+	// written for each
+	pages  [10][]uint8
+	banks  [10]uint8
+	prgRAM []uint8
+	chr    [chrBankSize]uint8
+
+	driver [nsfDriverSize]uint8
+
+	playPeriod uint64
+	playTimer  uint64
+	initDone   bool
+	playing    bool
+
+	fdsAudio *fdsAudio
+}
+
+// IsNSF indicates if a ROM is an NSF or NSFe music file.
+func IsNSF(rom []uint8) bool {
+	return (len(rom) >= len(nsfPrefix) && reflect.DeepEqual(rom[:5], nsfPrefix)) ||
+		(len(rom) >= len(nsfePrefix) && reflect.DeepEqual(rom[:4], nsfePrefix))
+}
+
+func newNSF(rom []uint8) (*nsf, error) {
+	c := &nsf{}
+
+	var err error
+	var speed, palSpeed uint16
+	if reflect.DeepEqual(rom[:4], nsfePrefix) {
+		speed, palSpeed, err = c.parseNSFe(rom)
+	} else {
+		speed, palSpeed, err = c.parseNSF(rom)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.info.Tracks == 0 {
+		return nil, errors.New("nsf has no tracks")
+	}
+	if c.info.Track >= c.info.Tracks {
+		c.info.Track = 0
+	}
+
+	// PAL tunes are played at their own rate, although the CPU runs at the
+	// NTSC clock rate
+	if c.region == RegionPAL {
+		speed = palSpeed
+		if speed == 0 {
+			speed = nsfDefaultPALSpeed
+		}
+	} else if speed == 0 {
+		speed = nsfDefaultSpeed
+	}
+	c.playPeriod = uint64(speed) * cpuClockRate / nsfMicroseconds
+
+	// lay out tune data in 4 KB banks
+	for _, b := range c.initBanks {
+		if b != 0 {
+			c.bankSwitch = true
+		}
+	}
+	padding := int(c.loadAddr & 0xfff)
+	if c.bankSwitch {
+		// FDS tunes take the banks for 0x6000 - 0x7fff from those for
+		// 0xe000 - 0xffff
+		c.ramBanks = [2]uint8{c.initBanks[6], c.initBanks[7]}
+	} else {
+		// tunes without bank switching are loaded in place, and FDS tunes
+		// may also load into RAM from 0x6000
+		base := prgROMLowAddr
+		if c.chips&nsfChipFDS != 0 {
+			base = prgRAMLowAddr
+		}
+		padding = int(c.loadAddr) - base
+		if padding < 0 {
+			return nil, errors.New(fmt.Sprintf("nsf load address 0x%x is below 0x%x", c.loadAddr, base))
+		}
+		first := (prgROMLowAddr - base) / nsfBankSize
+		for i := range c.initBanks {
+			c.initBanks[i] = uint8(first + i)
+		}
+		c.ramBanks = [2]uint8{0, 1}
+	}
+	c.data = append(make([]uint8, padding), c.data...)
+	if rem := len(c.data) % nsfBankSize; rem != 0 {
+		c.data = append(c.data, make([]uint8, nsfBankSize-rem)...)
+	}
+
+	if c.chips&nsfChipFDS != 0 {
+		c.prgRAM = make([]uint8, nsfFDSRAMHighAdr-prgRAMLowAddr+1)
+		c.fdsAudio = &fdsAudio{}
+	} else {
+		c.prgRAM = make([]uint8, prgRAMBankSize)
+	}
+	for chip, name := range nsfChipNames {
+		if c.chips&chip != 0 {
+			log.Printf("NSF expansion audio %s is not supported", name)
+		}
+	}
+	log.Printf("NSF audio is not emulated, so the tune plays silently")
+
+	log.Printf("NSF: %s - %s (%d tracks)", c.info.Title, c.info.Artist, c.info.Tracks)
+
+	c.reset()
+	return c, nil
+}
+
+// parseNSF reads an NSF header and returns the NTSC and PAL play speeds.
+func (c *nsf) parseNSF(rom []uint8) (uint16, uint16, error) {
+	if len(rom) < nsfHeaderSize {
+		return 0, 0, errors.New("nsf header is truncated")
+	}
+
+	c.info.Tracks = int(rom[0x06])
+	c.info.Track = int(rom[0x07]) - 1
+	c.loadAddr = binary.LittleEndian.Uint16(rom[0x08:])
+	c.initAddr = binary.LittleEndian.Uint16(rom[0x0a:])
+	c.playAddr = binary.LittleEndian.Uint16(rom[0x0c:])
+	c.info.Title = nsfString(rom[0x0e : 0x0e+nsfStringSize])
+	c.info.Artist = nsfString(rom[0x2e : 0x2e+nsfStringSize])
+	c.info.Copyright = nsfString(rom[0x4e : 0x4e+nsfStringSize])
+	copy(c.initBanks[:], rom[0x70:0x78])
+	c.region = nsfRegion(rom[0x7a])
+	c.chips = rom[0x7b]
+	c.data = rom[nsfHeaderSize:]
+
+	if c.info.Track < 0 {
+		c.info.Track = 0
+	}
+	return binary.LittleEndian.Uint16(rom[0x6e:]), binary.LittleEndian.Uint16(rom[0x78:]), nil
+}
+
+// parseNSFe reads the chunks of an NSFe file and returns the NTSC and PAL
+// play speeds.
+func (c *nsf) parseNSFe(rom []uint8) (uint16, uint16, error) {
+	var speed, palSpeed uint16
+	hasInfo := false
+
+	for i := len(nsfePrefix); i+8 <= len(rom); {
+		size := int(binary.LittleEndian.Uint32(rom[i:]))
+		id := string(rom[i+4 : i+8])
+		i += 8
+		if size < 0 || i+size > len(rom) {
+			return 0, 0, errors.New(fmt.Sprintf("nsfe chunk %s is truncated", id))
+		}
+		data := rom[i : i+size]
+		i += size
+
+		switch id {
+		case "INFO":
+			if size < 9 {
+				return 0, 0, errors.New("nsfe INFO chunk is truncated")
+			}
+			hasInfo = true
+			c.loadAddr = binary.LittleEndian.Uint16(data[0:])
+			c.initAddr = binary.LittleEndian.Uint16(data[2:])
+			c.playAddr = binary.LittleEndian.Uint16(data[4:])
+			c.region = nsfRegion(data[6])
+			c.chips = data[7]
+			c.info.Tracks = int(data[8])
+			if size > 9 {
+				c.info.Track = int(data[9])
+			}
+		case "DATA":
+			c.data = data
+		case "BANK":
+			copy(c.initBanks[:], data)
+		case "RATE":
+			if size >= 2 {
+				speed = binary.LittleEndian.Uint16(data)
+			}
+			if size >= 4 {
+				palSpeed = binary.LittleEndian.Uint16(data[2:])
+			}
+		case "auth":
+			fields := strings.Split(string(data), "\x00")
+			for n, f := range fields {
+				switch n {
+				case 0:
+					c.info.Title = f
+				case 1:
+					c.info.Artist = f
+				case 2:
+					c.info.Copyright = f
+				}
+			}
+		case "tlbl":
+			c.info.TrackTitles = strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+		case "NEND":
+			i = len(rom)
+		default:
+			// chunks starting with an uppercase letter must be understood
+			if id[0] >= 'A' && id[0] <= 'Z' {
+				return 0, 0, errors.New(fmt.Sprintf("unsupported nsfe chunk %s", id))
+			}
+		}
+	}
+
+	if !hasInfo {
+		return 0, 0, errors.New("nsfe has no INFO chunk")
+	}
+	return speed, palSpeed, nil
+}
+
+// nsfRegion decodes the region flags of NSF and NSFe files.
+func nsfRegion(flags uint8) Region {
+	switch {
+	case flags&0x2 != 0:
+		return RegionMulti
+	case flags&0x1 != 0:
+		return RegionPAL
+	}
+	return RegionNTSC
+}
+
+func nsfString(b []uint8) string {
+	return strings.TrimRight(string(b), "\x00")
+}
+
+// reset restores the initial banks and RAM, and rebuilds the driver to call
+// INIT with the current track.
+func (c *nsf) reset() {
+	for i := range c.prgRAM {
+		c.prgRAM[i] = 0
+	}
+	for i := 0; i < 2; i++ {
+		c.mapPage(i)
+	}
+	if c.fdsAudio != nil {
+		// FDS tunes may also switch and write the 0x6000 - 0x7fff banks
+		c.writeBank(nsfFDSBankAddr, c.ramBanks[0])
+		c.writeBank(nsfFDSBankAddr+1, c.ramBanks[1])
+		*c.fdsAudio = fdsAudio{}
+	}
+	for i, b := range c.initBanks {
+		c.writeBank(nsfBankLowAddr+uint16(i), b)
+	}
+
+	// dual region tunes are told they run on NTSC, as the CPU does
+	var region uint8
+	if c.region == RegionPAL {
+		region = 1
+	}
+	c.driver = [nsfDriverSize]uint8{
+		0xa9, uint8(c.info.Track), // LDA #track
+		0xa2, region, // LDX #region
+		0x20, uint8(c.initAddr), uint8(c.initAddr >> 8), // JSR init
+		0x8d, nsfInitDoneAddr & 0xff, nsfInitDoneAddr >> 8, // STA initDone
+		0x4c, nsfIdleAddr & 0xff, nsfIdleAddr >> 8, // idle: JMP idle
+		0x20, uint8(c.playAddr), uint8(c.playAddr >> 8), // nmi: JSR play
+		0x8d, nsfPlayDoneAddr & 0xff, nsfPlayDoneAddr >> 8, // STA playDone
+		0x40, // RTI
+	}
+
+	c.initDone = false
+	c.playing = false
+	c.playTimer = 0
+}
+
+// writeBank maps a 4 KB bank of tune data. FDS tunes run from RAM, so their
+// banks are copied rather than mapped.
+func (c *nsf) writeBank(a uint16, v uint8) {
+	page := int(a - nsfFDSBankAddr)
+	c.banks[page] = v
+	bank := c.bank(v)
+
+	if c.ramPage(page) {
+		copy(c.prgRAM[page*nsfBankSize:], bank)
+	}
+	c.mapPage(page)
+}
+
+// bank returns a 4 KB bank of tune data, or zeros past its end.
+func (c *nsf) bank(v uint8) []uint8 {
+	start := int(v) * nsfBankSize
+	if start+nsfBankSize <= len(c.data) {
+		return c.data[start : start+nsfBankSize]
+	}
+	return make([]uint8, nsfBankSize)
+}
+
+// ramPage indicates if a page is backed by RAM. Pages 0 and 1 cover 0x6000 -
+// 0x7fff, and for FDS tunes pages 2 - 7 continue up to 0xdfff.
+func (c *nsf) ramPage(page int) bool {
+	return page < 2 || (c.fdsAudio != nil && page < 8)
+}
+
+func (c *nsf) mapPage(page int) {
+	if c.ramPage(page) {
+		c.pages[page] = c.prgRAM[page*nsfBankSize : (page+1)*nsfBankSize]
+		return
+	}
+	c.pages[page] = c.bank(c.banks[page])
+}
+
+func (c *nsf) read(a uint16) (uint8, error) {
+	switch {
+	case a == nmiVector:
+		return nsfNMIAddr & 0xff, nil
+	case a == nmiVector+1:
+		return nsfNMIAddr >> 8, nil
+	case a == resetVector:
+		return nsfDriverAddr & 0xff, nil
+	case a == resetVector+1:
+		return nsfDriverAddr >> 8, nil
+	case a >= prgRAMLowAddr:
+		return c.pages[(a-prgRAMLowAddr)/nsfBankSize][a%nsfBankSize], nil
+	case a >= nsfDriverAddr && a < nsfDriverAddr+uint16(len(c.driver)):
+		return c.driver[a-nsfDriverAddr], nil
+	case c.fdsAudio != nil && a >= fdsAudioLowAddr && a <= fdsAudioHighAddr:
+		return c.fdsAudio.read(a), nil
+	}
+	return 0, errOpenBus
+}
+
+func (c *nsf) write(a uint16, v uint8) error {
+	switch {
+	case a == nsfInitDoneAddr:
+		c.initDone = true
+	case a == nsfPlayDoneAddr:
+		c.playing = false
+	case a >= nsfFDSBankAddr && a <= nsfBankHighAddr:
+		if a >= nsfBankLowAddr || c.fdsAudio != nil {
+			c.writeBank(a, v)
+		}
+	case a >= prgRAMLowAddr && a <= prgRAMHighAddr:
+		c.pages[(a-prgRAMLowAddr)/nsfBankSize][a%nsfBankSize] = v
+	case c.fdsAudio != nil && a >= prgROMLowAddr && a <= nsfFDSRAMHighAdr:
+		c.pages[(a-prgRAMLowAddr)/nsfBankSize][a%nsfBankSize] = v
+	case c.fdsAudio != nil && a >= fdsAudioLowAddr && a <= fdsAudioHighAddr:
+		c.fdsAudio.write(a, v)
+	}
+	return nil
+}
+
+func (c *nsf) readCHR(a uint16) (uint8, error) {
+	return c.chr[a], nil
+}
+
+func (c *nsf) writeCHR(a uint16, v uint8) error {
+	c.chr[a] = v
+	return nil
+}
+
+func (c *nsf) vramMirror() mirrorMode {
+	return horizontal
+}
+
+func (c *nsf) incScanline(cp *cpu) error {
+	return nil
+}
+
+// step raises an NMI to call PLAY at the tune's play rate, once INIT has
+// returned and the previous call to PLAY has finished.
+func (c *nsf) step(cpuCycles uint64) error {
+	if c.fdsAudio != nil {
+		for i := uint64(0); i < cpuCycles; i++ {
+			c.fdsAudio.step()
+		}
+	}
+
+	c.playTimer += cpuCycles
+	if c.playTimer < c.playPeriod {
+		return nil
+	}
+	c.playTimer -= c.playPeriod
+	if c.initDone && !c.playing {
+		c.playing = true
+		c.cpu.triggerNMI()
+	}
+	return nil
+}
+
+// selectTrack restarts playback at a track. The CPU must be reset to the
+// driver by the caller.
+func (c *nsf) selectTrack(track int) error {
+	if track < 0 || track >= c.info.Tracks {
+		return errors.New(fmt.Sprintf("nsf track %d does not exist", track))
+	}
+	c.info.Track = track
+	c.reset()
+	return nil
+}
+
+func (c *nsf) serialize(s *stateCodec) {
+	s.bytes(c.prgRAM)
+	s.fixed(&c.chr, &c.banks, &c.driver, &c.playPeriod, &c.playTimer, &c.initDone, &c.playing)
+	s.ints(&c.info.Track)
+	if c.fdsAudio != nil {
+		c.fdsAudio.serialize(s)
+	}
+	if s.loading() {
+		for i := range c.pages {
+			c.mapPage(i)
+		}
+	}
+}
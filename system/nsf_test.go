@@ -0,0 +1,292 @@
+package system
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// nsfHeader describes the fields of a test NSF file.
+type nsfHeader struct {
+	load, init, play uint16
+	tracks, start    uint8
+	banks            [nsfBankCount]uint8
+	region, chips    uint8
+	ntsc, pal        uint16
+}
+
+// buildNSF returns an NSF file holding data.
+func buildNSF(h nsfHeader, data []uint8) []uint8 {
+	rom := make([]uint8, nsfHeaderSize)
+	copy(rom, nsfPrefix)
+	rom[0x05] = 1
+	rom[0x06] = h.tracks
+	rom[0x07] = h.start
+	binary.LittleEndian.PutUint16(rom[0x08:], h.load)
+	binary.LittleEndian.PutUint16(rom[0x0a:], h.init)
+	binary.LittleEndian.PutUint16(rom[0x0c:], h.play)
+	copy(rom[0x0e:], "Title")
+	copy(rom[0x2e:], "Artist")
+	copy(rom[0x4e:], "2024 Copyright")
+	binary.LittleEndian.PutUint16(rom[0x6e:], h.ntsc)
+	copy(rom[0x70:], h.banks[:])
+	binary.LittleEndian.PutUint16(rom[0x78:], h.pal)
+	rom[0x7a] = h.region
+	rom[0x7b] = h.chips
+	return append(rom, data...)
+}
+
+// nsfBanks returns count 4 KB banks, each filled with its number.
+func nsfBanks(count int) []uint8 {
+	var r []uint8
+	for i := 0; i < count; i++ {
+		for j := 0; j < nsfBankSize; j++ {
+			r = append(r, uint8(i))
+		}
+	}
+	return r
+}
+
+func TestNSFHeader(t *testing.T) {
+	h := nsfHeader{load: 0x8000, init: 0x8000, play: 0x8000, tracks: 5, start: 3, ntsc: 8000, pal: 25000}
+	c, err := newNSF(buildNSF(h, []uint8{0x60}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.info.Title != "Title" || c.info.Artist != "Artist" || c.info.Copyright != "2024 Copyright" {
+		t.Errorf("strings read as %+v", c.info)
+	}
+	if c.info.Tracks != 5 || c.info.Track != 2 {
+		t.Errorf("%d tracks starting at %d, want 5 starting at 2", c.info.Tracks, c.info.Track)
+	}
+
+	tests := []struct {
+		region uint8
+		want   Region
+		period uint64
+	}{
+		{0, RegionNTSC, 8000 * cpuClockRate / nsfMicroseconds},
+		{1, RegionPAL, 25000 * cpuClockRate / nsfMicroseconds},
+		{2, RegionMulti, 8000 * cpuClockRate / nsfMicroseconds},
+		{3, RegionMulti, 8000 * cpuClockRate / nsfMicroseconds},
+	}
+	for _, tt := range tests {
+		h.region = tt.region
+		c, err := newNSF(buildNSF(h, []uint8{0x60}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.region != tt.want || c.playPeriod != tt.period {
+			t.Errorf("region flags %d gave %s with a %d cycle period, want %s and %d",
+				tt.region, c.region, c.playPeriod, tt.want, tt.period)
+		}
+	}
+
+	// missing speeds fall back to 60 and 50 Hz
+	h.ntsc, h.pal = 0, 0
+	for region, period := range map[uint8]uint64{0: nsfDefaultSpeed, 1: nsfDefaultPALSpeed} {
+		h.region = region
+		c, err := newNSF(buildNSF(h, []uint8{0x60}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := period * cpuClockRate / nsfMicroseconds; c.playPeriod != want {
+			t.Errorf("default period for region flags %d is %d, want %d", region, c.playPeriod, want)
+		}
+	}
+
+	h.tracks = 0
+	if _, err := newNSF(buildNSF(h, nil)); err == nil {
+		t.Error("nsf without tracks loaded")
+	}
+	if _, err := newNSF(buildNSF(h, nil)[:nsfHeaderSize-1]); err == nil {
+		t.Error("truncated header loaded")
+	}
+}
+
+// nsfeChunk returns an NSFe chunk.
+func nsfeChunk(id string, data []uint8) []uint8 {
+	r := make([]uint8, 8, 8+len(data))
+	binary.LittleEndian.PutUint32(r, uint32(len(data)))
+	copy(r[4:], id)
+	return append(r, data...)
+}
+
+func TestNSFeHeader(t *testing.T) {
+	info := []uint8{0x00, 0x80, 0x00, 0x80, 0x00, 0x80, 0x01, 0x00, 3, 1}
+	rate := []uint8{0x40, 0x1f, 0xa8, 0x61}
+	rom := append([]uint8{}, nsfePrefix...)
+	rom = append(rom, nsfeChunk("INFO", info)...)
+	rom = append(rom, nsfeChunk("DATA", []uint8{0x60})...)
+	rom = append(rom, nsfeChunk("RATE", rate)...)
+	rom = append(rom, nsfeChunk("auth", []uint8("Title\x00Artist\x00Copyright\x00Ripper"))...)
+	rom = append(rom, nsfeChunk("tlbl", []uint8("One\x00Two\x00Three\x00"))...)
+	rom = append(rom, nsfeChunk("NEND", nil)...)
+
+	c, err := newNSF(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.info.Title != "Title" || c.info.Artist != "Artist" || c.info.Copyright != "Copyright" {
+		t.Errorf("auth chunk read as %+v", c.info)
+	}
+	if c.info.Tracks != 3 || c.info.Track != 1 || len(c.info.TrackTitles) != 3 || c.info.TrackTitles[2] != "Three" {
+		t.Errorf("tracks read as %+v", c.info)
+	}
+	if want := uint64(25000) * cpuClockRate / nsfMicroseconds; c.region != RegionPAL || c.playPeriod != want {
+		t.Errorf("PAL tune has region %s and a %d cycle period, want %d", c.region, c.playPeriod, want)
+	}
+
+	unknown := append(append([]uint8{}, rom[:len(rom)-8]...), nsfeChunk("BLAH", nil)...)
+	if _, err := newNSF(unknown); err == nil {
+		t.Error("nsfe with an unknown required chunk loaded")
+	}
+}
+
+func TestNSFBanks(t *testing.T) {
+	read := func(c *nsf, a uint16) uint8 {
+		v, err := c.read(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	// bank switched: each 4 KB page starts with its initial bank
+	h := nsfHeader{load: 0x8100, init: 0x8000, play: 0x8000, tracks: 1,
+		banks: [nsfBankCount]uint8{2, 1, 0, 3, 3, 3, 3, 3}}
+	c, err := newNSF(buildNSF(h, nsfBanks(4)[0x100:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range h.banks {
+		if v := read(c, prgROMLowAddr+uint16(i)*nsfBankSize+0x800); v != b {
+			t.Errorf("page %d holds bank %d, want %d", i, v, b)
+		}
+	}
+	// the data starts at the load address within its bank
+	if v := read(c, 0xa0ff); v != 0 {
+		t.Errorf("padding before the load address is $%02x", v)
+	}
+	c.write(nsfBankLowAddr+1, 2)
+	if v := read(c, 0x9000); v != 2 {
+		t.Errorf("bank switch mapped bank %d", v)
+	}
+	c.reset()
+	if v := read(c, 0x9000); v != 1 {
+		t.Errorf("reset left bank %d mapped", v)
+	}
+
+	// not bank switched: the data is loaded in place
+	h = nsfHeader{load: 0x9000, init: 0x9000, play: 0x9000, tracks: 1}
+	c, err = newNSF(buildNSF(h, nsfBanks(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read(c, 0x8000) != 0 || read(c, 0x9000) != 0 || read(c, 0xa000) != 1 || read(c, 0xb000) != 2 {
+		t.Error("data not loaded at $9000")
+	}
+	h.load = 0x7000
+	if _, err := newNSF(buildNSF(h, nsfBanks(1))); err == nil {
+		t.Error("tune loaded below $8000")
+	}
+
+	// FDS tunes may load into RAM from $6000
+	h = nsfHeader{load: 0x7000, init: 0x7000, play: 0x7000, tracks: 1, chips: nsfChipFDS}
+	c, err = newNSF(buildNSF(h, nsfBanks(3)[:2*nsfBankSize+1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read(c, 0x6000) != 0 || read(c, 0x7000) != 0 || read(c, 0x8000) != 1 || read(c, 0x9000) != 2 {
+		t.Error("FDS data not loaded at $7000")
+	}
+	c.write(0x8000, 0x55)
+	if read(c, 0x8000) != 0x55 {
+		t.Error("FDS tune RAM not writable")
+	}
+
+	// bank switched FDS tunes start $6000 - $7fff with the banks of
+	// $e000 - $ffff
+	h = nsfHeader{load: 0x8000, init: 0x8000, play: 0x8000, tracks: 1, chips: nsfChipFDS,
+		banks: [nsfBankCount]uint8{0, 0, 0, 0, 0, 0, 2, 1}}
+	c, err = newNSF(buildNSF(h, nsfBanks(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read(c, 0x6000) != 2 || read(c, 0x7000) != 1 {
+		t.Error("FDS RAM banks not initialized from $e000 - $ffff banks")
+	}
+}
+
+// nsfDriverTune is loaded at $8000. INIT saves A and X in $00 and $01, waits
+// more than a frame, and sets $03. PLAY copies $03 to $04 and counts its
+// calls in $02.
+var nsfDriverTune = []uint8{
+	0x85, 0x00, // STA $00
+	0x86, 0x01, // STX $01
+	0xa9, 0x20, 0x85, 0x05, // LDA #$20, STA $05
+	0xa0, 0x00, // outer: LDY #0
+	0x88,       // inner: DEY
+	0xd0, 0xfd, // BNE inner
+	0xc6, 0x05, // DEC $05
+	0xd0, 0xf7, // BNE outer
+	0xa9, 0x01, 0x85, 0x03, // LDA #1, STA $03
+	0x60,                   // RTS
+	0xa5, 0x03, 0x85, 0x04, // play: LDA $03, STA $04
+	0xe6, 0x02, // INC $02
+	0x60, // RTS
+}
+
+func TestNSFDriverTiming(t *testing.T) {
+	for _, region := range []uint8{0, 1} {
+		h := nsfHeader{load: 0x8000, init: 0x8000, play: 0x8016, tracks: 4, start: 2,
+			region: region, ntsc: 16666, pal: 20000}
+		n, err := NewNES(buildNSF(h, nsfDriverTune), nil, nopController{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := n.(*nes).cartridge.(*nsf)
+
+		// run until INIT has returned
+		for n.ReadMemory(0x03) == 0 {
+			if err := n.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if a, x := n.ReadMemory(0x00), n.ReadMemory(0x01); a != 1 || x != region {
+			t.Errorf("INIT called with A=%d X=%d, want track 1 and region %d", a, x, region)
+		}
+		if n.ReadMemory(0x02) != 0 {
+			t.Errorf("PLAY called before INIT returned")
+		}
+
+		// PLAY is called once per period
+		start := n.CPUState().Cycles
+		for n.CPUState().Cycles-start < 20*c.playPeriod {
+			if err := n.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if plays := n.ReadMemory(0x02); plays < 19 || plays > 21 {
+			t.Errorf("region %d: PLAY called %d times in 20 periods", region, plays)
+		}
+		if n.ReadMemory(0x04) != 1 {
+			t.Errorf("PLAY ran before INIT finished")
+		}
+
+		// selecting a track restarts INIT with cleared RAM
+		if err := n.SelectTrack(3); err != nil {
+			t.Fatal(err)
+		}
+		if n.ReadMemory(0x02) != 0 || n.ReadMemory(0x03) != 0 {
+			t.Error("RAM not cleared when selecting a track")
+		}
+		for n.ReadMemory(0x03) == 0 {
+			if err := n.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if a := n.ReadMemory(0x00); a != 3 {
+			t.Errorf("INIT called with track %d after selecting track 3", a)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package system
+
+// Options configures optional emulator behavior. The zero value matches the
+// behavior of NewNES.
+type Options struct {
+	// DisableGameDB trusts the iNES header as-is instead of correcting it
+	// from the game database.
+	DisableGameDB bool
+
+	// FDSBIOS is the 8 KB Famicom Disk System BIOS ROM, which is required to
+	// run disk images.
+	FDSBIOS []uint8
+
+	// RAM selects the contents of CPU RAM, PRG RAM, VRAM and OAM at power
+	// on. Seed seeds the random pattern and RandomizeCPU.
+	RAM  RAMPattern
+	Seed int64
+
+	// RandomizeCPU starts the CPU with random register values.
+	RandomizeCPU bool
+
+	// CPU selects the 6502 variant. The NES uses the 2A03, which is the
+	// default.
+	CPU CPUVariant
+}
+
+// CPUVariant identifies a member of the 6502 family, for Options.CPU and
+// NewCPU.
+type CPUVariant int
+
+const (
+	// CPU2A03 is the NES CPU, an NMOS 6502 without decimal mode.
+	CPU2A03 CPUVariant = iota
+
+	// CPUNMOS6502 is the original 6502, with decimal mode.
+	CPUNMOS6502
+
+	// CPU65C02 is the WDC CMOS 6502, with decimal mode and additional
+	// opcodes in place of the unofficial NMOS ones.
+	CPU65C02
+)
@@ -1,9 +1,5 @@
 package system
 
-import (
-	"errors"
-)
-
 const (
 	DrawWidth  = 256
 	DrawHeight = 240
@@ -33,8 +29,8 @@ const (
 	// 1 CPU cycle = 3 PPU cycles
 	ppuCycleRatio = 3
 
-	// cycles taken by an OAM DMA transfer
-	oamDMACycles = 514
+	// frames until an I/O latch bit decays (roughly 600ms)
+	latchDecayFrames = 36
 )
 
 type ppu struct {
@@ -71,8 +67,11 @@ type ppu struct {
 	vBlankPeriod        bool
 	spriteOverflow      bool
 
-	// last value written to a PPU register, used for status read
-	lastWrite uint8
+	// the I/O latch holds the last value on the PPU's data bus, which is
+	// returned in place of unimplemented bits. Each bit decays to 0 some
+	// frames after it was last refreshed.
+	ioLatch      uint8
+	latchRefresh [8]int
 
 	// data read from vram is stored in a buffer
 	dataReadBuffer uint8
@@ -89,6 +88,22 @@ func newPPU(drawer Drawer, bus *ppuBus) *ppu {
 	}
 }
 
+// reset clears the registers affected by the reset line. Memory, OAM and
+// the address registers are kept.
+func (p *ppu) reset() {
+	p.writeCtrl(0)
+	p.writeMask(0)
+	p.writeToggle = false
+	p.loopyT, p.loopyX = 0, 0
+	p.dataReadBuffer = 0
+
+	// a reset starts an even frame; the latch keeps decaying from where it was
+	for i := range p.latchRefresh {
+		p.latchRefresh[i] -= p.frame
+	}
+	p.frame = 0
+}
+
 func (p *ppu) step(cpuCycles uint64) error {
 	cycles := cpuCycles * ppuCycleRatio
 
@@ -365,6 +380,7 @@ func (p *ppu) drawSprites() error {
 }
 
 func (p *ppu) write(a uint16, v uint8) error {
+	p.setLatch(v, 0xff)
 	switch a {
 	case 0:
 		p.writeCtrl(v)
@@ -393,8 +409,29 @@ func (p *ppu) read(a uint16) (uint8, error) {
 	case 7:
 		return p.readData()
 	default:
-		return 0, errors.New("illegal ppu read")
+		// write-only registers return the I/O latch
+		return p.latch(), nil
+	}
+}
+
+// setLatch refreshes the bits of the I/O latch selected by mask.
+func (p *ppu) setLatch(v, mask uint8) {
+	p.ioLatch = (p.ioLatch &^ mask) | (v & mask)
+	for i := uint(0); i < 8; i++ {
+		if mask&(1<<i) != 0 {
+			p.latchRefresh[i] = p.frame
+		}
+	}
+}
+
+// latch returns the I/O latch after decaying stale bits.
+func (p *ppu) latch() uint8 {
+	for i := uint(0); i < 8; i++ {
+		if p.frame-p.latchRefresh[i] > latchDecayFrames {
+			p.ioLatch &^= 1 << i
+		}
 	}
+	return p.ioLatch
 }
 
 func (p *ppu) writeCtrl(v uint8) {
@@ -416,7 +453,6 @@ func (p *ppu) writeCtrl(v uint8) {
 	p.largeSprites = isBitSet(v, 5)
 	p.vblankNMI = isBitSet(v, 7)
 
-	p.lastWrite = v
 }
 
 func (p *ppu) writeMask(v uint8) {
@@ -429,7 +465,6 @@ func (p *ppu) writeMask(v uint8) {
 	p.eGreen = isBitSet(v, 6)
 	p.eBlue = isBitSet(v, 7)
 
-	p.lastWrite = v
 }
 
 func (p *ppu) readStatus() (uint8, error) {
@@ -437,8 +472,8 @@ func (p *ppu) readStatus() (uint8, error) {
 
 	p.writeToggle = false
 
-	// bottom 5 bits are last written value
-	r |= (p.lastWrite & 0x1f)
+	// bottom 5 bits are the I/O latch
+	r |= (p.latch() & 0x1f)
 
 	if p.vBlankPeriod {
 		r |= (1 << 7)
@@ -453,26 +488,26 @@ func (p *ppu) readStatus() (uint8, error) {
 		r |= (1 << 5)
 	}
 
+	p.setLatch(r, 0xe0)
 	return r, nil
 }
 
 func (p *ppu) writeOamAddr(v uint8) {
-	p.lastWrite = v
 	p.oamAddr = v
 }
 
 func (p *ppu) readOamData() (uint8, error) {
-	return p.oam[p.oamAddr], nil
+	v := p.oam[p.oamAddr]
+	p.setLatch(v, 0xff)
+	return v, nil
 }
 
 func (p *ppu) writeOamData(v uint8) {
-	p.lastWrite = v
 	p.oam[p.oamAddr] = v
 	p.oamAddr++
 }
 
 func (p *ppu) writeScroll(v uint8) {
-	p.lastWrite = v
 	if p.writeToggle {
 		p.writeScrollY(v)
 	} else {
@@ -482,7 +517,6 @@ func (p *ppu) writeScroll(v uint8) {
 }
 
 func (p *ppu) writeAddress(v uint8) {
-	p.lastWrite = v
 	if p.writeToggle {
 		p.loopyT &= 0xff00
 		p.loopyT |= uint16(v)
@@ -502,14 +536,18 @@ func (p *ppu) readData() (uint8, error) {
 
 	// non-palette VRAM is buffered, and reads are subsequently delayed by one
 	result := r
-	if p.loopyV <= vramHighAddr {
+	if p.loopyV%ppuBusMirror <= vramHighAddr {
 		result = p.dataReadBuffer
 		p.dataReadBuffer = r
-	} else if p.loopyV <= paletteHighAddr {
+		p.setLatch(result, 0xff)
+	} else {
 		p.dataReadBuffer, err = p.bus.read(p.loopyV - 0x1000)
 		if err != nil {
 			return 0, err
 		}
+		// palette entries are 6 bits wide
+		result = (r & 0x3f) | (p.latch() & 0xc0)
+		p.setLatch(result, 0x3f)
 	}
 
 	if p.vramDownInc {
@@ -522,7 +560,6 @@ func (p *ppu) readData() (uint8, error) {
 }
 
 func (p *ppu) writeData(v uint8) error {
-	p.lastWrite = v
 	err := p.bus.write(p.loopyV, v)
 	if err != nil {
 		return err
@@ -537,22 +574,6 @@ func (p *ppu) writeData(v uint8) error {
 	return nil
 }
 
-func (p *ppu) oamDMA(v uint8, bus *cpuBus) error {
-	p.lastWrite = v
-	addr := uint16(v) << 8
-	var i uint16
-	for i = 0; i < oamSize; i++ {
-		r, err := bus.read(addr + i)
-		if err != nil {
-			return err
-		}
-		p.oam[p.oamAddr+uint8(i)] = r
-	}
-
-	p.step(oamDMACycles)
-	return nil
-}
-
 func (p *ppu) writeScrollX(v uint8) {
 	p.loopyT &= 0xffe0
 	p.loopyT |= uint16(v >> 3)
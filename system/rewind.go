@@ -0,0 +1,228 @@
+package system
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io/ioutil"
+)
+
+// RewindConfig configures a RewindBuffer.
+type RewindConfig struct {
+	// Budget is the most memory, in bytes, that compressed states may use.
+	// The oldest states are dropped to stay under it.
+	Budget int
+
+	// Interval is the number of frames between states. Rewinding steps back
+	// by this many frames at a time.
+	Interval int
+
+	// KeyframeInterval is the number of states in each group. The first state
+	// of a group is stored whole, and the rest as differences from it.
+	KeyframeInterval int
+}
+
+// DefaultRewindConfig keeps roughly the last few minutes of play at one state
+// per frame.
+var DefaultRewindConfig = RewindConfig{
+	Budget:           64 << 20,
+	Interval:         1,
+	KeyframeInterval: 60,
+}
+
+// RewindBuffer keeps a ring of recent save states to play a game backwards.
+type RewindBuffer struct {
+	nes    NES
+	cfg    RewindConfig
+	groups []*rewindGroup
+	used   int
+	frames int
+
+	// the uncompressed keyframe of the last group, which new states are
+	// encoded against
+	key []uint8
+
+	// reused, as flate writers are expensive to allocate
+	w *flate.Writer
+}
+
+// rewindGroup is a keyframe followed by states stored as their XOR with the
+// keyframe. All are compressed.
+type rewindGroup struct {
+	key    []uint8
+	deltas [][]uint8
+}
+
+// NewRewindBuffer creates an empty rewind buffer for n.
+func NewRewindBuffer(n NES, cfg RewindConfig) *RewindBuffer {
+	if cfg.Interval < 1 {
+		cfg.Interval = 1
+	}
+	if cfg.KeyframeInterval < 1 {
+		cfg.KeyframeInterval = 1
+	}
+	return &RewindBuffer{nes: n, cfg: cfg}
+}
+
+// Push records the current state if Interval frames have passed since the
+// last one. It should be called once per frame.
+func (r *RewindBuffer) Push() error {
+	r.frames++
+	if r.frames < r.cfg.Interval {
+		return nil
+	}
+	r.frames = 0
+
+	var b bytes.Buffer
+	if err := r.nes.SaveState(&b); err != nil {
+		return err
+	}
+	state := b.Bytes()
+
+	last := r.last()
+	if last == nil || len(last.deltas)+1 >= r.cfg.KeyframeInterval || len(state) != len(r.key) {
+		c, err := r.compress(state)
+		if err != nil {
+			return err
+		}
+		r.groups = append(r.groups, &rewindGroup{key: c})
+		r.key = state
+		r.used += len(c)
+	} else {
+		for i := range state {
+			state[i] ^= r.key[i]
+		}
+		c, err := r.compress(state)
+		if err != nil {
+			return err
+		}
+		last.deltas = append(last.deltas, c)
+		r.used += len(c)
+	}
+
+	// drop the oldest groups, but always keep the newest
+	for r.used > r.cfg.Budget && len(r.groups) > 1 {
+		r.used -= r.groups[0].size()
+		r.groups[0] = nil
+		r.groups = r.groups[1:]
+	}
+	return nil
+}
+
+// Rewind restores the most recent state and removes it from the buffer. It
+// returns false if the buffer is empty.
+func (r *RewindBuffer) Rewind() (bool, error) {
+	last := r.last()
+	if last == nil {
+		return false, nil
+	}
+	r.frames = 0
+
+	var state []uint8
+	if n := len(last.deltas); n > 0 {
+		delta, err := decompress(last.deltas[n-1])
+		if err != nil {
+			return false, err
+		}
+		if len(delta) != len(r.key) {
+			return false, errors.New("rewind state does not match its keyframe")
+		}
+		for i := range delta {
+			delta[i] ^= r.key[i]
+		}
+		state = delta
+		r.used -= len(last.deltas[n-1])
+		last.deltas = last.deltas[:n-1]
+	} else {
+		state = r.key
+		r.used -= len(last.key)
+		r.groups = r.groups[:len(r.groups)-1]
+		r.key = nil
+		if prev := r.last(); prev != nil {
+			key, err := decompress(prev.key)
+			if err != nil {
+				return false, err
+			}
+			r.key = key
+		}
+	}
+
+	if err := r.nes.LoadState(bytes.NewReader(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RewindFrame steps the game back and runs the frame after the restored state
+// so that it is drawn. States that would not move the game back, such as the
+// one pushed for the current frame, are dropped. It returns false once the
+// buffer runs out.
+func (r *RewindBuffer) RewindFrame() (bool, error) {
+	now := r.nes.FrameCount()
+	for {
+		ok, err := r.Rewind()
+		if err != nil || !ok {
+			return false, err
+		}
+		if r.nes.FrameCount()+1 < now {
+			break
+		}
+	}
+	return true, r.nes.RunFrame()
+}
+
+// Clear removes every state.
+func (r *RewindBuffer) Clear() {
+	r.groups = nil
+	r.key = nil
+	r.used = 0
+	r.frames = 0
+}
+
+// Len returns the number of states in the buffer.
+func (r *RewindBuffer) Len() int {
+	n := 0
+	for _, g := range r.groups {
+		n += 1 + len(g.deltas)
+	}
+	return n
+}
+
+func (r *RewindBuffer) last() *rewindGroup {
+	if len(r.groups) == 0 {
+		return nil
+	}
+	return r.groups[len(r.groups)-1]
+}
+
+func (g *rewindGroup) size() int {
+	n := len(g.key)
+	for _, d := range g.deltas {
+		n += len(d)
+	}
+	return n
+}
+
+func (r *RewindBuffer) compress(data []uint8) ([]uint8, error) {
+	var b bytes.Buffer
+	if r.w == nil {
+		w, err := flate.NewWriter(&b, flate.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		r.w = w
+	} else {
+		r.w.Reset(&b)
+	}
+	if _, err := r.w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := r.w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decompress(data []uint8) ([]uint8, error) {
+	return ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+}
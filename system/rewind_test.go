@@ -0,0 +1,111 @@
+package system
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRewind(t *testing.T) {
+	n, err := NewNES(stateROM, nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRewindBuffer(n, RewindConfig{Budget: 1 << 20, Interval: 2, KeyframeInterval: 3})
+
+	var history []machineSnapshot
+	for i := 0; i < 20; i++ {
+		runFrames(t, n, 1)
+		if err := r.Push(); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 1 {
+			history = append(history, snapshot(n))
+		}
+	}
+	if r.Len() != len(history) {
+		t.Fatalf("buffer has %d states, want %d", r.Len(), len(history))
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		pixels := string(n.Framebuffer().Pix)
+		ok, err := r.Rewind()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("buffer empty at state %d", i)
+		}
+		// the framebuffer is not part of the state, so it keeps the frame
+		// shown before rewinding
+		got, want := snapshot(n), history[i]
+		if got.pixels != pixels {
+			t.Fatalf("state %d: rewinding changed the framebuffer", i)
+		}
+		got.pixels, want.pixels = "", ""
+		if got != want {
+			t.Fatalf("state %d: want %+v, got %+v", i, want.cpu, got.cpu)
+		}
+	}
+	if ok, _ := r.Rewind(); ok {
+		t.Error("rewound past the first state")
+	}
+}
+
+func TestRewindBudget(t *testing.T) {
+	n, err := NewNES(stateROM, nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRewindBuffer(n, RewindConfig{Budget: 1, Interval: 1, KeyframeInterval: 4})
+	for i := 0; i < 10; i++ {
+		runFrames(t, n, 1)
+		if err := r.Push(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// only the newest group is kept once the budget is exceeded
+	if r.Len() > 4 {
+		t.Errorf("buffer has %d states over its budget", r.Len())
+	}
+}
+
+func TestRewindFrame(t *testing.T) {
+	tests := []struct {
+		interval int
+		frames   []int
+	}{
+		// the state pushed for the current frame is dropped, so the first
+		// step shows the frame before it
+		{1, []int{11, 10, 9, 8, 7, 6, 5, 4, 3, 2}},
+		{3, []int{10, 7, 4}},
+	}
+
+	for _, tt := range tests {
+		n, err := NewNES(stateROM, nil, nopController{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewRewindBuffer(n, RewindConfig{Budget: 1 << 20, Interval: tt.interval, KeyframeInterval: 4})
+		for i := 0; i < 12; i++ {
+			runFrames(t, n, 1)
+			if err := r.Push(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var frames []int
+		for {
+			ok, err := r.RewindFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			frames = append(frames, n.FrameCount())
+		}
+		if fmt.Sprint(frames) != fmt.Sprint(tt.frames) {
+			t.Errorf("interval %d: rewound through frames %v, want %v", tt.interval, frames, tt.frames)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package system
+
+import (
+	"bytes"
+)
+
+// RunAhead hides a game's input lag by emulating frames ahead with the
+// current input, showing the last of them, then restoring the state after
+// the first. Games that take Frames frames to react to input then respond on
+// the next frame shown.
+type RunAhead struct {
+	nes    NES
+	Frames int
+	state  bytes.Buffer
+}
+
+// NewRunAhead returns a RunAhead for n that runs frames ahead.
+func NewRunAhead(n NES, frames int) *RunAhead {
+	return &RunAhead{nes: n, Frames: frames}
+}
+
+// RunFrame runs one frame, drawing the frame Frames ahead of it instead.
+func (r *RunAhead) RunFrame() error {
+	if r.Frames <= 0 {
+		return r.nes.RunFrame()
+	}
+
+	r.nes.SetVideoEnabled(false)
+	defer r.nes.SetVideoEnabled(true)
+	if err := r.nes.RunFrame(); err != nil {
+		return err
+	}
+	r.state.Reset()
+	if err := r.nes.SaveState(&r.state); err != nil {
+		return err
+	}
+
+	for i := 1; i < r.Frames; i++ {
+		if err := r.nes.RunFrame(); err != nil {
+			return err
+		}
+	}
+	r.nes.SetVideoEnabled(true)
+	if err := r.nes.RunFrame(); err != nil {
+		return err
+	}
+	return r.nes.LoadState(bytes.NewReader(r.state.Bytes()))
+}
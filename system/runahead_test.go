@@ -0,0 +1,38 @@
+package system
+
+import (
+	"testing"
+)
+
+func TestRunAhead(t *testing.T) {
+	const ahead = 2
+	n, err := NewNES(stateROM, nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := NewNES(stateROM, nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRunAhead(n, ahead)
+	for i := 0; i < 5; i++ {
+		if err := r.RunFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runFrames(t, ref, 5)
+
+	// the machine only advances by the frames run
+	got, want := snapshot(n), snapshot(ref)
+	got.pixels, want.pixels = "", ""
+	if got != want {
+		t.Fatalf("state diverged\nwant: %+v\ngot:  %+v", want.cpu, got.cpu)
+	}
+
+	// while the frame shown is the one ahead of it
+	runFrames(t, ref, ahead)
+	if string(n.Framebuffer().Pix) != string(ref.Framebuffer().Pix) {
+		t.Error("frame shown is not the frame ahead")
+	}
+}
@@ -0,0 +1,164 @@
+package system
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// save state header
+var stateMagic = []uint8("NQST")
+
+const stateVersion uint16 = 1
+
+// stateCodec saves or restores machine state. Each component describes its
+// state once by passing pointers to its fields, which are written when
+// saving and overwritten when loading. The first error is kept and later
+// calls do nothing.
+type stateCodec struct {
+	w   io.Writer
+	r   io.Reader
+	err error
+}
+
+func (s *stateCodec) loading() bool {
+	return s.r != nil
+}
+
+// fixed saves or restores values with a fixed size, such as uint8, bool and
+// arrays of them.
+func (s *stateCodec) fixed(vs ...interface{}) {
+	for _, v := range vs {
+		if s.err != nil {
+			return
+		}
+		if s.loading() {
+			s.err = binary.Read(s.r, binary.LittleEndian, v)
+		} else {
+			s.err = binary.Write(s.w, binary.LittleEndian, v)
+		}
+	}
+}
+
+// ints saves or restores ints, which have no fixed size.
+func (s *stateCodec) ints(vs ...*int) {
+	for _, v := range vs {
+		n := int64(*v)
+		s.fixed(&n)
+		*v = int(n)
+	}
+}
+
+// bytes saves or restores a slice, whose length must match the saved length.
+func (s *stateCodec) bytes(b []uint8) {
+	n := uint32(len(b))
+	s.fixed(&n)
+	if s.err != nil {
+		return
+	}
+	if s.loading() {
+		if int(n) != len(b) {
+			s.err = errors.New(fmt.Sprintf("state has %d bytes where %d were expected", n, len(b)))
+			return
+		}
+		_, s.err = io.ReadFull(s.r, b)
+	} else {
+		_, s.err = s.w.Write(b)
+	}
+}
+
+func hashROM(rom []uint8) [sha1.Size]uint8 {
+	return sha1.Sum(rom)
+}
+
+// serialize saves or restores the state of every component. The APU is not
+// emulated, so it has no state to save.
+func (n *nes) serialize(s *stateCodec) {
+	n.cpu.serialize(s)
+	n.bus.serialize(s)
+	n.ppu.serialize(s)
+	n.ppu.bus.serialize(s)
+	n.cartridge.serialize(s)
+	s.ints(&n.screen.frames)
+}
+
+func (n *nes) SaveState(w io.Writer) error {
+	var b bytes.Buffer
+	b.Write(stateMagic)
+	s := &stateCodec{w: &b}
+	version := stateVersion
+	hash := n.romHash
+	s.fixed(&version, &hash)
+	n.serialize(s)
+	if s.err != nil {
+		return s.err
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func (n *nes) LoadState(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, stateMagic) {
+		return errors.New("not a save state")
+	}
+	s := &stateCodec{r: bytes.NewReader(data[len(stateMagic):])}
+	var version uint16
+	var hash [sha1.Size]uint8
+	s.fixed(&version, &hash)
+	switch {
+	case s.err != nil:
+		return s.err
+	case version != stateVersion:
+		return errors.New(fmt.Sprintf("save state version %d is not supported", version))
+	case hash != n.romHash:
+		return errors.New("save state was made with a different rom")
+	}
+
+	// keep the current state to roll back to if the save state is corrupt
+	var backup bytes.Buffer
+	n.serialize(&stateCodec{w: &backup})
+
+	n.serialize(s)
+	if s.err != nil {
+		n.serialize(&stateCodec{r: &backup})
+		return errors.New(fmt.Sprintf("save state is corrupt: %s", s.err))
+	}
+	return nil
+}
+
+func (c *cpu) serialize(s *stateCodec) {
+	s.fixed(&c.pc, &c.a, &c.x, &c.y, &c.p, &c.sp, &c.clock,
+		&c.irq, &c.nmi, &c.runIRQ, &c.prevRunIRQ, &c.runNMI, &c.prevRunNMI,
+		&c.halted, &c.waiting)
+}
+
+func (b *cpuBus) serialize(s *stateCodec) {
+	s.fixed(&b.wram, &b.dmaPending, &b.dmaPage, &b.openBus)
+	s.fixed(&b.joypad1.strobe, &b.joypad2.strobe)
+	s.ints(&b.joypad1.input, &b.joypad2.input)
+}
+
+func (p *ppu) serialize(s *stateCodec) {
+	s.ints(&p.dot, &p.scanline, &p.frame)
+	s.fixed(&p.oamAddr, &p.oam, &p.loopyX, &p.loopyT, &p.loopyV, &p.writeToggle,
+		&p.spriteTableAddr, &p.bgPatternTableAddr,
+		&p.largeSprites, &p.vblankNMI, &p.vramDownInc, &p.grayscale,
+		&p.showTilesLeft, &p.showSpritesLeft, &p.showTiles, &p.showSprites,
+		&p.eRed, &p.eGreen, &p.eBlue, &p.spriteZeroHit, &p.vBlankPeriod, &p.spriteOverflow,
+		&p.ioLatch, &p.dataReadBuffer)
+	for i := range p.latchRefresh {
+		s.ints(&p.latchRefresh[i])
+	}
+}
+
+func (b *ppuBus) serialize(s *stateCodec) {
+	s.fixed(&b.vram, &b.paletteRAM)
+}
@@ -0,0 +1,86 @@
+package system
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rhallman96/nesquack/internal/testrom"
+)
+
+// stateROM writes a changing value to RAM, PRG RAM and the backdrop color.
+var stateROM = testrom.Build(mmc1Header, nil, []uint8{
+	0xe8,             // INX
+	0x8e, 0x00, 0x60, // STX $6000
+	0xe6, 0x00, // INC $00
+	0xa9, 0x3f, 0x8d, 0x06, 0x20, // LDA #$3F, STA $2006
+	0xa9, 0x00, 0x8d, 0x06, 0x20, // LDA #$00, STA $2006
+	0x8e, 0x07, 0x20, // STX $2007
+	0x4c, 0x00, 0xc0, // JMP $C000
+})
+
+type machineSnapshot struct {
+	cpu    CPUState
+	ram    uint8
+	prgRAM uint8
+	frames int
+	pixels string
+}
+
+func snapshot(n NES) machineSnapshot {
+	return machineSnapshot{
+		cpu:    n.CPUState(),
+		ram:    n.ReadMemory(0x0000),
+		prgRAM: n.ReadMemory(0x6000),
+		frames: n.FrameCount(),
+		pixels: string(n.Framebuffer().Pix),
+	}
+}
+
+func runFrames(t *testing.T, n NES, frames int) {
+	for i := 0; i < frames; i++ {
+		if err := n.RunFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	n, err := NewNES(stateROM, nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runFrames(t, n, 5)
+
+	var state bytes.Buffer
+	if err := n.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+	saved := state.Bytes()
+
+	runFrames(t, n, 3)
+	want := snapshot(n)
+
+	if err := n.LoadState(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	runFrames(t, n, 3)
+	if got := snapshot(n); got != want {
+		t.Errorf("state diverged after loading\nwant: %+v\ngot:  %+v", want.cpu, got.cpu)
+	}
+
+	// a truncated state leaves the machine unchanged
+	if err := n.LoadState(bytes.NewReader(saved[:len(saved)/2])); err == nil {
+		t.Error("truncated state loaded")
+	}
+	if got := snapshot(n); got != want {
+		t.Error("truncated state changed the machine")
+	}
+
+	other, err := NewNES(testrom.Build(nromHeader, nil, nil), nil, nopController{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.LoadState(bytes.NewReader(saved)); err == nil {
+		t.Error("state loaded with a different rom")
+	}
+}
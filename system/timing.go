@@ -0,0 +1,47 @@
+package system
+
+// Region identifies the console a game was released for.
+type Region int
+
+// regions, numbered as in the NES 2.0 header
+const (
+	RegionNTSC Region = iota
+	RegionPAL
+	RegionMulti
+	RegionDendy
+)
+
+func (r Region) String() string {
+	switch r {
+	case RegionNTSC:
+		return "NTSC"
+	case RegionPAL:
+		return "PAL"
+	case RegionMulti:
+		return "multi-region"
+	case RegionDendy:
+		return "Dendy"
+	}
+	return "unknown"
+}
+
+// Timing describes the clock rates of the emulated console.
+type Timing struct {
+	// CPUClock is the CPU frequency in Hz.
+	CPUClock float64
+
+	// FrameRate is the number of frames per second.
+	FrameRate float64
+
+	Scanlines       int
+	DotsPerScanline int
+}
+
+// ntscTiming is the timing of the NTSC console, whose master clock runs at
+// 236.25 / 11 MHz and is divided by 12 for the CPU.
+var ntscTiming = Timing{
+	CPUClock:        236.25e6 / 11 / 12,
+	FrameRate:       236.25e6 / 11 / 4 / (scanlineCount*dotCount - 0.5),
+	Scanlines:       scanlineCount,
+	DotsPerScanline: dotCount,
+}
@@ -0,0 +1,218 @@
+package system
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// tracer logs executed instructions in the Nintendulator format used by the
+// nestest log:
+//
+//	C000  4C F5 C5  JMP $C5F5                       A:00 X:00 Y:00 P:24 SP:FD PPU:  0, 21 CYC:7
+type tracer struct {
+	w         io.Writer
+	low, high uint16
+}
+
+// operandFormat describes how an addressing mode is disassembled.
+type operandFormat int
+
+const (
+	formatImplied operandFormat = iota
+	formatAccumulator
+	formatImmediate
+	formatZeroPage
+	formatZeroPageX
+	formatZeroPageY
+	formatAbsolute
+	formatAbsoluteX
+	formatAbsoluteY
+	formatIndirect
+	formatIndirectX
+	formatIndirectY
+	formatRelative
+	formatZeroPageIndirect
+	formatAbsoluteIndirect
+	formatAbsoluteIndirectX
+)
+
+// operandSizes is the number of operand bytes following each opcode.
+var operandSizes = map[operandFormat]uint16{
+	formatImmediate:         1,
+	formatZeroPage:          1,
+	formatZeroPageX:         1,
+	formatZeroPageY:         1,
+	formatAbsolute:          2,
+	formatAbsoluteX:         2,
+	formatAbsoluteY:         2,
+	formatIndirect:          2,
+	formatIndirectX:         1,
+	formatIndirectY:         1,
+	formatRelative:          1,
+	formatZeroPageIndirect:  1,
+	formatAbsoluteIndirect:  2,
+	formatAbsoluteIndirectX: 2,
+}
+
+// operandFormats maps addressing mode functions to their formats. Functions
+// cannot be compared directly, so they are keyed by address.
+var operandFormats = map[uintptr]operandFormat{}
+
+func init() {
+	modes := []struct {
+		mode   addressMode
+		format operandFormat
+	}{
+		{implied, formatImplied},
+		{singleCycle, formatImplied},
+		{accumulator, formatAccumulator},
+		{immediate, formatImmediate},
+		{zeroPage, formatZeroPage},
+		{zeroPageX, formatZeroPageX},
+		{zeroPageY, formatZeroPageY},
+		{absolute, formatAbsolute},
+		{absoluteDeferred, formatAbsolute},
+		{absoluteX, formatAbsoluteX},
+		{absoluteY, formatAbsoluteY},
+		{indirect, formatIndirect},
+		{indirectX, formatIndirectX},
+		{indirectY, formatIndirectY},
+		{relative, formatRelative},
+		{zeroPageIndirect, formatZeroPageIndirect},
+		{absoluteIndirect, formatAbsoluteIndirect},
+		{absoluteIndirectX, formatAbsoluteIndirectX},
+	}
+	for _, m := range modes {
+		operandFormats[reflect.ValueOf(m.mode).Pointer()] = m.format
+	}
+}
+
+// trace logs the instruction at the program counter if it is in range.
+func (t *tracer) trace(c *cpu) error {
+	if c.pc < t.low || c.pc > t.high {
+		return nil
+	}
+
+	raw, text := disassemble(c, c.pc)
+	bytes := make([]string, len(raw))
+	for i, v := range raw {
+		bytes[i] = fmt.Sprintf("%02X", v)
+	}
+
+	// the asterisk of unofficial mnemonics is printed in the column before
+	// the mnemonic
+	if !strings.HasPrefix(text, "*") {
+		text = " " + text
+	}
+
+	// CPUs outside an NES have no PPU position
+	var scanline, dot int
+	if b, ok := c.bus.(*cpuBus); ok {
+		scanline, dot = b.ppu.scanline, b.ppu.dot
+	}
+
+	status := (c.p &^ flagBreak) | flagBreakHi
+	_, err := fmt.Fprintf(t.w, "%04X  %-8s %-32s A:%02X X:%02X Y:%02X P:%02X SP:%02X PPU:%3d,%3d CYC:%d\n",
+		c.pc, strings.Join(bytes, " "), text, c.a, c.x, c.y, status, c.sp,
+		scanline, dot, c.clock)
+	return err
+}
+
+// disassemble returns the bytes of the instruction at pc and its disassembly.
+// Memory operands are resolved using the current register values.
+func disassemble(c *cpu, pc uint16) ([]uint8, string) {
+	b := c.bus
+	op := b.peek(pc)
+	i := c.instructions[op]
+	if i == nil {
+		return []uint8{op}, fmt.Sprintf("??? $%02X", op)
+	}
+
+	format := operandFormats[reflect.ValueOf(i.addressMode).Pointer()]
+	size := operandSizes[format]
+	// BBR and BBS take a zero page address and a branch offset
+	branchOnBit := strings.HasPrefix(i.mnemonic, "BBR") || strings.HasPrefix(i.mnemonic, "BBS")
+	if branchOnBit {
+		size = 2
+	}
+
+	raw := []uint8{op}
+	for n := uint16(1); n <= size; n++ {
+		raw = append(raw, b.peek(pc+n))
+	}
+	var v8 uint8
+	var v16 uint16
+	if size > 0 {
+		v8 = raw[1]
+		v16 = uint16(raw[1])
+	}
+	if size > 1 {
+		v16 |= uint16(raw[2]) << 8
+	}
+	next := pc + 1 + size
+
+	peekWord := func(a uint16) uint16 {
+		return uint16(b.peek(a)) | uint16(b.peek(a+1))<<8
+	}
+	peekWordZeroPage := func(a uint8) uint16 {
+		return uint16(b.peek(uint16(a))) | uint16(b.peek(uint16(a+1)))<<8
+	}
+
+	var operand string
+	switch {
+	case branchOnBit:
+		operand = fmt.Sprintf("$%02X,$%04X", v8, next+uint16(int8(raw[2])))
+	case format == formatAccumulator:
+		operand = "A"
+	case format == formatImmediate:
+		operand = fmt.Sprintf("#$%02X", v8)
+	case format == formatZeroPage:
+		operand = fmt.Sprintf("$%02X = %02X", v8, b.peek(uint16(v8)))
+	case format == formatZeroPageX:
+		a := v8 + c.x
+		operand = fmt.Sprintf("$%02X,X @ %02X = %02X", v8, a, b.peek(uint16(a)))
+	case format == formatZeroPageY:
+		a := v8 + c.y
+		operand = fmt.Sprintf("$%02X,Y @ %02X = %02X", v8, a, b.peek(uint16(a)))
+	case format == formatAbsolute:
+		if i.mnemonic == "JMP" || i.mnemonic == "JSR" {
+			operand = fmt.Sprintf("$%04X", v16)
+		} else {
+			operand = fmt.Sprintf("$%04X = %02X", v16, b.peek(v16))
+		}
+	case format == formatAbsoluteX:
+		a := v16 + uint16(c.x)
+		operand = fmt.Sprintf("$%04X,X @ %04X = %02X", v16, a, b.peek(a))
+	case format == formatAbsoluteY:
+		a := v16 + uint16(c.y)
+		operand = fmt.Sprintf("$%04X,Y @ %04X = %02X", v16, a, b.peek(a))
+	case format == formatIndirect:
+		// the NMOS page wrapping bug
+		dest := uint16(b.peek(v16)) | uint16(b.peek((v16&0xff00)|((v16+1)&0xff)))<<8
+		operand = fmt.Sprintf("($%04X) = %04X", v16, dest)
+	case format == formatIndirectX:
+		a := v8 + c.x
+		dest := peekWordZeroPage(a)
+		operand = fmt.Sprintf("($%02X,X) @ %02X = %04X = %02X", v8, a, dest, b.peek(dest))
+	case format == formatIndirectY:
+		base := peekWordZeroPage(v8)
+		dest := base + uint16(c.y)
+		operand = fmt.Sprintf("($%02X),Y = %04X @ %04X = %02X", v8, base, dest, b.peek(dest))
+	case format == formatRelative:
+		operand = fmt.Sprintf("$%04X", next+uint16(int8(v8)))
+	case format == formatZeroPageIndirect:
+		dest := peekWordZeroPage(v8)
+		operand = fmt.Sprintf("($%02X) = %04X = %02X", v8, dest, b.peek(dest))
+	case format == formatAbsoluteIndirect:
+		operand = fmt.Sprintf("($%04X) = %04X", v16, peekWord(v16))
+	case format == formatAbsoluteIndirectX:
+		operand = fmt.Sprintf("($%04X,X) = %04X", v16, peekWord(v16+uint16(c.x)))
+	}
+
+	if operand == "" {
+		return raw, i.mnemonic
+	}
+	return raw, i.mnemonic + " " + operand
+}
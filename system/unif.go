@@ -0,0 +1,171 @@
+package system
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+)
+
+var (
+	unifPrefix = []uint8{0x55, 0x4e, 0x49, 0x46} // "UNIF"
+)
+
+const (
+	unifHeaderSize      = 0x20
+	unifChunkHeaderSize = 8
+)
+
+// unifBoards maps UNIF board names (without their NES-, HVC-, or UNL-
+// prefix) to the iNES mapper implementing them.
+var unifBoards = map[string]int{
+	"NROM":     nromHeader,
+	"NROM-128": nromHeader,
+	"NROM-256": nromHeader,
+	"RROM":     nromHeader,
+	"RROM-128": nromHeader,
+
+	"SAROM":  mmc1Header,
+	"SBROM":  mmc1Header,
+	"SCROM":  mmc1Header,
+	"SEROM":  mmc1Header,
+	"SFROM":  mmc1Header,
+	"SGROM":  mmc1Header,
+	"SHROM":  mmc1Header,
+	"SJROM":  mmc1Header,
+	"SKROM":  mmc1Header,
+	"SLROM":  mmc1Header,
+	"SL1ROM": mmc1Header,
+	"SNROM":  mmc1Header,
+	"SOROM":  mmc1Header,
+	"SUROM":  mmc1Header,
+	"SXROM":  mmc1Header,
+
+	"TBROM": mmc3Header,
+	"TEROM": mmc3Header,
+	"TFROM": mmc3Header,
+	"TGROM": mmc3Header,
+	"TKROM": mmc3Header,
+	"TLROM": mmc3Header,
+	"TNROM": mmc3Header,
+	"TSROM": mmc3Header,
+}
+
+// unifPRGRAMSizes holds the PRG RAM size of boards with more than 8 KB, which
+// the MMC1 banks with its CHR bank register.
+var unifPRGRAMSizes = map[string]int{
+	"SOROM": 2 * prgRAMBankSize,
+	"SXROM": 4 * prgRAMBankSize,
+}
+
+// unifBoardPrefixes are stripped from board names before they are looked up.
+var unifBoardPrefixes = []string{"NES-", "HVC-", "UNL-", "BTL-", "BMC-", "IREM-", "KONAMI-"}
+
+// isUNIF indicates if a ROM is stored in the UNIF format.
+func isUNIF(rom []uint8) bool {
+	return len(rom) >= len(unifPrefix) && reflect.DeepEqual(rom[:4], unifPrefix)
+}
+
+// createUNIFCartridge creates a cartridge from a UNIF image. UNIF stores
+// metadata in chunks rather than a fixed header, and identifies the board by
+// name instead of by mapper number.
+func createUNIFCartridge(rom []uint8, meminit *memoryInit) (cartridge, error) {
+	if len(rom) < unifHeaderSize {
+		return nil, errors.New("unif header is truncated")
+	}
+
+	var board string
+	var mapperMirror bool
+	var prg, chr [16][]uint8
+	info := romInfo{
+		mirror: horizontal,
+	}
+
+	for i := unifHeaderSize; i < len(rom); {
+		if i+unifChunkHeaderSize > len(rom) {
+			return nil, errors.New("unif chunk header is truncated")
+		}
+		id := string(rom[i : i+4])
+		size := int(binary.LittleEndian.Uint32(rom[i+4 : i+8]))
+		i += unifChunkHeaderSize
+		if size < 0 || i+size > len(rom) {
+			return nil, errors.New(fmt.Sprintf("unif chunk %s is truncated", id))
+		}
+		data := rom[i : i+size]
+		i += size
+
+		switch {
+		case id == "MAPR":
+			board = strings.TrimRight(string(data), "\x00")
+		case id == "MIRR" && size > 0:
+			switch data[0] {
+			case 0:
+				info.mirror = horizontal
+			case 1:
+				info.mirror = vertical
+			case 2:
+				info.mirror = onePage
+			case 3:
+				info.mirror = onePageHigh
+			case 4:
+				return nil, errors.New("four-screen mirroring is not supported")
+			case 5:
+				// left to the mapper's registers
+				mapperMirror = true
+			default:
+				return nil, errors.New(fmt.Sprintf("unknown unif mirroring %d", data[0]))
+			}
+		case id == "BATR" && size > 0:
+			info.battery = data[0] != 0
+		case strings.HasPrefix(id, "PRG"):
+			if n, ok := unifChunkIndex(id); ok {
+				prg[n] = data
+			}
+		case strings.HasPrefix(id, "CHR"):
+			if n, ok := unifChunkIndex(id); ok {
+				chr[n] = data
+			}
+		}
+	}
+
+	name := board
+	for _, p := range unifBoardPrefixes {
+		name = strings.TrimPrefix(name, p)
+	}
+	mapper, ok := unifBoards[name]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unsupported UNIF board %q", board))
+	}
+	info.mapper = mapper
+	info.prgRAMSize = unifPRGRAMSizes[name]
+	if mapperMirror && mapper == nromHeader {
+		return nil, errors.New(fmt.Sprintf("UNIF board %s has no mapper-controlled mirroring", board))
+	}
+
+	log.Printf("UNIF board %s", board)
+
+	var prgROM, chrROM []uint8
+	for n := range prg {
+		prgROM = append(prgROM, prg[n]...)
+		chrROM = append(chrROM, chr[n]...)
+	}
+	if len(prgROM) == 0 {
+		return nil, errors.New("unif image has no PRG data")
+	}
+
+	return newCartridge(info, prgROM, chrROM, nil, meminit)
+}
+
+// unifChunkIndex parses the hexadecimal digit ending PRGn and CHRn chunk IDs.
+func unifChunkIndex(id string) (int, bool) {
+	c := id[3]
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0'), true
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10, true
+	}
+	return 0, false
+}
@@ -0,0 +1,154 @@
+package system
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// unifChunk returns a UNIF chunk with the given ID and data.
+func unifChunk(id string, data []uint8) []uint8 {
+	c := append([]uint8(id), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(c[4:], uint32(len(data)))
+	return append(c, data...)
+}
+
+// unifImage returns a UNIF image holding chunks.
+func unifImage(chunks ...[]uint8) []uint8 {
+	rom := make([]uint8, unifHeaderSize)
+	copy(rom, unifPrefix)
+	rom[4] = 7
+	for _, c := range chunks {
+		rom = append(rom, c...)
+	}
+	return rom
+}
+
+func filledBank(size int, v uint8) []uint8 {
+	return bytes.Repeat([]uint8{v}, size)
+}
+
+func TestUNIFChunks(t *testing.T) {
+	// banks are ordered by chunk ID rather than by position in the file
+	rom := unifImage(
+		unifChunk("MAPR", []uint8("NES-SLROM\x00")),
+		unifChunk("PRG1", filledBank(prgROMBankSize, 1)),
+		unifChunk("CHR0", filledBank(chrBankSize, 2)),
+		unifChunk("PRG0", filledBank(prgROMBankSize, 0)),
+		unifChunk("MIRR", []uint8{1}),
+		unifChunk("READ", []uint8("ignored")),
+	)
+	c, err := createUNIFCartridge(rom, newMemoryInit(Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := c.(*mmc1)
+	if !ok {
+		t.Fatalf("cartridge is %T, want *mmc1", c)
+	}
+	if len(m.prgROM) != 2*prgROMBankSize || m.prgROM[0] != 0 || m.prgROM[prgROMBankSize] != 1 {
+		t.Error("PRG chunks were not joined in order")
+	}
+	if len(m.chr) != chrBankSize || m.chr[0] != 2 {
+		t.Error("CHR chunk was not loaded")
+	}
+	if m.mirror != vertical {
+		t.Errorf("mirroring is %d, want vertical", m.mirror)
+	}
+	if len(m.prgRAM) != prgRAMBankSize {
+		t.Errorf("PRG RAM is %d bytes", len(m.prgRAM))
+	}
+}
+
+func TestUNIFErrors(t *testing.T) {
+	prg := unifChunk("PRG0", filledBank(prgROMBankSize, 0))
+	tests := []struct {
+		name string
+		rom  []uint8
+		err  string
+	}{
+		{"truncated header", unifImage()[:unifHeaderSize-1], "header is truncated"},
+		{"truncated chunk header", append(unifImage(), 'M', 'A'), "chunk header is truncated"},
+		{"truncated chunk", unifImage(prg)[:unifHeaderSize+100], "chunk PRG0 is truncated"},
+		{"unknown board", unifImage(unifChunk("MAPR", []uint8("NES-XYZROM")), prg), "unsupported UNIF board"},
+		{"no PRG", unifImage(unifChunk("MAPR", []uint8("NES-NROM-128"))), "no PRG data"},
+		{"four-screen", unifImage(unifChunk("MAPR", []uint8("NES-TLROM")), unifChunk("MIRR", []uint8{4}), prg),
+			"four-screen"},
+		{"mapper mirroring on NROM", unifImage(unifChunk("MAPR", []uint8("NES-NROM-128")), unifChunk("MIRR", []uint8{5}), prg),
+			"mapper-controlled"},
+		{"unknown mirroring", unifImage(unifChunk("MAPR", []uint8("NES-NROM-128")), unifChunk("MIRR", []uint8{6}), prg),
+			"unknown unif mirroring"},
+	}
+	for _, tt := range tests {
+		_, err := createUNIFCartridge(tt.rom, newMemoryInit(Options{}))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	// mirroring is left to boards with mirroring registers
+	rom := unifImage(unifChunk("MAPR", []uint8("NES-SNROM")), unifChunk("MIRR", []uint8{5}), prg)
+	if _, err := createUNIFCartridge(rom, newMemoryInit(Options{})); err != nil {
+		t.Errorf("mapper mirroring on SNROM: %s", err)
+	}
+}
+
+func TestUNIFSxROM(t *testing.T) {
+	// 512 KB of PRG ROM, each bank filled with its number
+	var chunks [][]uint8
+	for i := 0; i < 4; i++ {
+		var prg []uint8
+		for b := 0; b < 8; b++ {
+			prg = append(prg, filledBank(prgROMBankSize, uint8(i*8+b))...)
+		}
+		chunks = append(chunks, unifChunk("PRG"+string(rune('0'+i)), prg))
+	}
+	boards := []struct {
+		name     string
+		ramBanks int
+		ramShift uint // of the PRG RAM bank in the CHR bank register
+	}{
+		{"NES-SOROM", 2, 3},
+		{"NES-SUROM", 1, 0},
+		{"NES-SXROM", 4, 2},
+	}
+	for _, tt := range boards {
+		board := tt.name
+		rom := unifImage(append(chunks, unifChunk("MAPR", []uint8(board)))...)
+		c, err := createUNIFCartridge(rom, newMemoryInit(Options{}))
+		if err != nil {
+			t.Fatalf("%s: %s", board, err)
+		}
+		m := c.(*mmc1)
+
+		// CHR bank bit 4 selects the upper 256 KB, including its last bank
+		m.writePRGBankRegister(0x03)
+		m.writeCHRLowBankRegister(0x10)
+		low, _ := m.read(0x8000)
+		high, _ := m.read(0xc000)
+		if low != 19 || high != 31 {
+			t.Errorf("%s: upper half banks are %d and %d, want 19 and 31", board, low, high)
+		}
+		m.writeCHRLowBankRegister(0x00)
+		low, _ = m.read(0x8000)
+		high, _ = m.read(0xc000)
+		if low != 3 || high != 15 {
+			t.Errorf("%s: lower half banks are %d and %d, want 3 and 15", board, low, high)
+		}
+
+		// each PRG RAM bank holds its own value
+		if len(m.prgRAM) != tt.ramBanks*prgRAMBankSize {
+			t.Fatalf("%s: PRG RAM is %d bytes", board, len(m.prgRAM))
+		}
+		for b := 0; b < tt.ramBanks; b++ {
+			m.writeCHRLowBankRegister(uint8(b) << tt.ramShift)
+			m.write(0x6000, uint8(0x40+b))
+		}
+		for b := 0; b < tt.ramBanks; b++ {
+			if v := m.prgRAM[b*prgRAMBankSize]; v != uint8(0x40+b) {
+				t.Errorf("%s: PRG RAM bank %d holds $%02x", board, b, v)
+			}
+		}
+	}
+}
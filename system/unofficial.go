@@ -0,0 +1,203 @@
+package system
+
+// Unofficial opcodes are mostly combinations of two official instructions
+// that share an opcode's decoding lines. Read-modify-write combinations make
+// the same dummy write as their official counterparts.
+
+// xaaMagic is ORed into the accumulator by XAA. The value varies between
+// consoles; 0xee is the most common.
+const xaaMagic = 0xee
+
+func alr(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := bus.read(a)
+	if err != nil {
+		return err
+	}
+	c.a &= v
+	return lsrAcc(c, bus, a)
+}
+
+func anc(c *cpu, bus memoryDevice, a uint16) error {
+	err := and(c, bus, a)
+	c.setFlagValue(flagCarry, c.isFlagSet(flagSign))
+	return err
+}
+
+func arr(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := bus.read(a)
+	if err != nil {
+		return err
+	}
+	c.a &= v
+	err = rorAcc(c, bus, a)
+	c.setFlagValue(flagCarry, (c.a&0x40) != 0)
+	c.setFlagValue(flagOverflow, ((c.a>>6)^(c.a>>5))&0x1 != 0)
+	return err
+}
+
+func axs(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := bus.read(a)
+	if err != nil {
+		return err
+	}
+	r := c.a & c.x
+	c.setFlagValue(flagCarry, v <= r)
+	c.x = r - v
+	c.setSignFlag(c.x)
+	c.setZeroFlag(c.x)
+	return nil
+}
+
+func dcp(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := readModifyWrite(c, bus, a)
+	if err != nil {
+		return err
+	}
+	v--
+	c.compare(c.a, v)
+	return bus.write(a, v)
+}
+
+func isc(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := readModifyWrite(c, bus, a)
+	if err != nil {
+		return err
+	}
+	v++
+	c.subtractWithBorrow(v)
+	return bus.write(a, v)
+}
+
+// kil halts the CPU until it is reset.
+func kil(c *cpu, bus memoryDevice, a uint16) error {
+	c.pc--
+	c.halted = true
+	return nil
+}
+
+func las(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := bus.read(a)
+	if err != nil {
+		return err
+	}
+	c.sp &= v
+	c.a = c.sp
+	c.x = c.sp
+	c.setSignFlag(c.a)
+	c.setZeroFlag(c.a)
+	return nil
+}
+
+func lax(c *cpu, bus memoryDevice, a uint16) error {
+	err := lda(c, bus, a)
+	c.x = c.a
+	return err
+}
+
+// nopRead is a NOP that reads its operand, with the side effects of the read.
+func nopRead(c *cpu, bus memoryDevice, a uint16) error {
+	_, err := bus.read(a)
+	return err
+}
+
+func rla(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := readModifyWrite(c, bus, a)
+	if err != nil {
+		return err
+	}
+	carry := (v & 0x80) != 0
+	v <<= 1
+	if c.isFlagSet(flagCarry) {
+		v |= 0x01
+	}
+	c.setFlagValue(flagCarry, carry)
+	c.a &= v
+	c.setSignFlag(c.a)
+	c.setZeroFlag(c.a)
+	return bus.write(a, v)
+}
+
+func rra(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := readModifyWrite(c, bus, a)
+	if err != nil {
+		return err
+	}
+	carry := (v & 0x01) != 0
+	v >>= 1
+	if c.isFlagSet(flagCarry) {
+		v |= 0x80
+	}
+	c.setFlagValue(flagCarry, carry)
+	c.addWithCarry(v)
+	return bus.write(a, v)
+}
+
+func sax(c *cpu, bus memoryDevice, a uint16) error {
+	return bus.write(a, c.a&c.x)
+}
+
+func slo(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := readModifyWrite(c, bus, a)
+	if err != nil {
+		return err
+	}
+	c.setFlagValue(flagCarry, (v&0x80) != 0)
+	v <<= 1
+	c.a |= v
+	c.setSignFlag(c.a)
+	c.setZeroFlag(c.a)
+	return bus.write(a, v)
+}
+
+func sre(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := readModifyWrite(c, bus, a)
+	if err != nil {
+		return err
+	}
+	c.setFlagValue(flagCarry, (v&0x01) != 0)
+	v >>= 1
+	c.a ^= v
+	c.setSignFlag(c.a)
+	c.setZeroFlag(c.a)
+	return bus.write(a, v)
+}
+
+func sha(c *cpu, bus memoryDevice, a uint16) error {
+	return storeHighAnd(bus, a, a-uint16(c.y), c.a&c.x)
+}
+
+func shx(c *cpu, bus memoryDevice, a uint16) error {
+	return storeHighAnd(bus, a, a-uint16(c.y), c.x)
+}
+
+func shy(c *cpu, bus memoryDevice, a uint16) error {
+	return storeHighAnd(bus, a, a-uint16(c.x), c.y)
+}
+
+func tas(c *cpu, bus memoryDevice, a uint16) error {
+	c.sp = c.a & c.x
+	return storeHighAnd(bus, a, a-uint16(c.y), c.sp)
+}
+
+func xaa(c *cpu, bus memoryDevice, a uint16) error {
+	v, err := bus.read(a)
+	if err != nil {
+		return err
+	}
+	c.a = (c.a | xaaMagic) & c.x & v
+	c.setSignFlag(c.a)
+	c.setZeroFlag(c.a)
+	return nil
+}
+
+// storeHighAnd implements the SHA family of stores, which write a register
+// ANDed with the high byte of the base address plus one. When indexing
+// crosses a page, the stored value also replaces the high byte of the
+// target address.
+func storeHighAnd(bus memoryDevice, a, base uint16, r uint8) error {
+	v := r & (uint8(base>>8) + 1)
+	if pageCrossed(a, base) {
+		a = (uint16(v) << 8) | (a & 0xff)
+	}
+	return bus.write(a, v)
+}
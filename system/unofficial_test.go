@@ -0,0 +1,198 @@
+package system
+
+import "testing"
+
+// opcodeRegs are the registers before or after an opcode test.
+type opcodeRegs struct {
+	a, x, y, p, sp uint8
+}
+
+func TestUnofficialOpcodes(t *testing.T) {
+	// pointers used by the indirect cases
+	pointers := map[uint16]uint8{0x24: 0x00, 0x25: 0x03, 0x30: 0xf0, 0x31: 0x02, 0x40: 0x00, 0x41: 0x03}
+
+	tests := []struct {
+		name    string
+		code    []uint8
+		before  opcodeRegs
+		mem     map[uint16]uint8
+		after   opcodeRegs
+		written map[uint16]uint8
+		cycles  uint64
+	}{
+		// SAX stores A & X without changing flags
+		{"SAX zp", []uint8{0x87, 0x10}, opcodeRegs{a: 0xf0, x: 0x3c, p: 0x24}, nil,
+			opcodeRegs{a: 0xf0, x: 0x3c, p: 0x24}, map[uint16]uint8{0x10: 0x30}, 3},
+		{"SAX zp,Y", []uint8{0x97, 0x10}, opcodeRegs{a: 0x81, x: 0x83, y: 0x05, p: 0xa6}, nil,
+			opcodeRegs{a: 0x81, x: 0x83, y: 0x05, p: 0xa6}, map[uint16]uint8{0x15: 0x81}, 4},
+		{"SAX (zp,X)", []uint8{0x83, 0x20}, opcodeRegs{a: 0xff, x: 0x04, p: 0x24}, nil,
+			opcodeRegs{a: 0xff, x: 0x04, p: 0x24}, map[uint16]uint8{0x0300: 0x04}, 6},
+
+		// LAX loads A and X
+		{"LAX zp", []uint8{0xa7, 0x10}, opcodeRegs{p: 0x24}, map[uint16]uint8{0x10: 0x80},
+			opcodeRegs{a: 0x80, x: 0x80, p: 0xa4}, nil, 3},
+		{"LAX abs,Y page cross", []uint8{0xbf, 0xf0, 0x02}, opcodeRegs{a: 0x12, y: 0x20, p: 0x24}, map[uint16]uint8{0x0310: 0x00},
+			opcodeRegs{y: 0x20, p: 0x26}, nil, 5},
+		{"LAX (zp),Y", []uint8{0xb3, 0x40}, opcodeRegs{y: 0x10, p: 0x24}, map[uint16]uint8{0x0310: 0x7f},
+			opcodeRegs{a: 0x7f, x: 0x7f, y: 0x10, p: 0x24}, nil, 5},
+		{"LAX (zp),Y page cross", []uint8{0xb3, 0x30}, opcodeRegs{y: 0x20, p: 0x24}, map[uint16]uint8{0x0310: 0x7f},
+			opcodeRegs{a: 0x7f, x: 0x7f, y: 0x20, p: 0x24}, nil, 6},
+		{"LAX imm", []uint8{0xab, 0x55}, opcodeRegs{a: 0xff, p: 0xa6}, nil,
+			opcodeRegs{a: 0x55, x: 0x55, p: 0x24}, nil, 2},
+
+		// DCP decrements memory, then compares it with A
+		{"DCP zp", []uint8{0xc7, 0x10}, opcodeRegs{a: 0x40, p: 0x24}, map[uint16]uint8{0x10: 0x41},
+			opcodeRegs{a: 0x40, p: 0x27}, map[uint16]uint8{0x10: 0x40}, 5},
+		{"DCP abs,X", []uint8{0xdf, 0x00, 0x03}, opcodeRegs{a: 0x10, x: 0x01, p: 0x25}, map[uint16]uint8{0x0301: 0x00},
+			opcodeRegs{a: 0x10, x: 0x01, p: 0x24}, map[uint16]uint8{0x0301: 0xff}, 7},
+		{"DCP (zp),Y page cross", []uint8{0xd3, 0x30}, opcodeRegs{a: 0x80, y: 0x20, p: 0x24}, map[uint16]uint8{0x0310: 0x01},
+			opcodeRegs{a: 0x80, y: 0x20, p: 0xa5}, map[uint16]uint8{0x0310: 0x00}, 8},
+
+		// ISC increments memory, then subtracts it from A
+		{"ISC zp", []uint8{0xe7, 0x10}, opcodeRegs{a: 0x50, p: 0x25}, map[uint16]uint8{0x10: 0x0f},
+			opcodeRegs{a: 0x40, p: 0x25}, map[uint16]uint8{0x10: 0x10}, 5},
+		{"ISC overflow", []uint8{0xe7, 0x10}, opcodeRegs{a: 0x80, p: 0x25}, map[uint16]uint8{0x10: 0x00},
+			opcodeRegs{a: 0x7f, p: 0x65}, map[uint16]uint8{0x10: 0x01}, 5},
+		{"ISC (zp),Y page cross", []uint8{0xf3, 0x30}, opcodeRegs{a: 0x00, y: 0x20, p: 0x25}, map[uint16]uint8{0x0310: 0xff},
+			opcodeRegs{a: 0x00, y: 0x20, p: 0x27}, map[uint16]uint8{0x0310: 0x00}, 8},
+		{"ISC abs,Y", []uint8{0xfb, 0x00, 0x03}, opcodeRegs{a: 0x00, y: 0x02, p: 0x24}, map[uint16]uint8{0x0302: 0x00},
+			opcodeRegs{a: 0xfe, y: 0x02, p: 0xa4}, map[uint16]uint8{0x0302: 0x01}, 7},
+
+		// SLO shifts memory left, then ORs it into A
+		{"SLO zp", []uint8{0x07, 0x10}, opcodeRegs{a: 0x02, p: 0x24}, map[uint16]uint8{0x10: 0x81},
+			opcodeRegs{a: 0x02, p: 0x25}, map[uint16]uint8{0x10: 0x02}, 5},
+		{"SLO abs", []uint8{0x0f, 0x00, 0x03}, opcodeRegs{p: 0x27}, map[uint16]uint8{0x0300: 0x40},
+			opcodeRegs{a: 0x80, p: 0xa4}, map[uint16]uint8{0x0300: 0x80}, 6},
+		{"SLO (zp,X)", []uint8{0x03, 0x20}, opcodeRegs{x: 0x04, p: 0x24}, map[uint16]uint8{0x0300: 0x00},
+			opcodeRegs{x: 0x04, p: 0x26}, map[uint16]uint8{0x0300: 0x00}, 8},
+
+		// RLA rotates memory left, then ANDs it into A
+		{"RLA zp carry in", []uint8{0x27, 0x10}, opcodeRegs{a: 0xff, p: 0x25}, map[uint16]uint8{0x10: 0x80},
+			opcodeRegs{a: 0x01, p: 0x25}, map[uint16]uint8{0x10: 0x01}, 5},
+		{"RLA zp zero", []uint8{0x27, 0x10}, opcodeRegs{a: 0x01, p: 0x24}, map[uint16]uint8{0x10: 0x40},
+			opcodeRegs{a: 0x00, p: 0x26}, map[uint16]uint8{0x10: 0x80}, 5},
+		{"RLA abs,X", []uint8{0x3f, 0xf0, 0x02}, opcodeRegs{a: 0xff, x: 0x20, p: 0x24}, map[uint16]uint8{0x0310: 0xc0},
+			opcodeRegs{a: 0x80, x: 0x20, p: 0xa5}, map[uint16]uint8{0x0310: 0x80}, 7},
+
+		// SRE shifts memory right, then EORs it into A
+		{"SRE zp", []uint8{0x47, 0x10}, opcodeRegs{a: 0x01, p: 0x24}, map[uint16]uint8{0x10: 0x03},
+			opcodeRegs{a: 0x00, p: 0x27}, map[uint16]uint8{0x10: 0x01}, 5},
+		{"SRE (zp,X)", []uint8{0x43, 0x20}, opcodeRegs{a: 0xc0, x: 0x04, p: 0x25}, map[uint16]uint8{0x0300: 0x80},
+			opcodeRegs{a: 0x80, x: 0x04, p: 0xa4}, map[uint16]uint8{0x0300: 0x40}, 8},
+
+		// RRA rotates memory right, then adds it to A with the rotated-out
+		// carry
+		{"RRA zp", []uint8{0x67, 0x10}, opcodeRegs{a: 0x01, p: 0x25}, map[uint16]uint8{0x10: 0x02},
+			opcodeRegs{a: 0x82, p: 0xa4}, map[uint16]uint8{0x10: 0x81}, 5},
+		{"RRA carry out", []uint8{0x67, 0x10}, opcodeRegs{a: 0xff, p: 0x24}, map[uint16]uint8{0x10: 0x01},
+			opcodeRegs{a: 0x00, p: 0x27}, map[uint16]uint8{0x10: 0x00}, 5},
+		{"RRA overflow", []uint8{0x67, 0x10}, opcodeRegs{a: 0x7f, p: 0x24}, map[uint16]uint8{0x10: 0x02},
+			opcodeRegs{a: 0x80, p: 0xe4}, map[uint16]uint8{0x10: 0x01}, 5},
+		{"RRA abs,Y page cross", []uint8{0x7b, 0xf0, 0x02}, opcodeRegs{a: 0x00, y: 0x20, p: 0x24}, map[uint16]uint8{0x0310: 0x04},
+			opcodeRegs{a: 0x02, y: 0x20, p: 0x24}, map[uint16]uint8{0x0310: 0x02}, 7},
+
+		// immediate combinations
+		{"ANC sets C from N", []uint8{0x0b, 0x80}, opcodeRegs{a: 0xff, p: 0x24}, nil,
+			opcodeRegs{a: 0x80, p: 0xa5}, nil, 2},
+		{"ANC clears C", []uint8{0x2b, 0x7f}, opcodeRegs{a: 0xff, p: 0x25}, nil,
+			opcodeRegs{a: 0x7f, p: 0x24}, nil, 2},
+		{"ALR", []uint8{0x4b, 0x03}, opcodeRegs{a: 0x07, p: 0x24}, nil,
+			opcodeRegs{a: 0x01, p: 0x25}, nil, 2},
+		{"ARR C from bit 6", []uint8{0x6b, 0xff}, opcodeRegs{a: 0xc0, p: 0x24}, nil,
+			opcodeRegs{a: 0x60, p: 0x25}, nil, 2},
+		{"ARR V from bits 6 and 5", []uint8{0x6b, 0xff}, opcodeRegs{a: 0x80, p: 0x25}, nil,
+			opcodeRegs{a: 0xc0, p: 0xe5}, nil, 2},
+		{"AXS", []uint8{0xcb, 0x02}, opcodeRegs{a: 0x0f, x: 0x05, p: 0x24}, nil,
+			opcodeRegs{a: 0x0f, x: 0x03, p: 0x25}, nil, 2},
+		{"AXS borrow", []uint8{0xcb, 0x10}, opcodeRegs{a: 0xff, x: 0x05, p: 0x25}, nil,
+			opcodeRegs{a: 0xff, x: 0xf5, p: 0xa4}, nil, 2},
+		{"SBC imm", []uint8{0xeb, 0x01}, opcodeRegs{a: 0x05, p: 0x25}, nil,
+			opcodeRegs{a: 0x04, p: 0x25}, nil, 2},
+
+		// unstable opcodes, with the behavior emulated
+		{"XAA", []uint8{0x8b, 0xff}, opcodeRegs{a: 0x11, x: 0xf0, p: 0x24}, nil,
+			opcodeRegs{a: 0xf0, x: 0xf0, p: 0xa4}, nil, 2},
+		{"LAS", []uint8{0xbb, 0xf0, 0x02}, opcodeRegs{y: 0x20, p: 0x24, sp: 0xf3}, map[uint16]uint8{0x0310: 0x3c},
+			opcodeRegs{a: 0x30, x: 0x30, y: 0x20, p: 0x24, sp: 0x30}, nil, 5},
+		{"SHA abs,Y", []uint8{0x9f, 0x00, 0x03}, opcodeRegs{a: 0xff, x: 0x0f, y: 0x10, p: 0x24}, nil,
+			opcodeRegs{a: 0xff, x: 0x0f, y: 0x10, p: 0x24}, map[uint16]uint8{0x0310: 0x04}, 5},
+		{"SHA abs,Y page cross", []uint8{0x9f, 0xf0, 0x02}, opcodeRegs{a: 0x01, x: 0xff, y: 0x20, p: 0x24}, nil,
+			opcodeRegs{a: 0x01, x: 0xff, y: 0x20, p: 0x24}, map[uint16]uint8{0x0110: 0x01, 0x0310: 0x00}, 5},
+		{"SHA (zp),Y", []uint8{0x93, 0x40}, opcodeRegs{a: 0xff, x: 0xff, y: 0x05, p: 0x24}, nil,
+			opcodeRegs{a: 0xff, x: 0xff, y: 0x05, p: 0x24}, map[uint16]uint8{0x0305: 0x04}, 6},
+		{"SHX", []uint8{0x9e, 0x00, 0x03}, opcodeRegs{x: 0xff, y: 0x01, p: 0x24}, nil,
+			opcodeRegs{x: 0xff, y: 0x01, p: 0x24}, map[uint16]uint8{0x0301: 0x04}, 5},
+		{"SHY", []uint8{0x9c, 0x00, 0x03}, opcodeRegs{x: 0x02, y: 0x07, p: 0x24}, nil,
+			opcodeRegs{x: 0x02, y: 0x07, p: 0x24}, map[uint16]uint8{0x0302: 0x04}, 5},
+		{"TAS", []uint8{0x9b, 0x00, 0x03}, opcodeRegs{a: 0xf7, x: 0x3f, y: 0x03, p: 0x24}, map[uint16]uint8{0x0303: 0xaa},
+			opcodeRegs{a: 0xf7, x: 0x3f, y: 0x03, p: 0x24, sp: 0x37}, map[uint16]uint8{0x0303: 0x04}, 5},
+
+		// NOPs read their operands
+		{"NOP imm", []uint8{0x80, 0x10}, opcodeRegs{p: 0x24}, nil, opcodeRegs{p: 0x24}, nil, 2},
+		{"NOP zp", []uint8{0x04, 0x10}, opcodeRegs{p: 0x24}, nil, opcodeRegs{p: 0x24}, nil, 3},
+		{"NOP abs,X", []uint8{0x3c, 0x00, 0x03}, opcodeRegs{x: 0x20, p: 0x24}, nil, opcodeRegs{x: 0x20, p: 0x24}, nil, 4},
+		{"NOP abs,X page cross", []uint8{0x1c, 0xf0, 0x02}, opcodeRegs{x: 0x20, p: 0x24}, nil, opcodeRegs{x: 0x20, p: 0x24}, nil, 5},
+	}
+
+	for _, tt := range tests {
+		m := &flatRAM{}
+		copy(m[0x0200:], tt.code)
+		for a, v := range pointers {
+			m[a] = v
+		}
+		for a, v := range tt.mem {
+			m[a] = v
+		}
+		c, err := NewCPU(m, CPU2A03)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sp := tt.before.sp
+		if sp == 0 {
+			sp = 0xfd
+		}
+		c.SetState(CPUState{PC: 0x0200, A: tt.before.a, X: tt.before.x, Y: tt.before.y, P: tt.before.p, SP: sp})
+		if err := c.Step(); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		want := CPUState{PC: 0x0200 + uint16(len(tt.code)), A: tt.after.a, X: tt.after.x, Y: tt.after.y,
+			P: tt.after.p, SP: tt.after.sp, Cycles: tt.cycles}
+		if want.SP == 0 {
+			want.SP = 0xfd
+		}
+		if got := c.State(); got != want {
+			t.Errorf("%s: state is %+v, want %+v", tt.name, got, want)
+		}
+		for a, v := range tt.written {
+			if m[a] != v {
+				t.Errorf("%s: $%04x is $%02x, want $%02x", tt.name, a, m[a], v)
+			}
+		}
+	}
+}
+
+func TestKIL(t *testing.T) {
+	m := &flatRAM{}
+	m[0x0200] = 0x02
+	c, err := NewCPU(m, CPU2A03)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetState(CPUState{PC: 0x0200, P: 0x24, SP: 0xfd})
+	for i := 0; i < 3; i++ {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the halted CPU keeps its PC, and the clock runs one cycle per step
+	if s := c.State(); !s.Halted || s.PC != 0x0200 || s.Cycles != 4 {
+		t.Errorf("state after KIL is %+v", s)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if c.State().Halted {
+		t.Error("reset did not clear the halt")
+	}
+}
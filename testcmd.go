@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rhallman96/nesquack/harness"
+	"github.com/rhallman96/nesquack/loader"
+)
+
+// runTests implements the test command, which runs test ROMs headlessly and
+// reports their results. It returns the process exit code.
+func runTests(args []string) int {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	format := flags.String("format", "junit", "report format: junit or json")
+	output := flags.String("o", "", "file to write the report to (defaults to stdout)")
+	timeout := flags.Int("timeout", harness.DefaultTimeout, "frames to run each ROM before it fails")
+	suite := flags.String("suite", "nesquack", "JUnit test suite name")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: nesquack test [flags] rom|directory...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *format != "junit" && *format != "json" {
+		fmt.Println("unknown report format " + *format)
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	roms, err := findROMs(flags.Args())
+	if err != nil {
+		fmt.Println("failed to find roms: " + err.Error())
+		return 2
+	}
+
+	cfg := harness.Config{Timeout: *timeout}
+	results := []harness.Result{}
+	failed := false
+	for _, filename := range roms {
+		var r harness.Result
+		rom, err := loader.Load(filename, "")
+		if err != nil {
+			r = harness.Result{Name: filename, Code: -1, Error: err.Error()}
+		} else {
+			r = harness.Run(filename, rom, cfg)
+		}
+		if !r.Passed {
+			failed = true
+		}
+		fmt.Fprintf(os.Stderr, "%s %s\n", passFail(r), filename)
+		results = append(results, r)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Println("failed to create report: " + err.Error())
+			return 2
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "json" {
+		err = harness.WriteJSON(w, results)
+	} else {
+		err = harness.WriteJUnit(w, *suite, results)
+	}
+	if err != nil {
+		fmt.Println("failed to write report: " + err.Error())
+		return 2
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// findROMs expands directories into the ROM files they contain.
+func findROMs(paths []string) ([]string, error) {
+	var r []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			r = append(r, p)
+			continue
+		}
+		err = filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && (loader.IsROMFile(path) || strings.EqualFold(filepath.Ext(path), ".zip")) {
+				r = append(r, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func passFail(r harness.Result) string {
+	switch {
+	case r.Passed:
+		return "PASS"
+	case r.Error != "":
+		return "ERROR"
+	}
+	return "FAIL"
+}